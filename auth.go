@@ -0,0 +1,210 @@
+package cypress
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/vektra/cypress/keystore"
+)
+
+// When a StreamHeader names an auth key, the receiver sends a
+// challenge of random bytes, the sender answers with a signature of
+// them by the key and the receiver replies with a byte accepting or
+// rejecting the stream. The challenge and response are the frame byte,
+// a uvarint length and the data.
+const (
+	authChallengeByte = 'c'
+	authResponseByte  = 's'
+	authAcceptByte    = 'y'
+	authRejectByte    = 'n'
+)
+
+const (
+	authNonceSize = 32
+
+	// larger than any signature by a supported curve
+	maxAuthFrame = 1024
+)
+
+var (
+	// The stream didn't authenticate and the receiver requires it
+	ErrNotAuthenticated = errors.New("stream is not authenticated")
+
+	// The receiver didn't accept the key the stream authenticated with
+	ErrAuthRejected = errors.New("authentication rejected")
+)
+
+// The tag Recv adds to each message with the id of the key the sender
+// authenticated with
+const AuthKeyTag = "auth_key"
+
+// What the sender signs, binding the challenge to the key id it sent
+func authDigest(nonce []byte, keyID string) []byte {
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write([]byte(keyID))
+
+	return h.Sum(nil)
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func signChallenge(key *ecdsa.PrivateKey, nonce []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, key, authDigest(nonce, keystore.KeyId(&key.PublicKey)))
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ecdsaSignature{r, s})
+}
+
+func verifyChallenge(key *ecdsa.PublicKey, keyID string, nonce, sig []byte) bool {
+	var es ecdsaSignature
+
+	rest, err := asn1.Unmarshal(sig, &es)
+	if err != nil || len(rest) > 0 || es.R == nil || es.S == nil {
+		return false
+	}
+
+	return ecdsa.Verify(key, authDigest(nonce, keyID), es.R, es.S)
+}
+
+func writeAuthFrame(w io.Writer, kind byte, data []byte) error {
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64+len(data))
+	buf[0] = kind
+
+	var tmp [binary.MaxVarintLen64]byte
+
+	cnt := binary.PutUvarint(tmp[:], uint64(len(data)))
+	buf = append(buf, tmp[:cnt]...)
+	buf = append(buf, data...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readAuthFrame(r byteReader, kind byte) ([]byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if b != kind {
+		return nil, ErrStreamUnsynced
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > maxAuthFrame {
+		return nil, ErrStreamUnsynced
+	}
+
+	data := make([]byte, n)
+
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Reads a byte at a time, so nothing past the exchange is consumed
+// before the stream's decoder is setup.
+type unbufferedReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (u *unbufferedReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(u.Reader, u.buf[:])
+	return u.buf[0], err
+}
+
+// Answer the receiver's challenge, called after sending the header
+func (s *Send) authenticate() error {
+	nonce, err := readAuthFrame(s.acks, authChallengeByte)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signChallenge(s.authKey, nonce)
+	if err != nil {
+		return err
+	}
+
+	err = writeAuthFrame(s.rw, authResponseByte, sig)
+	if err != nil {
+		return err
+	}
+
+	b, err := s.acks.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case authAcceptByte:
+		return nil
+	case authRejectByte:
+		return ErrAuthRejected
+	default:
+		return ErrStreamUnsynced
+	}
+}
+
+// Challenge the sender to prove it holds the key named in hdr. If the
+// Recv has no keys, the key can't be checked so the stream is accepted
+// without one.
+func (r *Recv) authenticate(hdr *StreamHeader) error {
+	id := hdr.GetAuthKeyId()
+	if id == "" {
+		return ErrNotAuthenticated
+	}
+
+	nonce := make([]byte, authNonceSize)
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+
+	err = writeAuthFrame(r.rw, authChallengeByte, nonce)
+	if err != nil {
+		return err
+	}
+
+	sig, err := readAuthFrame(&unbufferedReader{Reader: r.rw}, authResponseByte)
+	if err != nil {
+		return err
+	}
+
+	if r.keys != nil {
+		key, err := r.keys.Get(id)
+		if err != nil || keystore.KeyId(key) != id || !verifyChallenge(key, id, nonce, sig) {
+			r.rw.Write([]byte{authRejectByte})
+			return ErrAuthRejected
+		}
+
+		r.keyID = id
+	}
+
+	_, err = r.rw.Write([]byte{authAcceptByte})
+	return err
+}
@@ -0,0 +1,133 @@
+package cypress
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress/keystore"
+	"github.com/vektra/neko"
+)
+
+func TestAuth(t *testing.T) {
+	n := neko.Start(t)
+
+	var (
+		keys   keystore.TestKeys
+		local  net.Conn
+		remote net.Conn
+	)
+
+	n.Setup(func() {
+		keys.Gen()
+		local, remote = net.Pipe()
+	})
+
+	n.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	// Handshake and send m in the background, returning the result of
+	// the handshake
+	sendOne := func(s *Send, m *Message) chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			err := s.SendHandshake()
+			if err == nil {
+				err = s.Receive(m)
+				s.Flush()
+			}
+
+			done <- err
+		}()
+
+		return done
+	}
+
+	n.It("tags messages with the id of the sender's key", func() {
+		r, err := NewAuthRecv(remote, &keys)
+		require.NoError(t, err)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		done := sendOne(NewAuthSend(local, 0, nil, keys.Key), m)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		id := keystore.KeyId(&keys.Key.PublicKey)
+
+		tag, ok := m2.GetTag(AuthKeyTag)
+		require.True(t, ok)
+
+		assert.Equal(t, id, tag)
+		assert.Equal(t, id, r.KeyID())
+	})
+
+	n.It("rejects keys it doesn't know", func() {
+		var other keystore.TestKeys
+		other.Gen()
+
+		r, err := NewAuthRecv(remote, &other)
+		require.NoError(t, err)
+
+		done := sendOne(NewAuthSend(local, 0, nil, keys.Key), Log())
+
+		_, err = r.Generate()
+		assert.Equal(t, ErrAuthRejected, err)
+
+		assert.Equal(t, ErrAuthRejected, <-done)
+	})
+
+	n.It("rejects senders that don't authenticate", func() {
+		r, err := NewAuthRecv(remote, &keys)
+		require.NoError(t, err)
+
+		s := NewSequencedSend(local, 0, nil)
+
+		go s.SendHandshake()
+
+		_, err = r.Generate()
+		assert.Equal(t, ErrNotAuthenticated, err)
+	})
+
+	n.It("accepts authenticated streams without checking if it has no keys", func() {
+		r, err := NewRecv(remote)
+		require.NoError(t, err)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		done := sendOne(NewAuthSend(local, 0, nil, keys.Key), m)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		_, ok := m2.GetTag(AuthKeyTag)
+		assert.False(t, ok)
+	})
+
+	n.It("checks signatures against the challenge", func() {
+		nonce := []byte("challenge")
+
+		sig, err := signChallenge(keys.Key, nonce)
+		require.NoError(t, err)
+
+		id := keystore.KeyId(&keys.Key.PublicKey)
+
+		assert.True(t, verifyChallenge(&keys.Key.PublicKey, id, nonce, sig))
+		assert.False(t, verifyChallenge(&keys.Key.PublicKey, id, []byte("other"), sig))
+		assert.False(t, verifyChallenge(&keys.Key.PublicKey, "other", nonce, sig))
+		assert.False(t, verifyChallenge(&keys.Key.PublicKey, id, nonce, []byte("junk")))
+	})
+
+	n.Meow()
+}
@@ -14,6 +14,7 @@ type LifecycleData struct {
 
 	ranShutdown bool
 	onShutdown  []func()
+	onReload    []func()
 }
 
 var Lifecycle = &LifecycleData{}
@@ -25,6 +26,23 @@ func (l *LifecycleData) OnShutdown(handle func()) {
 	l.onShutdown = append(l.onShutdown, handle)
 }
 
+// Register a function to run when the process receives SIGHUP
+func (l *LifecycleData) OnReload(handle func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.onReload = append(l.onReload, handle)
+}
+
+func (l *LifecycleData) Reload() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, h := range l.onReload {
+		h()
+	}
+}
+
 func (l *LifecycleData) Shutdown(code int) {
 	l.RunCleanup()
 	os.Exit(code)
@@ -58,16 +76,22 @@ func (l *LifecycleData) Start() {
 		pprof.StartCPUProfile(f)
 	}
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
+	signal.Notify(c, syscall.SIGHUP)
 
 	go l.watchTheThrone(c)
 }
 
 func (l *LifecycleData) watchTheThrone(c chan os.Signal) {
-	<-c
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			l.Reload()
+			continue
+		}
 
-	l.Shutdown(0)
+		l.Shutdown(0)
+	}
 }
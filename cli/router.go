@@ -1,18 +1,24 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/vektra/cypress"
 	"github.com/vektra/cypress/router"
+	"github.com/vektra/errors"
 )
 
 type Router struct {
-	ConfigFile string `short:"c" long:"config" description:"path to config file"`
-	Available  bool   `short:"a" long:"available" description:"list all available plugins"`
+	ConfigFile      string        `short:"c" long:"config" description:"path to config file"`
+	Available       bool          `short:"a" long:"available" description:"list all available plugins"`
+	Check           bool          `long:"check" description:"check the config file for problems and exit"`
+	Graph           string        `long:"graph" optional:"yes" optional-value:"dot" description:"print the routes in the config as a graph, in dot or json format, and exit"`
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" default:"10s" description:"how long to wait for messages to drain on shutdown"`
 }
 
 type pluginDescription interface {
@@ -20,21 +26,7 @@ type pluginDescription interface {
 }
 
 func (rt *Router) pluginType(v interface{}) string {
-	var s []string
-
-	if _, ok := v.(cypress.GeneratorPlugin); ok {
-		s = append(s, "input")
-	}
-
-	if _, ok := v.(cypress.ReceiverPlugin); ok {
-		s = append(s, "output")
-	}
-
-	if _, ok := v.(cypress.FiltererPlugin); ok {
-		s = append(s, "filter")
-	}
-
-	return strings.Join(s, ", ")
+	return strings.Join(router.PluginKinds(v), ", ")
 }
 
 func (rt *Router) showAvailable() error {
@@ -96,6 +88,14 @@ func (rt *Router) Execute(args []string) error {
 		return rt.showAvailable()
 	}
 
+	if rt.Check {
+		return rt.check()
+	}
+
+	if rt.Graph != "" {
+		return rt.graph()
+	}
+
 	r := router.NewRouter()
 
 	f, err := os.Open(rt.ConfigFile)
@@ -117,8 +117,22 @@ func (rt *Router) Execute(args []string) error {
 
 	fmt.Printf("Router loaded and running\n%d routes active\n", len(r.Routes()))
 
+	if addr := r.AdminAddr(); addr != nil {
+		fmt.Printf("Admin API listening on %s\n", addr)
+	}
+
+	Lifecycle.OnReload(func() {
+		err := rt.reload(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reloading %s: %s\n", rt.ConfigFile, err)
+			return
+		}
+
+		fmt.Printf("Router reloaded\n%d routes active\n", len(r.Routes()))
+	})
+
 	Lifecycle.OnShutdown(func() {
-		r.Close()
+		rt.reportShutdown(r.Shutdown(rt.ShutdownTimeout))
 	})
 
 	select {}
@@ -126,6 +140,88 @@ func (rt *Router) Execute(args []string) error {
 	return nil
 }
 
+func (rt *Router) check() error {
+	f, err := os.Open(rt.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	problems := router.CheckConfig(f)
+
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", rt.ConfigFile, p)
+	}
+
+	if len(problems) > 0 {
+		return errors.Subject(router.ErrInvalidConfig, fmt.Sprintf("%d problems found", len(problems)))
+	}
+
+	fmt.Printf("%s: config OK\n", rt.ConfigFile)
+
+	return nil
+}
+
+var ErrUnknownGraphFormat = errors.New("unknown graph format")
+
+func (rt *Router) graph() error {
+	r := router.NewRouter()
+
+	f, err := os.Open(rt.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	err = r.LoadConfig(f)
+	if err != nil {
+		return err
+	}
+
+	g := r.Graph()
+
+	switch rt.Graph {
+	case "dot":
+		return g.WriteDOT(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		return enc.Encode(g)
+	default:
+		return errors.Subject(ErrUnknownGraphFormat, rt.Graph)
+	}
+}
+
+func (rt *Router) reload(r *router.Router) error {
+	f, err := os.Open(rt.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return r.Reload(f)
+}
+
+func (rt *Router) reportShutdown(report *router.ShutdownReport) {
+	if report.Clean() {
+		return
+	}
+
+	for _, name := range report.TimedOut {
+		fmt.Fprintf(os.Stderr, "Route %s did not drain before the deadline\n", name)
+	}
+
+	for _, u := range report.Undelivered {
+		fmt.Fprintf(os.Stderr, "%s\n", u)
+	}
+
+	for _, err := range report.Errors {
+		fmt.Fprintf(os.Stderr, "Error shutting down: %s\n", err)
+	}
+}
+
 func init() {
 	addCommand("router", "Route streams", "Route streams based on a config", &Router{})
 }
@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"compress/zlib"
 	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
 )
 
 // No compression is applied
@@ -18,6 +22,103 @@ var SNAPPY = StreamHeader_SNAPPY
 // ZLib compression is used
 var ZLIB = StreamHeader_ZLIB
 
+// Zstandard compression is used
+var ZSTD = StreamHeader_ZSTD
+
+// LZ4 compression is used
+var LZ4 = StreamHeader_LZ4
+
+// Find a compression by name, ignoring case
+func ParseCompression(name string) (StreamHeader_Compression, error) {
+	v, ok := StreamHeader_Compression_value[strings.ToUpper(name)]
+	if !ok {
+		return NONE, ErrUnknownCompression
+	}
+
+	return StreamHeader_Compression(v), nil
+}
+
+// Settings for the compressions that take them
+type CompressionOptions struct {
+	// The zstd level, from 1 (fastest) to 22 (smallest). 0 uses zstd's
+	// default.
+	ZstdLevel int
+
+	// A dictionary trained with `zstd --train`. Streams written with a
+	// dictionary can only be read with it, so readers need the same one.
+	ZstdDictionary []byte
+}
+
+// Create CompressionOptions with a zstd level and the dictionary in the
+// file at dictPath, if it's set
+func NewCompressionOptions(zstdLevel int, dictPath string) (*CompressionOptions, error) {
+	opts := &CompressionOptions{ZstdLevel: zstdLevel}
+
+	if dictPath != "" {
+		dict, err := ioutil.ReadFile(dictPath)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.ZstdDictionary = dict
+	}
+
+	// check the dictionary now rather than on the first stream
+	enc, err := zstd.NewWriter(nil, opts.zstdWriterOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	enc.Close()
+
+	return opts, nil
+}
+
+// The compression and it's options for a config's settings: the name of
+// a compression, a zstd level and the path of a zstd dictionary, which
+// may be empty.
+func LoadCompression(name string, zstdLevel int, dictPath string) (StreamHeader_Compression, *CompressionOptions, error) {
+	comp, err := ParseCompression(name)
+	if err != nil {
+		return NONE, nil, err
+	}
+
+	opts, err := NewCompressionOptions(zstdLevel, dictPath)
+	if err != nil {
+		return NONE, nil, err
+	}
+
+	return comp, opts, nil
+}
+
+func (c *CompressionOptions) zstdWriterOptions() []zstd.EOption {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+
+	if c == nil {
+		return opts
+	}
+
+	if c.ZstdLevel != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.ZstdLevel)))
+	}
+
+	if c.ZstdDictionary != nil {
+		opts = append(opts, zstd.WithEncoderDict(c.ZstdDictionary))
+	}
+
+	return opts
+}
+
+func (c *CompressionOptions) zstdReaderOptions() []zstd.DOption {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+
+	if c != nil && c.ZstdDictionary != nil {
+		opts = append(opts, zstd.WithDecoderDicts(c.ZstdDictionary))
+	}
+
+	return opts
+}
+
 const defaultSnappyChunkBuffer = 1024 * 10
 
 type snappyWriter struct {
@@ -61,6 +162,82 @@ func (s *snappyWriter) Close() error {
 	return s.Flush()
 }
 
+// Ends the zstd frame on each flush, so the stream can be read up to
+// it's last flush and appended to later, since frames can be
+// concatenated.
+type zstdWriter struct {
+	w     io.Writer
+	enc   *zstd.Encoder
+	dirty bool
+}
+
+func (z *zstdWriter) Write(data []byte) (int, error) {
+	z.dirty = true
+	return z.enc.Write(data)
+}
+
+func (z *zstdWriter) Flush() error {
+	if !z.dirty {
+		return nil
+	}
+
+	z.dirty = false
+
+	err := z.enc.Close()
+	z.enc.Reset(z.w)
+
+	return err
+}
+
+func (z *zstdWriter) Close() error {
+	return z.Flush()
+}
+
+// Ends the lz4 frame on each flush, for the same reasons as zstdWriter
+type lz4Writer struct {
+	*lz4.Writer
+
+	w     io.Writer
+	dirty bool
+}
+
+func (l *lz4Writer) Write(data []byte) (int, error) {
+	l.dirty = true
+	return l.Writer.Write(data)
+}
+
+func (l *lz4Writer) Flush() error {
+	if !l.dirty {
+		return nil
+	}
+
+	l.dirty = false
+
+	err := l.Writer.Close()
+	l.Writer.Reset(l.w)
+
+	return err
+}
+
+func (l *lz4Writer) Close() error {
+	return l.Flush()
+}
+
+// Returns err from every Write, for when a compressor can't be created
+type errWriter struct {
+	err error
+}
+
+func (e *errWriter) Write(data []byte) (int, error) { return 0, e.err }
+func (e *errWriter) Close() error                   { return e.err }
+
+// Returns err from every Read, for when a decompressor can't be created
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read(data []byte) (int, error) { return 0, e.err }
+
 type bufWriter struct {
 	*bufio.Writer
 }
@@ -71,6 +248,12 @@ func (b *bufWriter) Close() error {
 
 // Given a compression level, return a wrapped Writer
 func WriteCompressed(w io.WriteCloser, comp StreamHeader_Compression) io.WriteCloser {
+	return WriteCompressedOptions(w, comp, nil)
+}
+
+// Given a compression level and it's settings, return a wrapped Writer.
+// opts may be nil to use the defaults.
+func WriteCompressedOptions(w io.WriteCloser, comp StreamHeader_Compression, opts *CompressionOptions) io.WriteCloser {
 	switch comp {
 	case StreamHeader_NONE:
 		return &bufWriter{bufio.NewWriter(w)}
@@ -81,6 +264,15 @@ func WriteCompressed(w io.WriteCloser, comp StreamHeader_Compression) io.WriteCl
 		}
 	case StreamHeader_ZLIB:
 		return zlib.NewWriter(w)
+	case StreamHeader_ZSTD:
+		enc, err := zstd.NewWriter(w, opts.zstdWriterOptions()...)
+		if err != nil {
+			return &errWriter{err}
+		}
+
+		return &zstdWriter{w: w, enc: enc}
+	case StreamHeader_LZ4:
+		return &lz4Writer{Writer: lz4.NewWriter(w), w: w}
 	default:
 		panic("unknown compression requested")
 	}
@@ -88,6 +280,12 @@ func WriteCompressed(w io.WriteCloser, comp StreamHeader_Compression) io.WriteCl
 
 // Given a compression level, return a wrapped Reader
 func ReadCompressed(r io.Reader, comp StreamHeader_Compression) io.Reader {
+	return ReadCompressedOptions(r, comp, nil)
+}
+
+// Given a compression level and it's settings, return a wrapped Reader.
+// opts may be nil to use the defaults.
+func ReadCompressedOptions(r io.Reader, comp StreamHeader_Compression, opts *CompressionOptions) io.Reader {
 	switch comp {
 	case StreamHeader_NONE:
 		return r
@@ -100,6 +298,15 @@ func ReadCompressed(r io.Reader, comp StreamHeader_Compression) io.Reader {
 		}
 
 		return zw
+	case StreamHeader_ZSTD:
+		dec, err := zstd.NewReader(r, opts.zstdReaderOptions()...)
+		if err != nil {
+			return &errReader{err}
+		}
+
+		return dec
+	case StreamHeader_LZ4:
+		return lz4.NewReader(r)
 	default:
 		panic("unknown compression requested")
 	}
@@ -0,0 +1,147 @@
+package cypress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestCompression(t *testing.T) {
+	n := neko.Start(t)
+
+	roundTrip := func(comp StreamHeader_Compression, wopts, ropts *CompressionOptions) (*Message, error) {
+		var buf ByteBuffer
+
+		enc := NewStreamEncoder(&buf)
+		enc.Options = wopts
+
+		err := enc.Init(comp)
+		require.NoError(t, err)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		err = enc.Receive(m)
+		require.NoError(t, err)
+
+		err = enc.Close()
+		require.NoError(t, err)
+
+		dec, err := NewStreamDecoder(&buf)
+		require.NoError(t, err)
+
+		dec.Options = ropts
+
+		m2, err := dec.Generate()
+		if err != nil {
+			return nil, err
+		}
+
+		assert.Equal(t, comp, dec.Header.GetCompression())
+		assert.Equal(t, m, m2)
+
+		return m2, nil
+	}
+
+	n.It("reads back every compression", func() {
+		for _, comp := range []StreamHeader_Compression{NONE, SNAPPY, ZLIB, ZSTD, LZ4} {
+			_, err := roundTrip(comp, nil, nil)
+			require.NoError(t, err, comp.String())
+		}
+	})
+
+	n.It("uses the zstd level", func() {
+		_, err := roundTrip(ZSTD, &CompressionOptions{ZstdLevel: 19}, nil)
+		require.NoError(t, err)
+	})
+
+	n.It("uses a zstd dictionary", func() {
+		opts, err := NewCompressionOptions(3, "testdata/zstd.dict")
+		require.NoError(t, err)
+
+		_, err = roundTrip(ZSTD, opts, opts)
+		require.NoError(t, err)
+
+		_, err = roundTrip(ZSTD, opts, nil)
+		assert.Error(t, err)
+	})
+
+	n.It("rejects files that aren't dictionaries", func() {
+		_, err := NewCompressionOptions(0, "testdata/cypress.toml")
+		assert.Error(t, err)
+	})
+
+	n.It("can append to a zstd stream", func() {
+		tmp, err := ioutil.TempFile("", "compress")
+		require.NoError(t, err)
+
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		m := Log()
+		m.Add("hello", "world")
+
+		enc := NewStreamEncoder(tmp)
+
+		err = enc.Init(ZSTD)
+		require.NoError(t, err)
+
+		err = enc.Receive(m)
+		require.NoError(t, err)
+
+		err = enc.Flush()
+		require.NoError(t, err)
+
+		_, err = tmp.Seek(0, os.SEEK_SET)
+		require.NoError(t, err)
+
+		enc2 := NewStreamEncoder(tmp)
+
+		err = enc2.OpenFile(tmp)
+		require.NoError(t, err)
+
+		err = enc2.Receive(m)
+		require.NoError(t, err)
+
+		err = enc2.Close()
+		require.NoError(t, err)
+
+		_, err = tmp.Seek(0, os.SEEK_SET)
+		require.NoError(t, err)
+
+		dec, err := NewStreamDecoder(tmp)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			m2, err := dec.Generate()
+			require.NoError(t, err)
+
+			assert.Equal(t, m, m2)
+		}
+
+		_, err = dec.Generate()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	n.It("finds compressions by name", func() {
+		comp, err := ParseCompression("zstd")
+		require.NoError(t, err)
+
+		assert.Equal(t, ZSTD, comp)
+
+		comp, err = ParseCompression("LZ4")
+		require.NoError(t, err)
+
+		assert.Equal(t, LZ4, comp)
+
+		_, err = ParseCompression("brotli")
+		assert.Equal(t, ErrUnknownCompression, err)
+	})
+
+	n.Meow()
+}
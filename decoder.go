@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
+	"hash/crc32"
 	"io"
 	"strings"
 )
@@ -19,6 +20,14 @@ type Decoder struct {
 
 	kv *KVParser
 	js *json.Decoder
+
+	// The sequence number of the last message decoded, if the stream
+	// numbers it's messages
+	Sequence uint64
+
+	// How many bytes of the stream the native messages decoded so far
+	// took up
+	Offset int64
 }
 
 // Create a new Decoder reading data from r
@@ -41,7 +50,7 @@ func (d *Decoder) probe() error {
 	}
 
 	switch b {
-	case '+':
+	case '+', '#', '!':
 		d.decoder = decodeNative
 	case '>':
 		d.kv = NewKVParser(d.r)
@@ -75,6 +84,42 @@ func decodeNative(d *Decoder) (*Message, error) {
 		return nil, err
 	}
 
+	read := int64(1)
+
+	if b == '#' {
+		d.Sequence, err = binary.ReadUvarint(d.r)
+		if err != nil {
+			return nil, err
+		}
+
+		read += int64(sovLog(d.Sequence)) + 1
+
+		b, err = d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		sum     [4]byte
+		checked bool
+	)
+
+	if b == '!' {
+		_, err = io.ReadFull(d.r, sum[:])
+		if err != nil {
+			return nil, err
+		}
+
+		b, err = d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		checked = true
+		read += 5
+	}
+
 	if b != '+' {
 		return nil, ErrUnknownStreamType
 	}
@@ -98,6 +143,11 @@ func decodeNative(d *Decoder) (*Message, error) {
 		return nil, err
 	}
 
+	if checked && crc32.Checksum(sbuf, checksumTable) != binary.BigEndian.Uint32(sum[:]) {
+		pbBufPool.Put(buf)
+		return nil, ErrChecksumMismatch
+	}
+
 	m := &Message{Version: DEFAULT_VERSION}
 
 	err = m.Unmarshal(sbuf)
@@ -108,6 +158,8 @@ func decodeNative(d *Decoder) (*Message, error) {
 		return nil, err
 	}
 
+	d.Offset += read + int64(sovLog(dataLen)) + int64(dataLen)
+
 	return m, nil
 }
 
@@ -85,5 +85,45 @@ func TestDecoder(t *testing.T) {
 		assert.Equal(t, str, out)
 	})
 
+	n.It("checks messages that have checksums", func() {
+		buf.Reset()
+
+		enc := NewEncoder(&buf)
+		enc.Checksums = true
+
+		m := Log()
+		m.Add("hello", "world")
+
+		_, err := enc.Encode(m)
+		require.NoError(t, err)
+
+		assert.Equal(t, byte('!'), buf.Bytes()[0])
+
+		m2, err := dec.Decode()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+		assert.Equal(t, int64(6+sovLog(uint64(m.Size()))+m.Size()), dec.Offset)
+	})
+
+	n.It("rejects messages that don't match their checksum", func() {
+		buf.Reset()
+
+		enc := NewEncoder(&buf)
+		enc.Checksums = true
+
+		m := Log()
+		m.Add("hello", "world")
+
+		_, err := enc.Encode(m)
+		require.NoError(t, err)
+
+		data := buf.Bytes()
+		data[len(data)-1] ^= 0xff
+
+		_, err = dec.Decode()
+		assert.Equal(t, ErrChecksumMismatch, err)
+	})
+
 	n.Meow()
 }
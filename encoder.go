@@ -2,6 +2,7 @@ package cypress
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 )
 
@@ -9,8 +10,14 @@ import (
 // and writes them out.
 type Encoder struct {
 	w io.Writer
+
+	// Precede each message with a checksum of it
+	Checksums bool
 }
 
+// The CRC-32 polynomial used for message checksums
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
 // Create an Encoder that will write it's output to w
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
@@ -24,21 +31,33 @@ func (e *Encoder) Encode(m *Message) (uint64, error) {
 
 	buf := pbBufPool.Get().([]byte)
 
-	buf[0] = '+'
-
-	cnt := binary.PutUvarint(buf[1:], uint64(sz))
+	if len(buf) < sz {
+		buf = make([]byte, sz)
+	}
 
-	_, err := e.w.Write(buf[:cnt+1])
+	cnt, err := m.MarshalTo(buf)
 	if err != nil {
 		pbBufPool.Put(buf)
 		return 0, err
 	}
 
-	if len(buf) < sz {
-		buf = make([]byte, sz)
+	var (
+		hdr [binary.MaxVarintLen64 + 6]byte
+		pos int
+	)
+
+	if e.Checksums {
+		hdr[0] = '!'
+		binary.BigEndian.PutUint32(hdr[1:], crc32.Checksum(buf[:cnt], checksumTable))
+		pos = 5
 	}
 
-	cnt, err = m.MarshalTo(buf)
+	hdr[pos] = '+'
+	pos++
+
+	pos += binary.PutUvarint(hdr[pos:], uint64(sz))
+
+	_, err = e.w.Write(hdr[:pos])
 	if err != nil {
 		pbBufPool.Put(buf)
 		return 0, err
@@ -52,9 +71,34 @@ func (e *Encoder) Encode(m *Message) (uint64, error) {
 		return 0, err
 	}
 
+	if e.Checksums {
+		return uint64(sz) + 10, nil
+	}
+
 	return uint64(sz) + 5, nil
 }
 
+// Encode and write a Message, preceded by it's sequence number
+func (e *Encoder) EncodeSequenced(seq uint64, m *Message) (uint64, error) {
+	var buf [binary.MaxVarintLen64 + 1]byte
+
+	buf[0] = '#'
+
+	cnt := binary.PutUvarint(buf[1:], seq)
+
+	_, err := e.w.Write(buf[:cnt+1])
+	if err != nil {
+		return 0, err
+	}
+
+	sz, err := e.Encode(m)
+	if err != nil {
+		return 0, err
+	}
+
+	return sz + uint64(cnt) + 1, nil
+}
+
 // An encoder that writes messages in Key/Value format
 type KVEncoder struct {
 	w io.Writer
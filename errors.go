@@ -8,4 +8,13 @@ var (
 
 	// The system could not deduce the encoding of a stream
 	ErrUnknownStreamType = errors.New("unknown stream type")
+
+	// The stream's encoding doesn't support numbering messages
+	ErrNotSequenced = errors.New("stream can't number messages")
+
+	// The named compression isn't supported
+	ErrUnknownCompression = errors.New("unknown compression")
+
+	// A message didn't match the checksum before it
+	ErrChecksumMismatch = errors.New("message checksum mismatch")
 )
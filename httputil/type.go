@@ -1,9 +1,12 @@
 package httputil
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
+	"github.com/ugorji/go/codec"
 	"github.com/vektra/cypress"
 )
 
@@ -60,3 +63,24 @@ func EstablishHandler(req *http.Request, w http.ResponseWriter) LogHandler {
 
 	return nil
 }
+
+// Indicates the request's Accept header names a format we can't provide
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// Encode v to w using the format named by the request's Accept
+// header, either JSON (the default) or msgpack. If neither is acceptable,
+// nothing is written and ErrNotAcceptable is returned.
+func WriteValue(req *http.Request, w http.ResponseWriter, v interface{}) error {
+	accept := req.Header.Get("Accept")
+
+	switch accept {
+	case "", "*/*", "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(v)
+	case "application/msgpack":
+		w.Header().Set("Content-Type", "application/msgpack")
+		return codec.NewEncoder(w, &msgpack).Encode(v)
+	}
+
+	return ErrNotAcceptable
+}
@@ -2,9 +2,11 @@ package httputil
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 	"github.com/vektra/neko"
 )
 
@@ -74,5 +76,51 @@ func TestContentType(t *testing.T) {
 		require.True(t, ok)
 	})
 
+	n.It("writes values as JSON by default", func() {
+		req, err := http.NewRequest("GET", "/", nil)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+
+		err = WriteValue(req, w, map[string]int{"count": 3})
+		require.NoError(t, err)
+
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.Equal(t, "{\"count\":3}\n", w.Body.String())
+	})
+
+	n.It("writes values as msgpack if asked", func() {
+		req, err := http.NewRequest("GET", "/", nil)
+		require.NoError(t, err)
+
+		req.Header.Add("Accept", "application/msgpack")
+
+		w := httptest.NewRecorder()
+
+		err = WriteValue(req, w, map[string]int{"count": 3})
+		require.NoError(t, err)
+
+		require.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+
+		var out map[string]int
+
+		err = codec.NewDecoder(w.Body, &msgpack).Decode(&out)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, out["count"])
+	})
+
+	n.It("refuses to write values in other formats", func() {
+		req, err := http.NewRequest("GET", "/", nil)
+		require.NoError(t, err)
+
+		req.Header.Add("Accept", TextLogContentType)
+
+		w := httptest.NewRecorder()
+
+		err = WriteValue(req, w, map[string]int{"count": 3})
+		require.Equal(t, ErrNotAcceptable, err)
+	})
+
 	n.Meow()
 }
@@ -39,17 +39,23 @@ const (
 	StreamHeader_NONE   StreamHeader_Compression = 0
 	StreamHeader_SNAPPY StreamHeader_Compression = 1
 	StreamHeader_ZLIB   StreamHeader_Compression = 2
+	StreamHeader_ZSTD   StreamHeader_Compression = 3
+	StreamHeader_LZ4    StreamHeader_Compression = 4
 )
 
 var StreamHeader_Compression_name = map[int32]string{
 	0: "NONE",
 	1: "SNAPPY",
 	2: "ZLIB",
+	3: "ZSTD",
+	4: "LZ4",
 }
 var StreamHeader_Compression_value = map[string]int32{
 	"NONE":   0,
 	"SNAPPY": 1,
 	"ZLIB":   2,
+	"ZSTD":   3,
+	"LZ4":    4,
 }
 
 func (x StreamHeader_Compression) Enum() *StreamHeader_Compression {
@@ -281,6 +287,11 @@ func (m *Message) GetTags() []*Tag {
 type StreamHeader struct {
 	Compression      *StreamHeader_Compression `protobuf:"varint,1,opt,name=compression,enum=cypress.StreamHeader_Compression" json:"compression,omitempty"`
 	Mode             *StreamHeader_Mode        `protobuf:"varint,2,opt,name=mode,enum=cypress.StreamHeader_Mode" json:"mode,omitempty"`
+	Version          *uint32                   `protobuf:"varint,3,opt,name=version" json:"version,omitempty"`
+	SenderId         *string                   `protobuf:"bytes,4,opt,name=sender_id" json:"sender_id,omitempty"`
+	AuthKeyId        *string                   `protobuf:"bytes,5,opt,name=auth_key_id" json:"auth_key_id,omitempty"`
+	OfferCompression []StreamHeader_Compression `protobuf:"varint,6,rep,name=offer_compression,enum=cypress.StreamHeader_Compression" json:"offer_compression,omitempty"`
+	Checksums        *bool                     `protobuf:"varint,7,opt,name=checksums" json:"checksums,omitempty"`
 	XXX_unrecognized []byte                    `json:"-" codec:"-"`
 }
 
@@ -302,6 +313,41 @@ func (m *StreamHeader) GetMode() StreamHeader_Mode {
 	return StreamHeader_RAW
 }
 
+func (m *StreamHeader) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *StreamHeader) GetSenderId() string {
+	if m != nil && m.SenderId != nil {
+		return *m.SenderId
+	}
+	return ""
+}
+
+func (m *StreamHeader) GetAuthKeyId() string {
+	if m != nil && m.AuthKeyId != nil {
+		return *m.AuthKeyId
+	}
+	return ""
+}
+
+func (m *StreamHeader) GetOfferCompression() []StreamHeader_Compression {
+	if m != nil {
+		return m.OfferCompression
+	}
+	return nil
+}
+
+func (m *StreamHeader) GetChecksums() bool {
+	if m != nil && m.Checksums != nil {
+		return *m.Checksums
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterEnum("cypress.StreamHeader_Compression", StreamHeader_Compression_name, StreamHeader_Compression_value)
 	proto.RegisterEnum("cypress.StreamHeader_Mode", StreamHeader_Mode_name, StreamHeader_Mode_value)
@@ -932,6 +978,104 @@ func (m *StreamHeader) Unmarshal(data []byte) error {
 				}
 			}
 			m.Mode = &v
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			var v uint32
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Version = &v
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SenderId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(data[index:postIndex])
+			m.SenderId = &s
+			index = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AuthKeyId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(data[index:postIndex])
+			m.AuthKeyId = &s
+			index = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OfferCompression", wireType)
+			}
+			var v StreamHeader_Compression
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (StreamHeader_Compression(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.OfferCompression = append(m.OfferCompression, v)
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Checksums", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.Checksums = &b
 		default:
 			var sizeOfWire int
 			for {
@@ -1143,6 +1287,25 @@ func (m *StreamHeader) Size() (n int) {
 	if m.Mode != nil {
 		n += 1 + sovLog(uint64(*m.Mode))
 	}
+	if m.Version != nil {
+		n += 1 + sovLog(uint64(*m.Version))
+	}
+	if m.SenderId != nil {
+		l = len(*m.SenderId)
+		n += 1 + l + sovLog(uint64(l))
+	}
+	if m.AuthKeyId != nil {
+		l = len(*m.AuthKeyId)
+		n += 1 + l + sovLog(uint64(l))
+	}
+	if len(m.OfferCompression) > 0 {
+		for _, e := range m.OfferCompression {
+			n += 1 + sovLog(uint64(e))
+		}
+	}
+	if m.Checksums != nil {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1390,6 +1553,40 @@ func (m *StreamHeader) MarshalTo(data []byte) (n int, err error) {
 		i++
 		i = encodeVarintLog(data, i, uint64(*m.Mode))
 	}
+	if m.Version != nil {
+		data[i] = 0x18
+		i++
+		i = encodeVarintLog(data, i, uint64(*m.Version))
+	}
+	if m.SenderId != nil {
+		data[i] = 0x22
+		i++
+		i = encodeVarintLog(data, i, uint64(len(*m.SenderId)))
+		i += copy(data[i:], *m.SenderId)
+	}
+	if m.AuthKeyId != nil {
+		data[i] = 0x2a
+		i++
+		i = encodeVarintLog(data, i, uint64(len(*m.AuthKeyId)))
+		i += copy(data[i:], *m.AuthKeyId)
+	}
+	if len(m.OfferCompression) > 0 {
+		for _, num := range m.OfferCompression {
+			data[i] = 0x30
+			i++
+			i = encodeVarintLog(data, i, uint64(num))
+		}
+	}
+	if m.Checksums != nil {
+		data[i] = 0x38
+		i++
+		if *m.Checksums {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -1885,6 +2082,50 @@ func (this *StreamHeader) VerboseEqual(that interface{}) error {
 	} else if that1.Mode != nil {
 		return fmt.Errorf("Mode this(%v) Not Equal that(%v)", this.Mode, that1.Mode)
 	}
+	if this.Version != nil && that1.Version != nil {
+		if *this.Version != *that1.Version {
+			return fmt.Errorf("Version this(%v) Not Equal that(%v)", *this.Version, *that1.Version)
+		}
+	} else if this.Version != nil {
+		return fmt.Errorf("this.Version == nil && that.Version != nil")
+	} else if that1.Version != nil {
+		return fmt.Errorf("Version this(%v) Not Equal that(%v)", this.Version, that1.Version)
+	}
+	if this.SenderId != nil && that1.SenderId != nil {
+		if *this.SenderId != *that1.SenderId {
+			return fmt.Errorf("SenderId this(%v) Not Equal that(%v)", *this.SenderId, *that1.SenderId)
+		}
+	} else if this.SenderId != nil {
+		return fmt.Errorf("this.SenderId == nil && that.SenderId != nil")
+	} else if that1.SenderId != nil {
+		return fmt.Errorf("SenderId this(%v) Not Equal that(%v)", this.SenderId, that1.SenderId)
+	}
+	if this.AuthKeyId != nil && that1.AuthKeyId != nil {
+		if *this.AuthKeyId != *that1.AuthKeyId {
+			return fmt.Errorf("AuthKeyId this(%v) Not Equal that(%v)", *this.AuthKeyId, *that1.AuthKeyId)
+		}
+	} else if this.AuthKeyId != nil {
+		return fmt.Errorf("this.AuthKeyId == nil && that.AuthKeyId != nil")
+	} else if that1.AuthKeyId != nil {
+		return fmt.Errorf("AuthKeyId this(%v) Not Equal that(%v)", this.AuthKeyId, that1.AuthKeyId)
+	}
+	if len(this.OfferCompression) != len(that1.OfferCompression) {
+		return fmt.Errorf("OfferCompression this(%v) Not Equal that(%v)", len(this.OfferCompression), len(that1.OfferCompression))
+	}
+	for i := range this.OfferCompression {
+		if this.OfferCompression[i] != that1.OfferCompression[i] {
+			return fmt.Errorf("OfferCompression this[%v](%v) Not Equal that[%v](%v)", i, this.OfferCompression[i], i, that1.OfferCompression[i])
+		}
+	}
+	if this.Checksums != nil && that1.Checksums != nil {
+		if *this.Checksums != *that1.Checksums {
+			return fmt.Errorf("Checksums this(%v) Not Equal that(%v)", *this.Checksums, *that1.Checksums)
+		}
+	} else if this.Checksums != nil {
+		return fmt.Errorf("this.Checksums == nil && that.Checksums != nil")
+	} else if that1.Checksums != nil {
+		return fmt.Errorf("Checksums this(%v) Not Equal that(%v)", this.Checksums, that1.Checksums)
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return fmt.Errorf("XXX_unrecognized this(%v) Not Equal that(%v)", this.XXX_unrecognized, that1.XXX_unrecognized)
 	}
@@ -1928,6 +2169,50 @@ func (this *StreamHeader) Equal(that interface{}) bool {
 	} else if that1.Mode != nil {
 		return false
 	}
+	if this.Version != nil && that1.Version != nil {
+		if *this.Version != *that1.Version {
+			return false
+		}
+	} else if this.Version != nil {
+		return false
+	} else if that1.Version != nil {
+		return false
+	}
+	if this.SenderId != nil && that1.SenderId != nil {
+		if *this.SenderId != *that1.SenderId {
+			return false
+		}
+	} else if this.SenderId != nil {
+		return false
+	} else if that1.SenderId != nil {
+		return false
+	}
+	if this.AuthKeyId != nil && that1.AuthKeyId != nil {
+		if *this.AuthKeyId != *that1.AuthKeyId {
+			return false
+		}
+	} else if this.AuthKeyId != nil {
+		return false
+	} else if that1.AuthKeyId != nil {
+		return false
+	}
+	if len(this.OfferCompression) != len(that1.OfferCompression) {
+		return false
+	}
+	for i := range this.OfferCompression {
+		if this.OfferCompression[i] != that1.OfferCompression[i] {
+			return false
+		}
+	}
+	if this.Checksums != nil && that1.Checksums != nil {
+		if *this.Checksums != *that1.Checksums {
+			return false
+		}
+	} else if this.Checksums != nil {
+		return false
+	} else if that1.Checksums != nil {
+		return false
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return false
 	}
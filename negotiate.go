@@ -0,0 +1,118 @@
+package cypress
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// When a StreamHeader offers compressions, the receiver replies with the
+// pick frame byte and a uvarint of the compression it picked. Both sides
+// then use it for the rest of the stream.
+const negotiatePickByte = 'z'
+
+// The compressions Recv accepts when none are set
+var SupportedCompressions = []StreamHeader_Compression{NONE, SNAPPY, ZLIB, ZSTD, LZ4}
+
+// Parse a comma separated list of compression names, such as
+// "zstd,snappy". An empty string returns no compressions.
+func ParseCompressions(list string) ([]StreamHeader_Compression, error) {
+	var comps []StreamHeader_Compression
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		comp, err := ParseCompression(name)
+		if err != nil {
+			return nil, err
+		}
+
+		comps = append(comps, comp)
+	}
+
+	return comps, nil
+}
+
+// Pick the first of offers that's in accepted, or NONE if there are
+// none in common.
+func pickCompression(offers, accepted []StreamHeader_Compression) StreamHeader_Compression {
+	for _, offer := range offers {
+		for _, comp := range accepted {
+			if offer == comp {
+				return offer
+			}
+		}
+	}
+
+	return NONE
+}
+
+func writePickFrame(w io.Writer, comp StreamHeader_Compression) error {
+	var buf [1 + binary.MaxVarintLen64]byte
+
+	buf[0] = negotiatePickByte
+	cnt := binary.PutUvarint(buf[1:], uint64(comp))
+
+	_, err := w.Write(buf[:1+cnt])
+	return err
+}
+
+func readPickFrame(r byteReader) (StreamHeader_Compression, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return NONE, err
+	}
+
+	if b != negotiatePickByte {
+		return NONE, ErrStreamUnsynced
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return NONE, err
+	}
+
+	return StreamHeader_Compression(n), nil
+}
+
+// Read the compression the receiver picked from the offers, called
+// after sending the header and authenticating.
+func (s *Send) negotiate() (StreamHeader_Compression, error) {
+	comp, err := readPickFrame(s.acks)
+	if err != nil {
+		return NONE, err
+	}
+
+	// NONE is always allowed, it's the answer when nothing matches
+	if comp == NONE {
+		return comp, nil
+	}
+
+	for _, offer := range s.offers {
+		if comp == offer {
+			return comp, nil
+		}
+	}
+
+	return NONE, ErrStreamUnsynced
+}
+
+// Pick a compression from the ones hdr offers and tell the sender.
+func (r *Recv) negotiate(hdr *StreamHeader) (StreamHeader_Compression, error) {
+	accepted := r.accepted
+	if accepted == nil {
+		accepted = SupportedCompressions
+	}
+
+	comp := pickCompression(hdr.GetOfferCompression(), accepted)
+
+	err := writePickFrame(r.rw, comp)
+	if err != nil {
+		return NONE, err
+	}
+
+	return comp, nil
+}
@@ -0,0 +1,182 @@
+package cypress
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress/keystore"
+	"github.com/vektra/neko"
+)
+
+func TestNegotiate(t *testing.T) {
+	n := neko.Start(t)
+
+	var (
+		local  net.Conn
+		remote net.Conn
+	)
+
+	n.Setup(func() {
+		local, remote = net.Pipe()
+	})
+
+	n.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	// Handshake and send m in the background, returning the result of
+	// the handshake
+	sendOne := func(s *Send, m *Message) chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			err := s.SendHandshake()
+			if err == nil {
+				err = s.Receive(m)
+				s.Flush()
+			}
+
+			done <- err
+		}()
+
+		return done
+	}
+
+	offer := func(comps ...StreamHeader_Compression) *Send {
+		return NewSendOptions(local, 0, SendOptions{Compressions: comps})
+	}
+
+	n.It("uses the first offer the receiver accepts", func() {
+		r, err := NewRecv(remote)
+		require.NoError(t, err)
+
+		r.SetAcceptedCompressions([]StreamHeader_Compression{SNAPPY, ZLIB})
+
+		s := offer(ZSTD, ZLIB, SNAPPY)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		done := sendOne(s, m)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		assert.Equal(t, m, m2)
+		assert.Equal(t, ZLIB, r.header().GetCompression())
+		assert.Equal(t, ZLIB, s.compression)
+	})
+
+	n.It("picks from all supported compressions by default", func() {
+		r, err := NewRecv(remote)
+		require.NoError(t, err)
+
+		s := offer(ZSTD, SNAPPY)
+
+		done := sendOne(s, Log())
+
+		_, err = r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		assert.Equal(t, ZSTD, r.header().GetCompression())
+	})
+
+	n.It("falls back to no compression", func() {
+		r, err := NewRecv(remote)
+		require.NoError(t, err)
+
+		r.SetAcceptedCompressions([]StreamHeader_Compression{SNAPPY})
+
+		s := offer(ZSTD)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		done := sendOne(s, m)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		assert.Equal(t, m, m2)
+		assert.Equal(t, NONE, r.header().GetCompression())
+	})
+
+	n.It("negotiates after authenticating", func() {
+		var keys keystore.TestKeys
+		keys.Gen()
+
+		r, err := NewAuthRecv(remote, &keys)
+		require.NoError(t, err)
+
+		s := NewSendOptions(local, 0, SendOptions{
+			AuthKey:      keys.Key,
+			Compressions: []StreamHeader_Compression{LZ4},
+		})
+
+		m := Log()
+		m.Add("hello", "world")
+
+		done := sendOne(s, m)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+
+		assert.Equal(t, LZ4, r.header().GetCompression())
+		assert.Equal(t, keystore.KeyId(&keys.Key.PublicKey), r.KeyID())
+
+		tag, ok := m2.GetTag(AuthKeyTag)
+		require.True(t, ok)
+
+		assert.Equal(t, r.KeyID(), tag)
+	})
+
+	n.It("rejects picks that weren't offered", func() {
+		s := offer(SNAPPY)
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- s.SendHandshake()
+		}()
+
+		probe := NewProbe(remote)
+
+		err := probe.Probe()
+		require.NoError(t, err)
+
+		assert.Equal(t, []StreamHeader_Compression{SNAPPY}, probe.Header.GetOfferCompression())
+
+		err = writePickFrame(remote, ZSTD)
+		require.NoError(t, err)
+
+		assert.Equal(t, ErrStreamUnsynced, <-done)
+	})
+
+	n.It("parses lists of compressions", func() {
+		comps, err := ParseCompressions("zstd, snappy,none")
+		require.NoError(t, err)
+
+		assert.Equal(t, []StreamHeader_Compression{ZSTD, SNAPPY, NONE}, comps)
+
+		comps, err = ParseCompressions("")
+		require.NoError(t, err)
+
+		assert.Nil(t, comps)
+
+		_, err = ParseCompressions("zstd,brotli")
+		assert.Equal(t, ErrUnknownCompression, err)
+	})
+
+	n.Meow()
+}
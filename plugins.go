@@ -2,6 +2,7 @@ package cypress
 
 import (
 	"errors"
+	"io"
 	"sort"
 	"strings"
 )
@@ -79,7 +80,12 @@ func (t *TestPlugin) Generator() (Generator, error) {
 }
 
 func (t *TestPlugin) Generate() (*Message, error) {
-	return <-t.Messages, nil
+	m, ok := <-t.Messages
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return m, nil
 }
 
 func (t *TestPlugin) Receiver() (Receiver, error) {
@@ -1,8 +1,10 @@
 package plugins
 
 import (
+	_ "github.com/vektra/cypress/plugins/datagram"
 	_ "github.com/vektra/cypress/plugins/elasticsearch"
 	_ "github.com/vektra/cypress/plugins/file"
+	_ "github.com/vektra/cypress/plugins/filter"
 	_ "github.com/vektra/cypress/plugins/geoip"
 	_ "github.com/vektra/cypress/plugins/grep"
 	_ "github.com/vektra/cypress/plugins/json"
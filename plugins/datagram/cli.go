@@ -0,0 +1,69 @@
+package datagram
+
+import (
+	"os"
+	"time"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/cli/commands"
+)
+
+type CLISend struct {
+	UDP   string `short:"u" long:"udp" description:"udp host:port to send to"`
+	Dgram string `short:"d" long:"dgram" description:"unix datagram path to send to"`
+
+	MaxPacket     int           `long:"max-packet" description:"largest packet to send, default 1400 for udp and 16384 for unix datagrams"`
+	FlushInterval time.Duration `long:"flush-interval" default:"100ms" description:"how long a message waits to be batched with others"`
+}
+
+// The network and address from the flags
+func flagAddr(udp, dgram string) (string, string, error) {
+	p := Plugin{UDP: udp, Dgram: dgram}
+	return p.addr()
+}
+
+func (c *CLISend) Execute(args []string) error {
+	network, addr, err := flagAddr(c.UDP, c.Dgram)
+	if err != nil {
+		return err
+	}
+
+	s, err := NewSendOptions(network, addr, SendOptions{
+		MaxPacket:     c.MaxPacket,
+		FlushInterval: c.FlushInterval,
+	})
+	if err != nil {
+		return err
+	}
+
+	dec, err := cypress.NewStreamDecoder(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	return cypress.Glue(dec, s)
+}
+
+type CLIRecv struct {
+	UDP   string `short:"u" long:"udp" description:"udp host:port to listen on"`
+	Dgram string `short:"d" long:"dgram" description:"unix datagram path to listen on"`
+}
+
+func (c *CLIRecv) Execute(args []string) error {
+	network, addr, err := flagAddr(c.UDP, c.Dgram)
+	if err != nil {
+		return err
+	}
+
+	r, err := NewRecv(network, addr)
+	if err != nil {
+		return err
+	}
+
+	return cypress.Glue(r, cypress.NewStreamEncoder(os.Stdout))
+}
+
+func init() {
+	commands.Add("datagram:send", "send messages as datagrams", "", &CLISend{})
+	commands.Add("datagram:recv", "receive messages sent as datagrams", "", &CLIRecv{})
+}
@@ -0,0 +1,305 @@
+package datagram
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestDatagram(t *testing.T) {
+	n := neko.Start(t)
+
+	message := func(i int) *cypress.Message {
+		m := cypress.Log()
+		m.Add("index", i)
+
+		return m
+	}
+
+	// Read the packets sent to a raw socket
+	rawListen := func() (net.PacketConn, func() []byte) {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		return c, func() []byte {
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			buf := make([]byte, maxDatagram)
+
+			n, _, err := c.ReadFrom(buf)
+			require.NoError(t, err)
+
+			return buf[:n]
+		}
+	}
+
+	n.It("sends messages over udp", func() {
+		r, err := NewRecv("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		s, err := NewSend("udp", r.Addr().String())
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		var sent []*cypress.Message
+
+		for i := 0; i < 3; i++ {
+			m := message(i)
+
+			err = s.Receive(m)
+			require.NoError(t, err)
+
+			sent = append(sent, m)
+		}
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		for _, m := range sent {
+			m2, err := r.Generate()
+			require.NoError(t, err)
+
+			assert.Equal(t, m, m2)
+		}
+	})
+
+	n.It("sends messages over unix datagrams", func() {
+		dir, err := ioutil.TempDir("", "datagram")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "sock")
+
+		r, err := NewRecv("unixgram", path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		s, err := NewSend("unixgram", path)
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := message(0)
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("packs as many messages as fit into each packet", func() {
+		c, read := rawListen()
+		defer c.Close()
+
+		m := message(0)
+		size := m.Size() + 2
+
+		s, err := NewSendOptions("udp", c.LocalAddr().String(), SendOptions{
+			MaxPacket:     size*2 + 1,
+			FlushInterval: -1,
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		for i := 0; i < 3; i++ {
+			err = s.Receive(message(i))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, len(decodePacket(read())))
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, len(decodePacket(read())))
+	})
+
+	n.It("sends a packet once it's waited the flush interval", func() {
+		c, read := rawListen()
+		defer c.Close()
+
+		s, err := NewSendOptions("udp", c.LocalAddr().String(), SendOptions{
+			FlushInterval: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		err = s.Receive(message(0))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, len(decodePacket(read())))
+	})
+
+	n.It("rejects messages too big for a packet", func() {
+		c, _ := rawListen()
+		defer c.Close()
+
+		s, err := NewSendOptions("udp", c.LocalAddr().String(), SendOptions{MaxPacket: 10})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := message(0)
+		m.Add("padding", "more than ten bytes")
+
+		err = s.Receive(m)
+		assert.Equal(t, ErrMessageTooLarge, err)
+	})
+
+	n.It("drops packets that aren't messages", func() {
+		r, err := NewRecv("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		c, err := net.Dial("udp", r.Addr().String())
+		require.NoError(t, err)
+
+		defer c.Close()
+
+		_, err = c.Write([]byte("<13>not a message"))
+		require.NoError(t, err)
+
+		_, err = c.Write([]byte("+\x7fshort"))
+		require.NoError(t, err)
+
+		s, err := NewSend("udp", r.Addr().String())
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := message(0)
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	// Send a message and read it back from r
+	roundTrip := func(s *Send, r *Recv, i int) {
+		m := message(i)
+
+		err := s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	}
+
+	n.It("keeps sending over unix datagrams when the receiver restarts", func() {
+		dir, err := ioutil.TempDir("", "datagram")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "sock")
+
+		r, err := NewRecv("unixgram", path)
+		require.NoError(t, err)
+
+		s, err := NewSendOptions("unixgram", path, SendOptions{FlushInterval: -1})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		roundTrip(s, r, 0)
+
+		r.Close()
+
+		// nothing is listening, so this is dropped
+		err = s.Receive(message(1))
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), s.Dropped())
+
+		r, err = NewRecv("unixgram", path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		roundTrip(s, r, 2)
+	})
+
+	n.It("keeps sending over udp when the receiver restarts", func() {
+		r, err := NewRecv("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		addr := r.Addr().String()
+
+		s, err := NewSendOptions("udp", addr, SendOptions{FlushInterval: -1})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		roundTrip(s, r, 0)
+
+		r.Close()
+
+		for i := 1; i < 4; i++ {
+			err = s.Receive(message(i))
+			require.NoError(t, err)
+
+			err = s.Flush()
+			require.NoError(t, err)
+		}
+
+		r, err = NewRecv("udp", addr)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		roundTrip(s, r, 4)
+	})
+
+	n.It("returns EOF once closed", func() {
+		r, err := NewRecv("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			r.Close()
+		}()
+
+		_, err = r.Generate()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,65 @@
+package datagram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vektra/cypress"
+)
+
+type Plugin struct {
+	UDP   string `description:"udp host:port to listen on (input) or send to (output)"`
+	Dgram string `description:"unix datagram path to listen on (input) or send to (output)"`
+
+	MaxPacket     int    `toml:"max_packet" description:"largest packet to send, default 1400 for udp and 16384 for unix datagrams (output)"`
+	FlushInterval string `toml:"flush_interval" description:"how long a message waits to be batched with others, default 100ms (output)"`
+}
+
+func (p *Plugin) Description() string {
+	return `Send or receive messages as datagrams, without acks.`
+}
+
+// The network and address to use
+func (p *Plugin) addr() (string, string, error) {
+	switch {
+	case p.UDP != "" && p.Dgram != "":
+		return "", "", fmt.Errorf("specify only one of udp and dgram")
+	case p.UDP != "":
+		return "udp", p.UDP, nil
+	case p.Dgram != "":
+		return "unixgram", p.Dgram, nil
+	default:
+		return "", "", fmt.Errorf("specify udp or dgram")
+	}
+}
+
+func (p *Plugin) Receiver() (cypress.Receiver, error) {
+	network, addr, err := p.addr()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := SendOptions{MaxPacket: p.MaxPacket}
+
+	if p.FlushInterval != "" {
+		opts.FlushInterval, err = time.ParseDuration(p.FlushInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewSendOptions(network, addr, opts)
+}
+
+func (p *Plugin) Generator() (cypress.Generator, error) {
+	network, addr, err := p.addr()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecv(network, addr)
+}
+
+func init() {
+	cypress.AddPlugin("Datagram", func() cypress.Plugin { return &Plugin{} })
+}
@@ -0,0 +1,105 @@
+package datagram
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/vektra/cypress"
+)
+
+// Big enough for any UDP packet
+const maxDatagram = 65536
+
+// Generates the messages in the datagrams sent to an address by Send.
+// Packets that can't be decoded are dropped.
+type Recv struct {
+	conn net.PacketConn
+	buf  []byte
+
+	// messages left from the last packet
+	pending []*cypress.Message
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// Listen for datagrams on addr, network is "udp" or "unixgram"
+func NewRecv(network, addr string) (*Recv, error) {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recv{conn: conn, buf: make([]byte, maxDatagram)}, nil
+}
+
+// The address being listened on
+func (r *Recv) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+func (r *Recv) Generate() (*cypress.Message, error) {
+	for len(r.pending) == 0 {
+		n, _, err := r.conn.ReadFrom(r.buf)
+		if err != nil {
+			r.lock.Lock()
+			closed := r.closed
+			r.lock.Unlock()
+
+			if closed {
+				return nil, io.EOF
+			}
+
+			return nil, err
+		}
+
+		r.pending = decodePacket(r.buf[:n])
+	}
+
+	m := r.pending[0]
+	r.pending = r.pending[1:]
+
+	return m, nil
+}
+
+// The messages in a packet, none if any of it can't be decoded
+func decodePacket(data []byte) []*cypress.Message {
+	// only native messages, not whatever else was sent to the port
+	if len(data) == 0 || data[0] != '+' {
+		return nil
+	}
+
+	dec := cypress.NewDecoder(bytes.NewReader(data))
+
+	var msgs []*cypress.Message
+
+	for {
+		m, err := dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				return msgs
+			}
+
+			return nil
+		}
+
+		msgs = append(msgs, m)
+	}
+}
+
+func (r *Recv) Close() error {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+
+	err := r.conn.Close()
+
+	if addr, ok := r.conn.LocalAddr().(*net.UnixAddr); ok {
+		os.Remove(addr.Name)
+	}
+
+	return err
+}
@@ -0,0 +1,265 @@
+package datagram
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vektra/cypress"
+	"gopkg.in/tomb.v2"
+)
+
+// The largest UDP packet sent by default, small enough to not be
+// fragmented on most networks
+const DefaultUDPPacket = 1400
+
+// The largest unix datagram sent by default
+const DefaultUnixPacket = 16384
+
+// How long a message waits to be batched with others by default
+const DefaultFlushInterval = 100 * time.Millisecond
+
+// The message doesn't fit in a packet on it's own
+var ErrMessageTooLarge = errors.New("message too large for a datagram")
+
+// Sends messages as datagrams without acks or retries. Messages are
+// encoded in the native format and as many as fit are packed into each
+// packet. A packet is sent when the next message wouldn't fit or the
+// oldest message in it has waited FlushInterval. Packets that can't be
+// sent are dropped and counted rather than returned as errors.
+type Send struct {
+	network string
+	addr    string
+
+	// UDP packets are sent from an unconnected socket, so errors from
+	// packets the receiver wasn't there for aren't reported on later
+	// ones
+	pconn net.PacketConn
+	to    net.Addr
+
+	// unix datagrams are sent over a connection, redialed after a
+	// failed write in case the receiver was restarted
+	conn net.Conn
+
+	maxPacket int
+	interval  time.Duration
+
+	dropped uint64
+
+	lock  sync.Mutex
+	buf   bytes.Buffer
+	msg   bytes.Buffer
+	enc   *cypress.Encoder
+	since time.Time
+
+	t tomb.Tomb
+}
+
+// Optional behavior of a Send
+type SendOptions struct {
+	// The largest packet to send, the default for the network if 0
+	MaxPacket int
+
+	// How long a message waits to be batched, DefaultFlushInterval if 0.
+	// If negative, messages are only sent when a packet is full or on
+	// Flush.
+	FlushInterval time.Duration
+}
+
+// Create a Send to addr on network, "udp" or "unixgram"
+func NewSend(network, addr string) (*Send, error) {
+	return NewSendOptions(network, addr, SendOptions{})
+}
+
+// Create a Send to addr on network with the behavior in opts
+func NewSendOptions(network, addr string, opts SendOptions) (*Send, error) {
+	if opts.MaxPacket == 0 {
+		opts.MaxPacket = DefaultUDPPacket
+
+		if network == "unixgram" {
+			opts.MaxPacket = DefaultUnixPacket
+		}
+	}
+
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	s := &Send{
+		network:   network,
+		addr:      addr,
+		maxPacket: opts.MaxPacket,
+		interval:  opts.FlushInterval,
+	}
+
+	if network == "unixgram" {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		s.conn = conn
+	} else {
+		to, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		pconn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		s.pconn = pconn
+		s.to = to
+	}
+
+	s.enc = cypress.NewEncoder(&s.msg)
+
+	if s.interval > 0 {
+		s.t.Go(s.flusher)
+	}
+
+	return s, nil
+}
+
+// Add m to the current packet, sending it first if m doesn't fit
+func (s *Send) Receive(m *cypress.Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.msg.Reset()
+
+	_, err := s.enc.Encode(m)
+	if err != nil {
+		return err
+	}
+
+	if s.msg.Len() > s.maxPacket {
+		return ErrMessageTooLarge
+	}
+
+	if s.buf.Len()+s.msg.Len() > s.maxPacket {
+		s.send()
+	}
+
+	if s.buf.Len() == 0 {
+		s.since = time.Now()
+	}
+
+	s.buf.Write(s.msg.Bytes())
+
+	return nil
+}
+
+// Send the current packet, dropping it if it can't be. Called with the
+// lock held.
+func (s *Send) send() {
+	if s.buf.Len() == 0 {
+		return
+	}
+
+	err := s.write(s.buf.Bytes())
+	if err != nil {
+		s.dropped++
+	}
+
+	s.buf.Reset()
+}
+
+func (s *Send) write(data []byte) error {
+	if s.pconn != nil {
+		_, err := s.pconn.WriteTo(data, s.to)
+		return err
+	}
+
+	if s.conn != nil {
+		_, err := s.conn.Write(data)
+		if err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	// the receiver may have been restarted, so connect to it's new
+	// socket and try again
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+
+	_, err = conn.Write(data)
+	if err != nil {
+		conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
+
+// Send the current packet now
+func (s *Send) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.send()
+
+	return nil
+}
+
+// How many packets have been dropped because they couldn't be sent
+func (s *Send) Dropped() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.dropped
+}
+
+func (s *Send) flusher() error {
+	tick := time.NewTicker(s.interval / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			s.lock.Lock()
+
+			if s.buf.Len() > 0 && time.Since(s.since) >= s.interval {
+				s.send()
+			}
+
+			s.lock.Unlock()
+		case <-s.t.Dying():
+			return nil
+		}
+	}
+}
+
+// Send the current packet and close the socket
+func (s *Send) Close() error {
+	if s.interval > 0 {
+		s.t.Kill(nil)
+		s.t.Wait()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.send()
+
+	if s.pconn != nil {
+		return s.pconn.Close()
+	}
+
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package filter
+
+import (
+	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/cli/commands"
+)
+
+type Filter struct {
+	Query string `short:"q" long:"query" description:"query messages must match to pass through"`
+}
+
+func (f *Filter) Description() string {
+	return `Pass through messages that match a query, eg. severity <= 3 and host =~ web`
+}
+
+func (f *Filter) Filterer() (cypress.Filterer, error) {
+	return cypress.ParseQuery(f.Query)
+}
+
+func (f *Filter) Execute(args []string) error {
+	q, err := cypress.ParseQuery(f.Query)
+	if err != nil {
+		return err
+	}
+
+	return cypress.StandardStreamFilter(q)
+}
+
+func init() {
+	commands.Add("filter", "filter messages using a query", "", &Filter{})
+	cypress.AddPlugin("filter", func() cypress.Plugin { return &Filter{} })
+}
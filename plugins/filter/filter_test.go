@@ -0,0 +1,48 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestFilter(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("passes through messages that match the query", func() {
+		f := &Filter{Query: `severity <= 3 and host =~ web`}
+
+		filt, err := f.Filterer()
+		require.NoError(t, err)
+
+		m := cypress.Log()
+		m.Add("severity", 2)
+		m.Add("host", "web01")
+
+		m2 := cypress.Log()
+		m2.Add("severity", 2)
+		m2.Add("host", "db01")
+
+		m3, err := filt.Filter(m)
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m3)
+
+		m4, err := filt.Filter(m2)
+		require.NoError(t, err)
+
+		assert.Nil(t, m4)
+	})
+
+	n.It("errors on an invalid query", func() {
+		f := &Filter{Query: `severity <=`}
+
+		_, err := f.Filterer()
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
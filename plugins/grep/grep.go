@@ -11,8 +11,10 @@ import (
 type Grep struct {
 	Field   string `short:"f" long:"field" description:"field to match against"`
 	Pattern string `short:"p" long:"pattern" description:"regexp pattern to match value against"`
+	Query   string `short:"q" long:"query" description:"match messages against a query instead of a field"`
 
 	regexp *regexp.Regexp
+	query  *cypress.Query
 }
 
 func (g *Grep) Description() string {
@@ -20,13 +22,8 @@ func (g *Grep) Description() string {
 }
 
 func (g *Grep) Filter(m *cypress.Message) (*cypress.Message, error) {
-	if g.regexp == nil {
-		reg, err := regexp.Compile(g.Pattern)
-		if err != nil {
-			return nil, err
-		}
-
-		g.regexp = reg
+	if g.query != nil {
+		return g.query.Filter(m)
 	}
 
 	if f, ok := m.Get(g.Field); ok {
@@ -46,12 +43,40 @@ func (g *Grep) Filter(m *cypress.Message) (*cypress.Message, error) {
 	return nil, nil
 }
 
+// Parse the query or compile the pattern once, so a bad one is reported
+// when the filter is created rather than on the first message, and the
+// filter is safe to use from several workers at once
 func (g *Grep) Filterer() (cypress.Filterer, error) {
-	return g, nil
+	f := *g
+
+	if g.Query != "" {
+		q, err := cypress.ParseQuery(g.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		f.query = q
+
+		return &f, nil
+	}
+
+	reg, err := regexp.Compile(g.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	f.regexp = reg
+
+	return &f, nil
 }
 
 func (g *Grep) Execute(args []string) error {
-	return cypress.StandardStreamFilter(g)
+	f, err := g.Filterer()
+	if err != nil {
+		return err
+	}
+
+	return cypress.StandardStreamFilter(f)
 }
 
 func init() {
@@ -1,6 +1,7 @@
 package grep
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,7 +18,8 @@ func TestGrep(t *testing.T) {
 	n.CheckMock(&mr.Mock)
 
 	n.It("filters messages that match the pattern through", func() {
-		grep := &Grep{Field: "message", Pattern: "wo"}
+		grep, err := (&Grep{Field: "message", Pattern: "wo"}).Filterer()
+		require.NoError(t, err)
 
 		m := cypress.Log()
 		m.Add("message", "hello world")
@@ -37,7 +39,8 @@ func TestGrep(t *testing.T) {
 	})
 
 	n.It("can match a numeric field", func() {
-		grep := &Grep{Field: "age", Pattern: "35"}
+		grep, err := (&Grep{Field: "age", Pattern: "35"}).Filterer()
+		require.NoError(t, err)
 
 		m := cypress.Log()
 		m.Add("age", 35)
@@ -48,5 +51,58 @@ func TestGrep(t *testing.T) {
 		assert.Equal(t, m, m2)
 	})
 
+	n.It("can match messages against a query", func() {
+		grep, err := (&Grep{Query: `severity <= 3 and host =~ web`}).Filterer()
+		require.NoError(t, err)
+
+		m := cypress.Log()
+		m.Add("severity", 3)
+		m.Add("host", "web01")
+
+		m2, err := grep.Filter(m)
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+
+		m3, err := grep.Filter(cypress.Log())
+		require.NoError(t, err)
+
+		assert.Nil(t, m3)
+	})
+
+	n.It("reports a bad query when the filter is created", func() {
+		_, err := (&Grep{Query: `severity <=`}).Filterer()
+		assert.Error(t, err)
+	})
+
+	n.It("reports a bad pattern when the filter is created", func() {
+		_, err := (&Grep{Field: "message", Pattern: "("}).Filterer()
+		assert.Error(t, err)
+	})
+
+	n.It("can be used from several goroutines at once", func() {
+		grep, err := (&Grep{Field: "message", Pattern: "wo"}).Filterer()
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				m := cypress.Log()
+				m.Add("message", "hello world")
+
+				m2, err := grep.Filter(m)
+				assert.NoError(t, err)
+				assert.Equal(t, m, m2)
+			}()
+		}
+
+		wg.Wait()
+	})
+
 	n.Meow()
 }
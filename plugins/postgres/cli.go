@@ -70,6 +70,8 @@ type Recv struct {
 	Order          string
 	Limit          uint
 
+	Query string `short:"q" long:"query" description:"only output messages matching this query"`
+
 	BufferSize int `long:"buffersize" default:"100"`
 }
 
@@ -82,6 +84,7 @@ func (g *Recv) Execute(args []string) error {
 		SessionId: g.SessionId,
 		Order:     g.Order,
 		Limit:     g.Limit,
+		Query:     g.Query,
 	}
 
 	db, err := sql.Open("postgres",
@@ -132,6 +135,8 @@ type Plugin struct {
 	Order          string
 	Limit          uint
 
+	Query string
+
 	BufferSize int
 }
 
@@ -163,6 +168,7 @@ func (p *Plugin) Generator() (cypress.Generator, error) {
 		SessionId: p.SessionId,
 		Order:     p.Order,
 		Limit:     p.Limit,
+		Query:     p.Query,
 	}
 
 	db, err := sql.Open("postgres",
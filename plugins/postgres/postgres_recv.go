@@ -34,6 +34,8 @@ type PostgresRecv struct {
 	Options       *Options
 	BufferSize    int
 	MessageBuffer chan *cypress.Message
+
+	query *cypress.Query
 }
 
 type Options struct {
@@ -48,15 +50,29 @@ type Options struct {
 	TagValue       string
 	Order          string
 	Limit          uint
+
+	// Messages that don't match this query are skipped
+	Query string
 }
 
 func NewPostgresRecv(postgres *Postgres, options *Options, bufferSize int) (*PostgresRecv, error) {
-	return &PostgresRecv{
+	pr := &PostgresRecv{
 		Postgres:      postgres,
 		Options:       options,
 		BufferSize:    bufferSize,
 		MessageBuffer: make(chan *cypress.Message, bufferSize),
-	}, nil
+	}
+
+	if options.Query != "" {
+		query, err := cypress.ParseQuery(options.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		pr.query = query
+	}
+
+	return pr, nil
 }
 
 func (pr *PostgresRecv) BuildStmt(o *Options) string {
@@ -179,6 +195,11 @@ func (pr *PostgresRecv) Search(o *Options) ([]*cypress.Message, error) {
 
 func (pr *PostgresRecv) BufferMessages(messages []*cypress.Message) error {
 	for _, message := range messages {
+		if pr.query != nil && !pr.query.Match(message) {
+			pr.Options.Start = message.GetTimestamp().Time().Format(time.RFC3339)
+			continue
+		}
+
 		select {
 
 		case pr.MessageBuffer <- message:
@@ -310,3 +310,30 @@ func TestPostgresOnline(t *testing.T) {
 
 	n.Meow()
 }
+
+func TestPostgresRecvQuery(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("only buffers messages matching the query", func() {
+		pr, err := NewPostgresRecv(&Postgres{}, &Options{Query: `severity <= 3`}, 10)
+		require.NoError(t, err)
+
+		m1 := cypress.Log()
+		m1.Add("severity", "2")
+
+		m2 := cypress.Log()
+		m2.Add("severity", "5")
+
+		pr.BufferMessages([]*cypress.Message{m1, m2})
+
+		require.Equal(t, 1, len(pr.MessageBuffer))
+		require.Equal(t, m1, <-pr.MessageBuffer)
+	})
+
+	n.It("rejects an invalid query", func() {
+		_, err := NewPostgresRecv(&Postgres{}, &Options{Query: `severity <=`}, 10)
+		require.Error(t, err)
+	})
+
+	n.Meow()
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/goamz/goamz/aws"
 	"github.com/vektra/cypress"
 	"github.com/vektra/cypress/cli/commands"
+	"github.com/vektra/cypress/plugins/spool"
 	"github.com/vektra/errors"
 )
 
@@ -17,6 +18,10 @@ type Send struct {
 
 	ACL    string `long:"acl" description:"ACL to apply to data"`
 	Region string `short:"r" long:"region" description:"AWS region to use"`
+
+	Compression    string `short:"c" long:"compression" default:"snappy" description:"none, snappy, zlib, zstd or lz4"`
+	ZstdLevel      int    `long:"zstd-level" description:"zstd level from 1 (fastest) to 22 (smallest)"`
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of a dictionary trained with zstd --train"`
 }
 
 func (s *Send) Execute(args []string) error {
@@ -39,7 +44,19 @@ func (s *Send) Execute(args []string) error {
 		}
 	}
 
-	r, err := NewS3(s.Dir, s.Bucket, S3Params{ACL: acl, Auth: auth, Region: region})
+	comp, opts, err := cypress.LoadCompression(s.Compression, s.ZstdLevel, s.ZstdDictionary)
+	if err != nil {
+		return err
+	}
+
+	params := S3Params{
+		ACL:    acl,
+		Auth:   auth,
+		Region: region,
+		Spool:  &spool.SpoolOptions{Compression: comp, CompressionOptions: opts},
+	}
+
+	r, err := NewS3(s.Dir, s.Bucket, params)
 	if err != nil {
 		return err
 	}
@@ -59,6 +76,8 @@ type Recv struct {
 	Bucket    string `short:"b" long:"bucket" description:"bucket to store data in"`
 
 	Region string `short:"r" long:"region" description:"AWS region to use"`
+
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of the dictionary the streams were written with"`
 }
 
 func (s *Recv) Execute(args []string) error {
@@ -76,12 +95,19 @@ func (s *Recv) Execute(args []string) error {
 		}
 	}
 
+	opts, err := cypress.NewCompressionOptions(0, s.ZstdDictionary)
+	if err != nil {
+		return err
+	}
+
 	enc := cypress.NewStreamEncoder(os.Stdout)
 	gen, err := NewS3Generator(s.Bucket, auth, region)
 	if err != nil {
 		return err
 	}
 
+	gen.CompressionOptions = opts
+
 	return cypress.Glue(gen, enc)
 }
 
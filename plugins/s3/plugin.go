@@ -3,6 +3,7 @@ package s3
 import (
 	"github.com/goamz/goamz/aws"
 	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/plugins/spool"
 	"github.com/vektra/errors"
 )
 
@@ -13,6 +14,24 @@ type S3Plugin struct {
 	Bucket    string `description:"S3 bucket + path to store streams in"`
 	ACL       string `description:"S3 ACL of data written (output only)"`
 	Region    string `description:"AWS region to use"`
+
+	Compression    string `description:"none, snappy, zlib, zstd or lz4, default snappy (output only)"`
+	ZstdLevel      int    `toml:"zstd_level" description:"zstd level from 1 (fastest) to 22 (smallest)"`
+	ZstdDictionary string `toml:"zstd_dictionary" description:"path of a dictionary trained with zstd --train"`
+}
+
+func (s *S3Plugin) spoolOptions() (*spool.SpoolOptions, error) {
+	name := s.Compression
+	if name == "" {
+		name = "snappy"
+	}
+
+	comp, opts, err := cypress.LoadCompression(name, s.ZstdLevel, s.ZstdDictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spool.SpoolOptions{Compression: comp, CompressionOptions: opts}, nil
 }
 
 func (s *S3Plugin) Description() string {
@@ -39,7 +58,12 @@ func (s *S3Plugin) Receiver() (cypress.Receiver, error) {
 		}
 	}
 
-	return NewS3(s.Dir, s.Bucket, S3Params{ACL: acl, Auth: auth, Region: region})
+	opts, err := s.spoolOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS3(s.Dir, s.Bucket, S3Params{ACL: acl, Auth: auth, Region: region, Spool: opts})
 }
 
 func (s *S3Plugin) Generator() (cypress.Generator, error) {
@@ -57,7 +81,19 @@ func (s *S3Plugin) Generator() (cypress.Generator, error) {
 		}
 	}
 
-	return NewS3Generator(s.Bucket, auth, region)
+	opts, err := s.spoolOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	gen, err := NewS3Generator(s.Bucket, auth, region)
+	if err != nil {
+		return nil, err
+	}
+
+	gen.CompressionOptions = opts.CompressionOptions
+
+	return gen, nil
 }
 
 func init() {
@@ -71,6 +71,9 @@ type S3Params struct {
 	ACL    s3.ACL
 	Auth   aws.Auth
 	Region aws.Region
+
+	// How the spooled files sent to S3 are compressed, snappy if nil
+	Spool *spool.SpoolOptions
 }
 
 func (p *S3Params) Client() *s3.S3 {
@@ -91,7 +94,13 @@ func (p *S3Params) S3Config() *S3Config {
 }
 
 func NewS3(dir, bucket string, params S3Params) (*S3, error) {
-	spool, err := spool.NewSpool(dir)
+	opts := spool.SpoolOptions{Compression: cypress.SNAPPY}
+
+	if params.Spool != nil {
+		opts = *params.Spool
+	}
+
+	spool, err := spool.NewSpoolOptions(dir, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -288,6 +297,9 @@ type S3Generator struct {
 	// Indicates if we should process any unsigned logs seen
 	AllowUnsigned bool
 
+	// Settings to read the streams with, such as a zstd dictionary
+	CompressionOptions *cypress.CompressionOptions
+
 	client *s3.S3
 	bucket *s3.Bucket
 
@@ -445,6 +457,8 @@ restart:
 			return nil, err
 		}
 
+		dec.Options = g.CompressionOptions
+
 		g.response = resp
 		g.dec = dec
 	}
@@ -3,6 +3,7 @@ package spool
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/vektra/cypress"
 	"github.com/vektra/cypress/cli/commands"
@@ -10,6 +11,35 @@ import (
 
 type Send struct {
 	Dir string `short:"d" description:"where to write the messages to"`
+
+	MaxFiles int           `long:"max-files" description:"how many rotated files to keep, default 10, -1 for no limit"`
+	MaxBytes int64         `long:"max-bytes" description:"most bytes all the files can use"`
+	MaxAge   time.Duration `long:"max-age" description:"how long to keep rotated files, ie 168h"`
+	MinFree  int64         `long:"min-free" description:"bytes to leave free on the disk"`
+
+	WaitForConsumers bool `long:"wait-for-consumers" description:"keep rotated files until every consumer has read them"`
+
+	Sync         string        `long:"sync" default:"always" description:"when to sync messages to disk: always, count, interval or os"`
+	SyncEvery    int           `long:"sync-every" description:"how many messages to sync after with --sync count, default 100"`
+	SyncInterval time.Duration `long:"sync-interval" description:"how often to sync with --sync interval, default 1s"`
+
+	CompressionFlags
+}
+
+// Compression flags shared by spool:send and spool:recv
+type CompressionFlags struct {
+	Compression    string `short:"c" long:"compression" default:"snappy" description:"none, snappy, zlib, zstd or lz4"`
+	ZstdLevel      int    `long:"zstd-level" description:"zstd level from 1 (fastest) to 22 (smallest)"`
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of a dictionary trained with zstd --train"`
+}
+
+func (c *CompressionFlags) options() (SpoolOptions, error) {
+	comp, opts, err := cypress.LoadCompression(c.Compression, c.ZstdLevel, c.ZstdDictionary)
+	if err != nil {
+		return SpoolOptions{}, err
+	}
+
+	return SpoolOptions{Compression: comp, CompressionOptions: opts}, nil
 }
 
 func (s *Send) Execute(args []string) error {
@@ -21,7 +51,26 @@ func (s *Send) Execute(args []string) error {
 		os.MkdirAll(s.Dir, 0755)
 	}
 
-	spool, err := NewSpool(s.Dir)
+	opts, err := s.options()
+	if err != nil {
+		return err
+	}
+
+	opts.MaxFiles = s.MaxFiles
+	opts.MaxBytes = s.MaxBytes
+	opts.MaxAge = s.MaxAge
+	opts.MinFree = s.MinFree
+	opts.WaitForConsumers = s.WaitForConsumers
+
+	opts.SyncMode, err = ParseSyncMode(s.Sync)
+	if err != nil {
+		return err
+	}
+
+	opts.SyncEvery = s.SyncEvery
+	opts.SyncInterval = s.SyncInterval
+
+	spool, err := NewSpoolOptions(s.Dir, opts)
 	if err != nil {
 		return err
 	}
@@ -35,7 +84,11 @@ func (s *Send) Execute(args []string) error {
 }
 
 type Recv struct {
-	Dir string `short:"d" description:"where to write the messages to"`
+	Dir      string `short:"d" description:"where to write the messages to"`
+	Consumer string `long:"consumer" description:"resume from where this consumer stopped and save it's place"`
+	Follow   bool   `short:"f" long:"follow" description:"wait for more messages once all have been read"`
+
+	CompressionFlags
 }
 
 func (r *Recv) Execute(args []string) error {
@@ -43,18 +96,22 @@ func (r *Recv) Execute(args []string) error {
 		return fmt.Errorf("no target specified")
 	}
 
-	if _, err := os.Stat(r.Dir); err != nil {
+	opts, err := r.options()
+	if err != nil {
 		return err
 	}
 
-	spool, err := NewSpool(r.Dir)
+	spool, err := OpenSpool(r.Dir, opts.CompressionOptions)
 	if err != nil {
 		return err
 	}
 
 	enc := cypress.NewStreamEncoder(os.Stdout)
 
-	gen, err := spool.Generator()
+	gen, err := spool.GeneratorOptions(GeneratorOptions{
+		Consumer: r.Consumer,
+		Follow:   r.Follow,
+	})
 	if err != nil {
 		return err
 	}
@@ -62,7 +119,53 @@ func (r *Recv) Execute(args []string) error {
 	return cypress.Glue(gen, enc)
 }
 
+type Fsck struct {
+	Dir            string `short:"d" description:"the spool to check"`
+	Repair         bool   `short:"r" long:"repair" description:"cut damaged files back to their last good message"`
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of the dictionary the spool was written with"`
+}
+
+func (f *Fsck) Execute(args []string) error {
+	if f.Dir == "" {
+		return fmt.Errorf("no target specified")
+	}
+
+	opts, err := cypress.NewCompressionOptions(0, f.ZstdDictionary)
+	if err != nil {
+		return err
+	}
+
+	results, err := Check(f.Dir, opts, f.Repair)
+	if err != nil {
+		return err
+	}
+
+	damaged := 0
+
+	for _, res := range results {
+		if res.Err == nil {
+			fmt.Printf("%s: %d messages, ok\n", res.Path, res.Messages)
+			continue
+		}
+
+		damaged++
+
+		if res.Repaired {
+			fmt.Printf("%s: %d messages, then %s, repaired\n", res.Path, res.Messages, res.Err)
+		} else {
+			fmt.Printf("%s: %d messages, then %s\n", res.Path, res.Messages, res.Err)
+		}
+	}
+
+	if damaged > 0 && !f.Repair {
+		return fmt.Errorf("%d damaged files, run with --repair to fix", damaged)
+	}
+
+	return nil
+}
+
 func init() {
 	commands.Add("spool:send", "write messages to a spool", "", &Send{})
 	commands.Add("spool:recv", "read messages from a spool", "", &Recv{})
+	commands.Add("spool:fsck", "check a spool for damaged files", "", &Fsck{})
 }
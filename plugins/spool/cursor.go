@@ -0,0 +1,190 @@
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where a named consumer is in a spool. They're saved as JSON in the
+// consumers directory of the spool.
+type Cursor struct {
+	// The label of the rotated file being read, or "current"
+	File string `json:"file"`
+
+	// How much of the file's messages have been read, as counted by
+	// cypress.StreamDecoder.Offset
+	Offset int64 `json:"offset"`
+
+	// When reading the current file, the label of the time it was
+	// opened. If it's been rotated since, it's the first file rotated
+	// after then.
+	Opened string `json:"opened,omitempty"`
+}
+
+const (
+	currentName  = "current"
+	consumersDir = "consumers"
+)
+
+var ErrBadConsumerName = errors.New("consumer names can't be empty or contain /")
+
+// Indicates if the consumer at c has read all of the rotated file label
+func (c *Cursor) passed(label string) bool {
+	if c.File == currentName {
+		return label <= c.Opened
+	}
+
+	return label < c.File
+}
+
+// Find where c is in rotated, the sorted labels of the rotated files
+// opened before the current one. The index is len(rotated) for the
+// current file. If c's file was removed, it's the start of the next one.
+func (c *Cursor) find(rotated []string) (int, int64) {
+	for i, label := range rotated {
+		switch {
+		case c.File == currentName:
+			if label > c.Opened {
+				return i, c.Offset
+			}
+		case label == c.File:
+			return i, c.Offset
+		case label > c.File:
+			return i, 0
+		}
+	}
+
+	if c.File == currentName {
+		return len(rotated), c.Offset
+	}
+
+	return len(rotated), 0
+}
+
+func (s *Spool) cursorPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", ErrBadConsumerName
+	}
+
+	return filepath.Join(s.root, consumersDir, name), nil
+}
+
+// The cursor saved for the consumer name, nil if there isn't one
+func (s *Spool) Cursor(name string) (*Cursor, error) {
+	path, err := s.cursorPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var c Cursor
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Save c as the cursor for the consumer name, replacing the old one
+// atomically so a crash leaves one or the other
+func (s *Spool) saveCursor(name string, c *Cursor) error {
+	path, err := s.cursorPath(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// The names of the consumers with saved cursors
+func (s *Spool) Consumers() ([]string, error) {
+	ents, err := ioutil.ReadDir(filepath.Join(s.root, consumersDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var names []string
+
+	for _, e := range ents {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+
+		names = append(names, e.Name())
+	}
+
+	return names, nil
+}
+
+// Forget the consumer name, so pruning no longer waits for it
+func (s *Spool) RemoveConsumer(name string) error {
+	path, err := s.cursorPath(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// The cursors of every consumer
+func (s *Spool) cursors() ([]*Cursor, error) {
+	names, err := s.Consumers()
+	if err != nil {
+		return nil, err
+	}
+
+	var cursors []*Cursor
+
+	for _, name := range names {
+		c, err := s.Cursor(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil {
+			cursors = append(cursors, c)
+		}
+	}
+
+	return cursors, nil
+}
@@ -0,0 +1,286 @@
+package spool
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestConsumers(t *testing.T) {
+	n := neko.Start(t)
+
+	var (
+		dir string
+		sf  *Spool
+		idx int
+	)
+
+	n.Setup(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+
+		sf, err = NewSpool(dir)
+		require.NoError(t, err)
+
+		idx = 0
+	})
+
+	n.Cleanup(func() {
+		sf.Close()
+		os.RemoveAll(dir)
+	})
+
+	// Write count numbered messages to the spool
+	write := func(count int) {
+		for i := 0; i < count; i++ {
+			m := cypress.Log()
+			m.Add("index", idx)
+			idx++
+
+			err := sf.Receive(m)
+			require.NoError(t, err)
+		}
+
+		err := sf.Flush()
+		require.NoError(t, err)
+	}
+
+	// Read up to count messages as the consumer, returning their indexes
+	read := func(name string, count int) []int64 {
+		gen, err := sf.ConsumerGenerator(name)
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		var indexes []int64
+
+		for len(indexes) < count {
+			m, err := gen.Generate()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+
+			i, ok := m.GetInt("index")
+			require.True(t, ok)
+
+			indexes = append(indexes, i)
+		}
+
+		return indexes
+	}
+
+	n.It("starts new consumers at the beginning", func() {
+		write(2)
+
+		err := sf.Rotate()
+		require.NoError(t, err)
+
+		write(1)
+
+		assert.Equal(t, []int64{0, 1, 2}, read("shipper", 10))
+
+		names, err := sf.Consumers()
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"shipper"}, names)
+	})
+
+	n.It("resumes where the consumer stopped", func() {
+		write(3)
+
+		assert.Equal(t, []int64{0}, read("shipper", 1))
+		assert.Equal(t, []int64{1, 2}, read("shipper", 10))
+		assert.Equal(t, []int64(nil), read("shipper", 10))
+
+		write(1)
+
+		assert.Equal(t, []int64{3}, read("shipper", 10))
+	})
+
+	n.It("keeps each consumer's place separately", func() {
+		write(2)
+
+		assert.Equal(t, []int64{0, 1}, read("shipper", 10))
+		assert.Equal(t, []int64{0}, read("indexer", 1))
+
+		write(1)
+
+		assert.Equal(t, []int64{2}, read("shipper", 10))
+		assert.Equal(t, []int64{1, 2}, read("indexer", 10))
+	})
+
+	n.It("follows the current file when it's rotated", func() {
+		write(2)
+
+		assert.Equal(t, []int64{0}, read("shipper", 1))
+
+		c, err := sf.Cursor("shipper")
+		require.NoError(t, err)
+
+		assert.Equal(t, "current", c.File)
+
+		err = sf.Rotate()
+		require.NoError(t, err)
+
+		write(1)
+
+		err = sf.Rotate()
+		require.NoError(t, err)
+
+		write(1)
+
+		assert.Equal(t, []int64{1, 2, 3}, read("shipper", 10))
+	})
+
+	n.It("skips to the next file when the consumer's was removed", func() {
+		write(2)
+
+		err := sf.Rotate()
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{0}, read("shipper", 1))
+
+		write(1)
+
+		err = sf.Rotate()
+		require.NoError(t, err)
+
+		sf.MaxFiles = 1
+		sf.pruneOldFiles()
+
+		assert.Equal(t, []int64{2}, read("shipper", 10))
+	})
+
+	n.It("waits for consumers before removing files", func() {
+		sf.MaxFiles = 1
+		sf.WaitForConsumers = true
+
+		assert.Equal(t, []int64(nil), read("shipper", 10))
+
+		for i := 0; i < 3; i++ {
+			write(1)
+
+			err := sf.Rotate()
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []int64{0}, read("shipper", 1))
+
+		sf.pruneOldFiles()
+
+		assert.Equal(t, []int64{1, 2}, read("shipper", 10))
+
+		sf.pruneOldFiles()
+
+		ents, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+
+		var files int
+
+		for _, e := range ents {
+			if e.Name() != "current" && e.Name() != consumersDir {
+				files++
+			}
+		}
+
+		assert.Equal(t, 1, files)
+
+		err = sf.RemoveConsumer("shipper")
+		require.NoError(t, err)
+
+		names, err := sf.Consumers()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(names))
+	})
+
+	n.It("rejects names that aren't a single file", func() {
+		for _, name := range []string{"", "..", "a/b"} {
+			_, err := sf.ConsumerGenerator(name)
+			assert.Equal(t, ErrBadConsumerName, err)
+		}
+	})
+
+	n.It("doesn't prune or write a spool opened to read it", func() {
+		sf.MaxFiles = -1
+		sf.WaitForConsumers = true
+
+		assert.Equal(t, []int64(nil), read("shipper", 10))
+
+		for i := 0; i < 15; i++ {
+			write(1)
+
+			err := sf.Rotate()
+			require.NoError(t, err)
+		}
+
+		before, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+
+		ro, err := OpenSpool(dir, nil)
+		require.NoError(t, err)
+
+		defer ro.Close()
+
+		after, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, len(before), len(after))
+
+		gen, err := ro.ConsumerGenerator("shipper")
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		count := 0
+
+		for {
+			_, err := gen.Generate()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+
+			count++
+		}
+
+		assert.Equal(t, 15, count)
+
+		assert.Equal(t, ErrReadOnly, ro.Receive(cypress.Log()))
+	})
+
+	n.It("reads every file without a consumer", func() {
+		write(1)
+
+		assert.Equal(t, []int64{0}, read("shipper", 10))
+
+		_, err := os.Stat(filepath.Join(dir, consumersDir))
+		require.NoError(t, err)
+
+		gen, err := sf.Generator()
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		m, err := gen.Generate()
+		require.NoError(t, err)
+
+		i, ok := m.GetInt("index")
+		require.True(t, ok)
+
+		assert.Equal(t, int64(0), i)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!freebsd,!openbsd,!dragonfly
+
+package spool
+
+import "errors"
+
+var errDiskFreeUnsupported = errors.New("checking free disk space isn't supported")
+
+func diskFree(path string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
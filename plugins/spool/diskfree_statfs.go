@@ -0,0 +1,17 @@
+// +build linux darwin freebsd openbsd dragonfly
+
+package spool
+
+import "syscall"
+
+// The bytes available to unprivileged users on the disk holding path
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,184 @@
+package spool
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/tai64n"
+)
+
+// What checking a spool file found
+type CheckResult struct {
+	// The file's path
+	Path string
+
+	// How many messages could be read
+	Messages int
+
+	// Why the rest of the file couldn't be read, nil if all of it could
+	Err error
+
+	// The file was rewritten with just the messages that could be read
+	Repaired bool
+}
+
+// Read the file at path, stopping at the first message that's cut short,
+// doesn't match it's checksum or otherwise can't be decoded
+func CheckFile(path string, opts *cypress.CompressionOptions) (*CheckResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	res := &CheckResult{Path: path}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		return res, nil
+	}
+
+	dec, err := cypress.NewStreamDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+
+	dec.Options = opts
+
+	for {
+		_, err := dec.Generate()
+		if err != nil {
+			if err != io.EOF {
+				res.Err = err
+			}
+
+			return res, nil
+		}
+
+		res.Messages++
+	}
+}
+
+// Rewrite the file at path with only it's first count messages, replacing
+// it atomically. A file without a readable header is emptied.
+func RepairFile(path string, count int, opts *cypress.CompressionOptions) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	tmp := path + ".repair"
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = copyMessages(in, out, count, opts)
+	if err == nil {
+		err = out.Sync()
+	}
+
+	out.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func copyMessages(in io.Reader, out *os.File, count int, opts *cypress.CompressionOptions) error {
+	dec, err := cypress.NewStreamDecoder(in)
+	if err != nil {
+		return err
+	}
+
+	dec.Options = opts
+
+	err = dec.Probe()
+	if err != nil {
+		// leave the file empty
+		return nil
+	}
+
+	enc := cypress.NewStreamEncoder(out)
+	enc.Options = opts
+
+	err = enc.WriteCustomHeader(dec.Header)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		m, err := dec.Generate()
+		if err != nil {
+			return err
+		}
+
+		err = enc.Receive(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.Close()
+}
+
+// Check every file in the spool in dir, oldest first, repairing the
+// ones that can't be read completely if repair is set. Nothing should
+// be writing to the spool while it's repaired.
+func Check(dir string, opts *cypress.CompressionOptions, repair bool) ([]*CheckResult, error) {
+	ents, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, e := range ents {
+		if tai64n.ParseTAI64NLabel(e.Name()) != nil {
+			names = append(names, e.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	if _, err := os.Stat(filepath.Join(dir, currentName)); err == nil {
+		names = append(names, currentName)
+	}
+
+	var results []*CheckResult
+
+	for _, name := range names {
+		res, err := CheckFile(filepath.Join(dir, name), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if repair && res.Err != nil {
+			err = RepairFile(res.Path, res.Messages, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			res.Repaired = true
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,215 @@
+package spool
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestFsck(t *testing.T) {
+	n := neko.Start(t)
+
+	var dir string
+
+	n.Setup(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+	})
+
+	n.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	// Write count messages to a spool in dir and close it
+	fill := func(comp cypress.StreamHeader_Compression, count int) {
+		sf, err := NewSpoolOptions(dir, SpoolOptions{Compression: comp})
+		require.NoError(t, err)
+
+		for i := 0; i < count; i++ {
+			m := cypress.Log()
+			m.Add("index", i)
+
+			err = sf.Receive(m)
+			require.NoError(t, err)
+		}
+
+		err = sf.Close()
+		require.NoError(t, err)
+	}
+
+	readAll := func(sf *Spool) int {
+		gen, err := sf.Generator()
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		count := 0
+
+		for {
+			_, err := gen.Generate()
+			if err == io.EOF {
+				return count
+			}
+
+			require.NoError(t, err)
+
+			count++
+		}
+	}
+
+	current := func() string {
+		return filepath.Join(dir, "current")
+	}
+
+	appendTo := func(path string, data []byte) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+
+		defer f.Close()
+
+		_, err = f.Write(data)
+		require.NoError(t, err)
+	}
+
+	n.It("writes a checksum before each message", func() {
+		fill(cypress.NONE, 1)
+
+		f, err := os.Open(current())
+		require.NoError(t, err)
+
+		defer f.Close()
+
+		probe := cypress.NewProbe(f)
+
+		err = probe.Probe()
+		require.NoError(t, err)
+
+		assert.True(t, probe.Header.GetChecksums())
+	})
+
+	for _, comp := range []cypress.StreamHeader_Compression{cypress.NONE, cypress.SNAPPY} {
+		comp := comp
+
+		n.It("recovers a "+comp.String()+" current file with a torn write", func() {
+			fill(comp, 3)
+
+			appendTo(current(), []byte{'!', 1, 2, 3, 4, '+', 100, 1, 2})
+
+			res, err := CheckFile(current(), nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, 3, res.Messages)
+			assert.Error(t, res.Err)
+
+			sf, err := NewSpoolOptions(dir, SpoolOptions{Compression: comp})
+			require.NoError(t, err)
+
+			defer sf.Close()
+
+			err = sf.Receive(cypress.Log())
+			require.NoError(t, err)
+
+			assert.Equal(t, 4, readAll(sf))
+		})
+	}
+
+	n.It("leaves a damaged current file alone when opened to read", func() {
+		fill(cypress.NONE, 3)
+
+		appendTo(current(), []byte{'!', 1, 2, 3, 4, '+', 100, 1, 2})
+
+		before, err := os.Stat(current())
+		require.NoError(t, err)
+
+		sf, err := OpenSpool(dir, nil)
+		require.NoError(t, err)
+
+		defer sf.Close()
+
+		after, err := os.Stat(current())
+		require.NoError(t, err)
+
+		assert.True(t, os.SameFile(before, after))
+		assert.Equal(t, before.Size(), after.Size())
+	})
+
+	n.It("empties a current file with a torn header", func() {
+		err := ioutil.WriteFile(current(), []byte{'-', 10, 1}, 0644)
+		require.NoError(t, err)
+
+		sf, err := NewSpool(dir)
+		require.NoError(t, err)
+
+		defer sf.Close()
+
+		err = sf.Receive(cypress.Log())
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, readAll(sf))
+	})
+
+	n.It("finds and repairs damaged files", func() {
+		fill(cypress.NONE, 3)
+
+		// flip a bit in the last message
+		data, err := ioutil.ReadFile(current())
+		require.NoError(t, err)
+
+		data[len(data)-1] ^= 1
+
+		err = ioutil.WriteFile(current(), data, 0644)
+		require.NoError(t, err)
+
+		results, err := Check(dir, nil, false)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(results))
+
+		assert.Equal(t, 2, results[0].Messages)
+		assert.Equal(t, cypress.ErrChecksumMismatch, results[0].Err)
+		assert.False(t, results[0].Repaired)
+
+		results, err = Check(dir, nil, true)
+		require.NoError(t, err)
+
+		assert.True(t, results[0].Repaired)
+
+		results, err = Check(dir, nil, false)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, results[0].Messages)
+		assert.NoError(t, results[0].Err)
+	})
+
+	n.It("checks rotated files too", func() {
+		sf, err := NewSpool(dir)
+		require.NoError(t, err)
+
+		err = sf.Receive(cypress.Log())
+		require.NoError(t, err)
+
+		err = sf.Rotate()
+		require.NoError(t, err)
+
+		err = sf.Close()
+		require.NoError(t, err)
+
+		results, err := Check(dir, nil, false)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(results))
+
+		assert.Equal(t, 1, results[0].Messages)
+		assert.Equal(t, current(), results[1].Path)
+	})
+
+	n.Meow()
+}
@@ -1,22 +1,101 @@
 package spool
 
-import "github.com/vektra/cypress"
+import (
+	"time"
+
+	"github.com/vektra/cypress"
+)
 
 type SpoolPlugin struct {
 	Directory string `description:"directory to read/write messages to"`
+
+	Compression    string `description:"none, snappy, zlib, zstd or lz4, default snappy"`
+	ZstdLevel      int    `toml:"zstd_level" description:"zstd level from 1 (fastest) to 22 (smallest)"`
+	ZstdDictionary string `toml:"zstd_dictionary" description:"path of a dictionary trained with zstd --train"`
+
+	MaxFiles int    `toml:"max_files" description:"how many rotated files to keep, default 10, -1 for no limit"`
+	MaxBytes int64  `toml:"max_bytes" description:"most bytes all the files can use"`
+	MaxAge   string `toml:"max_age" description:"how long to keep rotated files, ie 168h"`
+	MinFree  int64  `toml:"min_free" description:"bytes to leave free on the disk"`
+
+	Consumer         string `description:"name to save the input's place in the spool under"`
+	WaitForConsumers bool   `toml:"wait_for_consumers" description:"keep rotated files until every consumer has read them"`
+	Follow           bool   `description:"wait for more messages once all have been read"`
+
+	Sync         string `description:"when to sync messages to disk: always, count, interval or os, default always"`
+	SyncEvery    int    `toml:"sync_every" description:"how many messages to sync after with count, default 100"`
+	SyncInterval string `toml:"sync_interval" description:"how often to sync with interval, default 1s"`
+}
+
+func (s *SpoolPlugin) options() (SpoolOptions, error) {
+	name := s.Compression
+	if name == "" {
+		name = "snappy"
+	}
+
+	comp, opts, err := cypress.LoadCompression(name, s.ZstdLevel, s.ZstdDictionary)
+	if err != nil {
+		return SpoolOptions{}, err
+	}
+
+	so := SpoolOptions{
+		Compression:        comp,
+		CompressionOptions: opts,
+		MaxFiles:           s.MaxFiles,
+		MaxBytes:           s.MaxBytes,
+		MinFree:            s.MinFree,
+		WaitForConsumers:   s.WaitForConsumers,
+	}
+
+	so.SyncMode, err = ParseSyncMode(s.Sync)
+	if err != nil {
+		return SpoolOptions{}, err
+	}
+
+	so.SyncEvery = s.SyncEvery
+
+	if s.SyncInterval != "" {
+		so.SyncInterval, err = time.ParseDuration(s.SyncInterval)
+		if err != nil {
+			return SpoolOptions{}, err
+		}
+	}
+
+	if s.MaxAge != "" {
+		so.MaxAge, err = time.ParseDuration(s.MaxAge)
+		if err != nil {
+			return SpoolOptions{}, err
+		}
+	}
+
+	return so, nil
 }
 
 func (s *SpoolPlugin) Receiver() (cypress.Receiver, error) {
-	return NewSpool(s.Directory)
+	opts, err := s.options()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSpoolOptions(s.Directory, opts)
 }
 
 func (s *SpoolPlugin) Generator() (cypress.Generator, error) {
-	spool, err := NewSpool(s.Directory)
+	opts, err := s.options()
+	if err != nil {
+		return nil, err
+	}
+
+	// only read the spool, leaving pruning it to the output writing it
+	spool, err := OpenSpool(s.Directory, opts.CompressionOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	return spool.Generator()
+	return spool.GeneratorOptions(GeneratorOptions{
+		Consumer: s.Consumer,
+		Follow:   s.Follow,
+	})
 }
 
 func init() {
@@ -0,0 +1,443 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vektra/cypress"
+)
+
+// A cypress.SendQueue that keeps messages in spool format files until
+// they're acked. Messages are numbered and written to segment files
+// named by the number of their first message. The numbers of acked
+// messages are appended to a matching .acks file, and a segment and it's
+// acks are removed once all of it's messages are acked.
+type Queue struct {
+	dir  string
+	opts QueueOptions
+
+	lock     sync.Mutex
+	segments []*queueSegment
+	nextID   uint64
+	unacked  []*cypress.Message
+
+	// the messages not yet acked, oldest first. A message appended
+	// more than once is in here once per append.
+	queued []queuedMessage
+
+	// the segment being appended to
+	file *os.File
+	enc  *cypress.StreamEncoder
+}
+
+type queueSegment struct {
+	start uint64
+	count uint64
+	acked uint64
+
+	acks *os.File
+}
+
+const (
+	queueSuffix = ".queue"
+	acksSuffix  = ".acks"
+)
+
+// How big a queue segment gets before a new one is started
+const DefaultSegmentSize = PerFileSize
+
+// Optional behavior of a Queue
+type QueueOptions struct {
+	// How big a segment gets before a new one is started,
+	// DefaultSegmentSize if 0
+	SegmentSize int64
+
+	// How segments are compressed
+	Compression cypress.StreamHeader_Compression
+
+	// Settings for the compression, also used to read the segments
+	CompressionOptions *cypress.CompressionOptions
+}
+
+// Open the Queue in dir, uncompressed
+func NewQueue(dir string) (*Queue, error) {
+	return NewQueueOptions(dir, QueueOptions{})
+}
+
+// Open the Queue in dir with the behavior in opts. The messages in dir
+// that weren't acked are returned by Unacked.
+func NewQueueOptions(dir string, opts QueueOptions) (*Queue, error) {
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:  dir,
+		opts: opts,
+	}
+
+	err = q.load()
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+
+	err = q.startSegment()
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *Queue) path(start uint64, suffix string) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016x%s", start, suffix))
+}
+
+// Read the segments in the directory, keeping the messages that
+// weren't acked
+func (q *Queue) load() error {
+	ents, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	var starts []uint64
+
+	for _, e := range ents {
+		if !strings.HasSuffix(e.Name(), queueSuffix) {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), queueSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		starts = append(starts, start)
+	}
+
+	sort.Sort(uint64s(starts))
+
+	for _, start := range starts {
+		seg, msgs, err := q.loadSegment(start)
+		if err != nil {
+			return err
+		}
+
+		if end := seg.start + seg.count; end > q.nextID {
+			q.nextID = end
+		}
+
+		if seg.acked == seg.count {
+			q.removeSegment(seg)
+			continue
+		}
+
+		for _, msg := range msgs {
+			q.queued = append(q.queued, msg)
+			q.unacked = append(q.unacked, msg.m)
+		}
+
+		q.segments = append(q.segments, seg)
+	}
+
+	return nil
+}
+
+type uint64s []uint64
+
+func (u uint64s) Len() int           { return len(u) }
+func (u uint64s) Less(i, j int) bool { return u[i] < u[j] }
+func (u uint64s) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+type queuedMessage struct {
+	id uint64
+	m  *cypress.Message
+}
+
+// Read the segment starting at start, returning the messages that
+// weren't acked in order. A segment cut short by a crash is read
+// up to the last complete message.
+func (q *Queue) loadSegment(start uint64) (*queueSegment, []queuedMessage, error) {
+	acked, err := readAcks(q.path(start, acksSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seg := &queueSegment{start: start}
+	var msgs []queuedMessage
+
+	f, err := os.Open(q.path(start, queueSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	dec, err := cypress.NewStreamDecoder(f)
+	if err == nil {
+		dec.Options = q.opts.CompressionOptions
+
+		for {
+			m, err := dec.Generate()
+			if err != nil {
+				break
+			}
+
+			id := start + seg.count
+			seg.count++
+
+			if acked[id] {
+				seg.acked++
+			} else {
+				msgs = append(msgs, queuedMessage{id, m})
+			}
+		}
+	}
+
+	seg.acks, err = os.OpenFile(q.path(start, acksSuffix), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return seg, msgs, nil
+}
+
+func readAcks(path string) (map[uint64]bool, error) {
+	acked := make(map[uint64]bool)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return acked, nil
+		}
+
+		return nil, err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		id, err := binary.ReadUvarint(r)
+		if err != nil {
+			// a partial number at the end is an ack that didn't
+			// finish being written
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return acked, nil
+			}
+
+			return nil, err
+		}
+
+		acked[id] = true
+	}
+}
+
+// Start a new segment for the messages appended
+func (q *Queue) startSegment() error {
+	if q.enc != nil {
+		q.enc.Close()
+		q.file.Close()
+
+		// it won't get another ack to remove it
+		if cur := q.current(); cur.acked == cur.count {
+			q.removeSegment(cur)
+			q.segments = q.segments[:len(q.segments)-1]
+		}
+	}
+
+	seg := &queueSegment{start: q.nextID}
+
+	f, err := os.OpenFile(q.path(seg.start, queueSuffix), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	seg.acks, err = os.OpenFile(q.path(seg.start, acksSuffix), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	enc := cypress.NewStreamEncoder(f)
+	enc.Options = q.opts.CompressionOptions
+
+	checksums := true
+
+	err = enc.WriteCustomHeader(&cypress.StreamHeader{
+		Compression: q.opts.Compression.Enum(),
+		Checksums:   &checksums,
+	})
+	if err != nil {
+		seg.acks.Close()
+		f.Close()
+		return err
+	}
+
+	q.file = f
+	q.enc = enc
+	q.segments = append(q.segments, seg)
+
+	return nil
+}
+
+func (q *Queue) current() *queueSegment {
+	return q.segments[len(q.segments)-1]
+}
+
+func (q *Queue) removeSegment(seg *queueSegment) {
+	if seg.acks != nil {
+		seg.acks.Close()
+	}
+
+	os.Remove(q.path(seg.start, queueSuffix))
+	os.Remove(q.path(seg.start, acksSuffix))
+}
+
+// The messages in the directory that weren't acked when the Queue was
+// opened, oldest first
+func (q *Queue) Unacked() ([]*cypress.Message, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.unacked, nil
+}
+
+// Write m to the current segment and sync it to disk
+func (q *Queue) Append(m *cypress.Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	err := q.enc.Receive(m)
+	if err != nil {
+		return err
+	}
+
+	err = q.enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = q.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	q.queued = append(q.queued, queuedMessage{q.nextID, m})
+	q.nextID++
+
+	q.current().count++
+
+	if int64(q.enc.EncodedBytes()) >= q.opts.SegmentSize {
+		return q.startSegment()
+	}
+
+	return nil
+}
+
+// Record that m was acked, removing it's segment if every message in it
+// has been. If m was appended more than once the oldest is acked.
+// Messages the Queue doesn't have are ignored.
+func (q *Queue) Ack(m *cypress.Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// acks mostly come in the order messages were sent, so this
+	// usually stops at the first one
+	pos := -1
+
+	for i, qm := range q.queued {
+		if qm.m == m {
+			pos = i
+			break
+		}
+	}
+
+	if pos < 0 {
+		return nil
+	}
+
+	id := q.queued[pos].id
+
+	if pos == 0 {
+		q.queued[0] = queuedMessage{}
+		q.queued = q.queued[1:]
+	} else {
+		q.queued = append(q.queued[:pos], q.queued[pos+1:]...)
+	}
+
+	idx := sort.Search(len(q.segments), func(i int) bool {
+		return q.segments[i].start > id
+	}) - 1
+
+	if idx < 0 {
+		return nil
+	}
+
+	seg := q.segments[idx]
+
+	var buf [binary.MaxVarintLen64]byte
+
+	cnt := binary.PutUvarint(buf[:], id)
+
+	_, err := seg.acks.Write(buf[:cnt])
+	if err != nil {
+		return err
+	}
+
+	seg.acked++
+
+	if seg.acked == seg.count && seg != q.current() {
+		q.removeSegment(seg)
+		q.segments = append(q.segments[:idx], q.segments[idx+1:]...)
+	}
+
+	return nil
+}
+
+// How many messages are stored and not acked
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.queued)
+}
+
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var err error
+
+	if q.enc != nil {
+		err = q.enc.Close()
+		q.file.Close()
+		q.enc = nil
+	}
+
+	for _, seg := range q.segments {
+		if seg.acks != nil {
+			seg.acks.Close()
+		}
+	}
+
+	return err
+}
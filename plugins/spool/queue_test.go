@@ -0,0 +1,271 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestQueue(t *testing.T) {
+	n := neko.Start(t)
+
+	var dir string
+
+	n.Setup(func() {
+		var err error
+
+		dir, err = ioutil.TempDir("", "queue")
+		require.NoError(t, err)
+	})
+
+	n.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	message := func(i int) *cypress.Message {
+		m := cypress.Log()
+		m.Add("index", i)
+
+		return m
+	}
+
+	segments := func() []string {
+		names, err := filepath.Glob(filepath.Join(dir, "*"+queueSuffix))
+		require.NoError(t, err)
+
+		return names
+	}
+
+	n.It("returns the messages that weren't acked after reopening", func() {
+		q, err := NewQueue(dir)
+		require.NoError(t, err)
+
+		var msgs []*cypress.Message
+
+		for i := 0; i < 3; i++ {
+			m := message(i)
+
+			err = q.Append(m)
+			require.NoError(t, err)
+
+			msgs = append(msgs, m)
+		}
+
+		err = q.Ack(msgs[1])
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, q.Len())
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		unacked, err := q.Unacked()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(unacked))
+
+		assert.Equal(t, msgs[0], unacked[0])
+		assert.Equal(t, msgs[2], unacked[1])
+	})
+
+	n.It("tracks acks of the messages it returns", func() {
+		q, err := NewQueue(dir)
+		require.NoError(t, err)
+
+		err = q.Append(message(0))
+		require.NoError(t, err)
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		unacked, err := q.Unacked()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(unacked))
+
+		err = q.Ack(unacked[0])
+		require.NoError(t, err)
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		unacked, err = q.Unacked()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(unacked))
+	})
+
+	n.It("removes segments once every message in them is acked", func() {
+		q, err := NewQueueOptions(dir, QueueOptions{SegmentSize: 1})
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		var msgs []*cypress.Message
+
+		for i := 0; i < 3; i++ {
+			m := message(i)
+
+			err = q.Append(m)
+			require.NoError(t, err)
+
+			msgs = append(msgs, m)
+		}
+
+		assert.Equal(t, 4, len(segments()))
+
+		err = q.Ack(msgs[1])
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, len(segments()))
+
+		err = q.Ack(msgs[0])
+		require.NoError(t, err)
+
+		err = q.Ack(msgs[2])
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, len(segments()))
+	})
+
+	n.It("ignores acks of messages it doesn't have", func() {
+		q, err := NewQueue(dir)
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		err = q.Ack(message(0))
+		assert.NoError(t, err)
+	})
+
+	n.It("stores a message appended twice until both are acked", func() {
+		q, err := NewQueue(dir)
+		require.NoError(t, err)
+
+		m := message(0)
+
+		err = q.Append(m)
+		require.NoError(t, err)
+
+		err = q.Append(m)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, q.Len())
+
+		err = q.Ack(m)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, q.Len())
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		unacked, err := q.Unacked()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(unacked))
+
+		err = q.Ack(unacked[0])
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, q.Len())
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		unacked, err = q.Unacked()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(unacked))
+	})
+
+	n.It("reads up to a message cut short by a crash", func() {
+		q, err := NewQueue(dir)
+		require.NoError(t, err)
+
+		m := message(0)
+
+		err = q.Append(m)
+		require.NoError(t, err)
+
+		err = q.Append(message(1))
+		require.NoError(t, err)
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		name := segments()[0]
+
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+
+		err = os.Truncate(name, fi.Size()-3)
+		require.NoError(t, err)
+
+		q, err = NewQueue(dir)
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		unacked, err := q.Unacked()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(unacked))
+
+		assert.Equal(t, m, unacked[0])
+	})
+
+	n.It("compresses segments", func() {
+		q, err := NewQueueOptions(dir, QueueOptions{Compression: cypress.ZSTD})
+		require.NoError(t, err)
+
+		m := message(0)
+
+		err = q.Append(m)
+		require.NoError(t, err)
+
+		err = q.Close()
+		require.NoError(t, err)
+
+		q, err = NewQueueOptions(dir, QueueOptions{Compression: cypress.ZSTD})
+		require.NoError(t, err)
+
+		defer q.Close()
+
+		unacked, err := q.Unacked()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(unacked))
+
+		assert.Equal(t, m, unacked[0])
+	})
+
+	n.Meow()
+}
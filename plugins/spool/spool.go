@@ -1,16 +1,21 @@
 package spool
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/vektra/cypress"
 	"github.com/vektra/tai64n"
+	"gopkg.in/tomb.v2"
 )
 
 type Spool struct {
@@ -18,17 +23,57 @@ type Spool struct {
 	// The size of each file will get before it's rotated
 	PerFileSize int64
 
-	// How many rotate files to keep
+	// How many rotate files to keep, no limit if negative
 	MaxFiles int
 
+	// The most bytes the files can take up, including the current one.
+	// No limit if 0.
+	MaxBytes int64
+
+	// How long to keep rotated files, forever if 0
+	MaxAge time.Duration
+
+	// Remove rotated files while the disk has less than this many
+	// bytes free
+	MinFree int64
+
+	// Only remove rotated files every consumer has read
+	WaitForConsumers bool
+
 	OnRotate func(string) error
 
+	// How new files are compressed
+	Compression cypress.StreamHeader_Compression
+
+	// Settings for the compression, also used to read the files
+	CompressionOptions *cypress.CompressionOptions
+
 	root      string
 	current   string
 	file      *os.File
 	startSize int64
 
 	enc *cypress.StreamEncoder
+
+	// opened by OpenSpool, so there's nothing to write to
+	readOnly bool
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	closed bool
+
+	syncMode     SyncMode
+	syncEvery    int
+	syncInterval time.Duration
+	syncFile     func(*os.File) error
+
+	// how many messages have been written and synced, across files
+	written uint64
+	synced  uint64
+	syncing bool
+	syncs   int
+
+	t tomb.Tomb
 }
 
 const PerFileSize = (1024 * 1024) // 1 meg per file
@@ -38,6 +83,28 @@ const MaxFiles = 10
 
 const DefaultSpoolDir = "/var/lib/cypress/spool"
 
+// Cut current back to it's last complete message, in case a write to it
+// was interrupted by a crash. Only the writer may do this, as current is
+// replaced with the repaired copy.
+func (sf *Spool) recoverCurrent() error {
+	res, err := CheckFile(sf.current, sf.CompressionOptions)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if res.Err == nil {
+		return nil
+	}
+
+	log.Printf("Recovering %s after message %d: %s", sf.current, res.Messages, res.Err)
+
+	return RepairFile(sf.current, res.Messages, sf.CompressionOptions)
+}
+
 func (sf *Spool) openCurrent() error {
 	fd, err := os.OpenFile(sf.current, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
@@ -54,9 +121,15 @@ func (sf *Spool) openCurrent() error {
 	sf.startSize = fi.Size()
 
 	enc := cypress.NewStreamEncoder(fd)
+	enc.Options = sf.CompressionOptions
 
 	if sf.startSize == 0 {
-		err = enc.Init(cypress.SNAPPY)
+		checksums := true
+
+		err = enc.WriteCustomHeader(&cypress.StreamHeader{
+			Compression: sf.Compression.Enum(),
+			Checksums:   &checksums,
+		})
 		if err != nil {
 			return err
 		}
@@ -72,12 +145,74 @@ func (sf *Spool) openCurrent() error {
 	return nil
 }
 
+// Create a Spool in root that compresses with snappy
 func NewSpool(root string) (*Spool, error) {
+	return NewSpoolOptions(root, SpoolOptions{Compression: cypress.SNAPPY})
+}
+
+// Optional behavior of a Spool
+type SpoolOptions struct {
+	// How new files are compressed
+	Compression cypress.StreamHeader_Compression
+
+	// Settings for the compression, nil for the defaults
+	CompressionOptions *cypress.CompressionOptions
+
+	// How many rotated files to keep, MaxFiles if 0 and no limit if
+	// negative
+	MaxFiles int
+
+	// Limits on the total size of the files, how long rotated files
+	// are kept and how much of the disk is left free. None if 0.
+	MaxBytes int64
+	MaxAge   time.Duration
+	MinFree  int64
+
+	// Don't remove rotated files until every consumer has read them,
+	// even if that means going over the limits
+	WaitForConsumers bool
+
+	// When messages are synced to disk, SyncAlways by default
+	SyncMode SyncMode
+
+	// How many messages SyncCount syncs after, DefaultSyncEvery if 0
+	SyncEvery int
+
+	// How often SyncInterval syncs, DefaultSyncInterval if 0
+	SyncInterval time.Duration
+}
+
+// Create a Spool in root with the behavior in opts
+func NewSpoolOptions(root string, opts SpoolOptions) (*Spool, error) {
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = MaxFiles
+	}
+
+	if opts.SyncEvery == 0 {
+		opts.SyncEvery = DefaultSyncEvery
+	}
+
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+
 	sf := &Spool{
-		PerFileSize: PerFileSize,
-		MaxFiles:    MaxFiles,
+		PerFileSize:        PerFileSize,
+		MaxFiles:           opts.MaxFiles,
+		MaxBytes:           opts.MaxBytes,
+		MaxAge:             opts.MaxAge,
+		MinFree:            opts.MinFree,
+		WaitForConsumers:   opts.WaitForConsumers,
+		Compression:        opts.Compression,
+		CompressionOptions: opts.CompressionOptions,
+		syncMode:           opts.SyncMode,
+		syncEvery:          opts.SyncEvery,
+		syncInterval:       opts.SyncInterval,
+		syncFile:           syncFile,
 	}
 
+	sf.cond = sync.NewCond(&sf.lock)
+
 	err := os.MkdirAll(root, 0755)
 	if err != nil {
 		return nil, err
@@ -89,11 +224,48 @@ func NewSpool(root string) (*Spool, error) {
 
 	sf.current = path.Join(root, "current")
 
+	err = sf.recoverCurrent()
+	if err != nil {
+		return nil, err
+	}
+
 	err = sf.openCurrent()
 	if err != nil {
 		return nil, err
 	}
 
+	if sf.syncMode == SyncInterval {
+		sf.t.Go(sf.syncTimer)
+	}
+
+	return sf, nil
+}
+
+var ErrReadOnly = errors.New("spool was opened read only")
+
+// Open the existing spool in root just to read it. Unlike
+// NewSpoolOptions, nothing is pruned, recovered or created, so it's safe
+// to use alongside the process writing the spool. opts are used to read
+// the files and may be nil.
+func OpenSpool(root string, opts *cypress.CompressionOptions) (*Spool, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	sf := &Spool{
+		CompressionOptions: opts,
+		root:               root,
+		current:            path.Join(root, "current"),
+		readOnly:           true,
+	}
+
+	sf.cond = sync.NewCond(&sf.lock)
+
 	return sf, nil
 }
 
@@ -105,79 +277,270 @@ func (sf *Spool) CurrentFile() string {
 	return path.Join(sf.root, "current")
 }
 
+type spoolFile struct {
+	name string
+	ts   *tai64n.TAI64N
+	size int64
+}
+
+type spoolFiles []spoolFile
+
+func (s spoolFiles) Len() int           { return len(s) }
+func (s spoolFiles) Less(i, j int) bool { return s[i].ts.Before(s[j].ts) }
+func (s spoolFiles) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Remove the oldest rotated files until every retention limit is met.
+// The current file is counted towards MaxBytes but never removed.
 func (sf *Spool) pruneOldFiles() {
-	files, err := ioutil.ReadDir(sf.root)
+	ents, err := ioutil.ReadDir(sf.root)
 
 	if err != nil {
 		fmt.Printf("Error reading files in %s: %s", sf.root, err)
 		return
 	}
 
-	var oldest string = ""
-	var oldest_ts *tai64n.TAI64N
+	var (
+		files spoolFiles
+		total int64
+	)
 
-	count := 0
+	for _, fi := range ents {
+		if fi.Name() == "current" {
+			total += fi.Size()
+			continue
+		}
 
-	for _, fi := range files {
 		ts := tai64n.ParseTAI64NLabel(fi.Name())
 
 		if ts == nil {
 			continue
 		}
 
-		count++
+		files = append(files, spoolFile{fi.Name(), ts, fi.Size()})
+		total += fi.Size()
+	}
+
+	sort.Sort(files)
 
-		if oldest_ts == nil || ts.Before(oldest_ts) {
-			oldest_ts = ts
-			oldest = fi.Name()
+	var cursors []*Cursor
+
+	if sf.WaitForConsumers {
+		cursors, err = sf.cursors()
+		if err != nil {
+			fmt.Printf("Error reading consumers in %s: %s\n", sf.root, err)
+			return
 		}
 	}
 
-	if count <= sf.MaxFiles {
-		return
+	var cutoff time.Time
+
+	if sf.MaxAge > 0 {
+		cutoff = time.Now().Add(-sf.MaxAge)
 	}
 
-	if oldest != "" {
-		name := path.Join(sf.root, oldest)
+	for len(files) > 0 {
+		oldest := files[0]
+
+		// a file is named for when it was rotated, so it's newest
+		// message is at least that old
+		expired := !cutoff.IsZero() && oldest.ts.Time().Before(cutoff)
+
+		overLimit := (sf.MaxFiles >= 0 && len(files) > sf.MaxFiles) ||
+			(sf.MaxBytes > 0 && total > sf.MaxBytes) ||
+			expired ||
+			(sf.MinFree > 0 && sf.lowOnSpace())
+
+		if !overLimit {
+			return
+		}
+
+		for _, c := range cursors {
+			if !c.passed(oldest.name) {
+				return
+			}
+		}
+
+		name := path.Join(sf.root, oldest.name)
 
 		err := os.Remove(name)
 
 		if err != nil {
 			fmt.Printf("Error removing %s: %s\n", name, err)
+			return
 		}
+
+		files = files[1:]
+		total -= oldest.size
+	}
+}
+
+// Indicates if the disk holding the spool has less than MinFree bytes
+// free. It isn't if that can't be checked.
+func (sf *Spool) lowOnSpace() bool {
+	free, err := diskFree(sf.root)
+	if err != nil {
+		return false
 	}
+
+	return free < uint64(sf.MinFree)
 }
 
+// Write m to current, syncing it as the SyncMode says, and rotate
+// current if it's full. Safe to call from multiple goroutines.
 func (sf *Spool) Receive(m *cypress.Message) error {
+	if sf.readOnly {
+		return ErrReadOnly
+	}
+
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	if sf.closed {
+		return cypress.ErrClosed
+	}
+
 	err := sf.enc.Receive(m)
 	if err != nil {
 		return err
 	}
 
-	sf.file.Sync()
+	sf.written++
 
-	if uint64(sf.startSize)+sf.enc.EncodedBytes() >= uint64(sf.PerFileSize) {
-		err := sf.Rotate()
-		if err != nil {
-			return err
+	seq := sf.written
+
+	if sf.full() {
+		// Waiting for a running sync gives up the lock, so another
+		// Receive may rotate current first
+		sf.waitForSync()
+
+		if sf.full() {
+			err := sf.rotate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch sf.syncMode {
+	case SyncAlways:
+		return sf.syncTo(seq)
+	case SyncCount:
+		if sf.written-sf.synced >= uint64(sf.syncEvery) {
+			return sf.syncTo(sf.written)
 		}
 	}
 
 	return nil
 }
 
+// Write out any buffered messages and, unless the SyncMode is SyncOS,
+// sync them
 func (sf *Spool) Flush() error {
-	return sf.enc.Flush()
+	if sf.readOnly {
+		return nil
+	}
+
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	if sf.closed {
+		return nil
+	}
+
+	err := sf.enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	if sf.syncMode == SyncOS {
+		return nil
+	}
+
+	return sf.syncTo(sf.written)
 }
 
 func (sf *Spool) Close() error {
-	sf.enc.Flush()
-	return sf.file.Close()
+	if sf.readOnly {
+		return nil
+	}
+
+	sf.lock.Lock()
+
+	if sf.closed {
+		sf.lock.Unlock()
+		return nil
+	}
+
+	sf.closed = true
+
+	err := sf.closeCurrent()
+
+	sf.lock.Unlock()
+
+	if sf.syncMode == SyncInterval {
+		sf.t.Kill(nil)
+		sf.t.Wait()
+	}
+
+	return err
+}
+
+// Flush, sync unless the SyncMode is SyncOS, and close current. Must be
+// called with the lock held.
+func (sf *Spool) closeCurrent() error {
+	sf.waitForSync()
+
+	err := sf.enc.Close()
+
+	if sf.syncMode != SyncOS {
+		serr := sf.syncFile(sf.file)
+		if err == nil {
+			err = serr
+		}
+
+		sf.syncs++
+		sf.synced = sf.written
+	}
+
+	cerr := sf.file.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Indicates current has reached PerFileSize
+func (sf *Spool) full() bool {
+	return uint64(sf.startSize)+sf.enc.EncodedBytes() >= uint64(sf.PerFileSize)
 }
 
 func (sf *Spool) Rotate() error {
-	sf.enc.Flush()
-	sf.file.Close()
+	if sf.readOnly {
+		return ErrReadOnly
+	}
+
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	// wait first, as it gives up the lock and the spool could be closed
+	// in the meantime
+	sf.waitForSync()
+
+	if sf.closed {
+		return cypress.ErrClosed
+	}
+
+	return sf.rotate()
+}
+
+// Must be called with the lock held and no sync running, so that the
+// lock isn't given up part way through
+func (sf *Spool) rotate() error {
+	err := sf.closeCurrent()
+	if err != nil {
+		return err
+	}
 
 	newName := sf.newFilename()
 	os.Rename(sf.current, newName)
@@ -188,7 +551,7 @@ func (sf *Spool) Rotate() error {
 
 	sf.pruneOldFiles()
 
-	err := sf.openCurrent()
+	err = sf.openCurrent()
 	if err != nil {
 		return err
 	}
@@ -196,7 +559,73 @@ func (sf *Spool) Rotate() error {
 	return nil
 }
 
+// How often a following generator checks for new messages by default
+const DefaultFollowInterval = 250 * time.Millisecond
+
+// Optional behavior of a SpoolGenerator
+type GeneratorOptions struct {
+	// Resume from and save the place of this consumer. A new consumer
+	// starts at the beginning.
+	Consumer string
+
+	// Wait for more messages at the end of current rather than returning
+	// io.EOF, moving on to the new current when it's rotated. Close
+	// stops the wait.
+	Follow bool
+
+	// How often to check for more messages when following,
+	// DefaultFollowInterval if 0
+	FollowInterval time.Duration
+}
+
+// Create a generator that reads every message in the spool
 func (s *Spool) Generator() (*SpoolGenerator, error) {
+	return s.GeneratorOptions(GeneratorOptions{})
+}
+
+// Create a generator for the consumer name that starts where it's
+// cursor is, or at the beginning for a new consumer, and saves it's
+// place as it reads. Messages Generate returns are counted as read once
+// Generate is called again.
+func (s *Spool) ConsumerGenerator(name string) (*SpoolGenerator, error) {
+	if _, err := s.cursorPath(name); err != nil {
+		return nil, err
+	}
+
+	return s.GeneratorOptions(GeneratorOptions{Consumer: name})
+}
+
+// Open the current file for reading and note when, making sure it
+// wasn't rotated in the middle
+func (s *Spool) readCurrent() (*os.File, string, error) {
+	path := filepath.Join(s.root, currentName)
+
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		opened := tai64n.Now().Label()
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+
+		now, err := os.Stat(path)
+		if err == nil && os.SameFile(fi, now) {
+			return f, opened, nil
+		}
+
+		f.Close()
+	}
+}
+
+// The labels of the files rotated after after and no later than until,
+// oldest first
+func (s *Spool) rotatedBetween(after, until string) ([]string, error) {
 	ents, err := ioutil.ReadDir(s.root)
 	if err != nil {
 		return nil, err
@@ -205,48 +634,152 @@ func (s *Spool) Generator() (*SpoolGenerator, error) {
 	var names []string
 
 	for _, e := range ents {
-		if e.Name() == "current" {
+		name := e.Name()
+
+		if tai64n.ParseTAI64NLabel(name) == nil || name <= after || name > until {
 			continue
 		}
 
-		names = append(names, e.Name())
+		names = append(names, name)
 	}
 
 	sort.Strings(names)
 
-	names = append(names, "current")
-
-	// we open all the files up front because something might rotate them
-	// out and delete them, so we want to be sure we've still got access
-	// to them.
-	var files []*os.File
+	return names, nil
+}
 
-	for _, name := range names {
-		f, err := os.Open(filepath.Join(s.root, name))
-		if err == nil {
-			files = append(files, f)
+// Create a generator with the behavior in opts
+func (s *Spool) GeneratorOptions(opts GeneratorOptions) (*SpoolGenerator, error) {
+	if opts.Consumer != "" {
+		if _, err := s.cursorPath(opts.Consumer); err != nil {
+			return nil, err
 		}
 	}
 
-	dec, err := cypress.NewStreamDecoder(files[0])
+	if opts.FollowInterval == 0 {
+		opts.FollowInterval = DefaultFollowInterval
+	}
+
+	// Open current before listing the rotated files so that one rotated
+	// in between isn't missed. Files rotated since it was opened are it
+	// and newer ones, which are read through it or when following.
+	cur, opened, err := s.readCurrent()
 	if err != nil {
 		return nil, err
 	}
 
-	return &SpoolGenerator{files: files, dec: dec}, nil
+	fi, err := cur.Stat()
+	if err != nil {
+		cur.Close()
+		return nil, err
+	}
+
+	names, err := s.rotatedBetween("", opened)
+	if err != nil {
+		cur.Close()
+		return nil, err
+	}
+
+	start, skip := 0, int64(0)
+
+	if opts.Consumer != "" {
+		c, err := s.Cursor(opts.Consumer)
+		if err != nil {
+			cur.Close()
+			return nil, err
+		}
+
+		if c != nil {
+			start, skip = c.find(names)
+		}
+	}
+
+	sg := &SpoolGenerator{
+		opts:     s.CompressionOptions,
+		spool:    s,
+		consumer: opts.Consumer,
+		follow:   opts.Follow,
+		interval: opts.FollowInterval,
+		done:     make(chan struct{}),
+		opened:   opened,
+		size:     fi.Size(),
+		skip:     skip,
+	}
+
+	// we open all the files up front because something might rotate them
+	// out and delete them, so we want to be sure we've still got access
+	// to them.
+	sg.open(names[start:])
+
+	sg.files = append(sg.files, cur)
+	sg.labels = append(sg.labels, currentName)
+
+	sg.dec = sg.decoder(sg.files[0])
+
+	if opts.Consumer != "" {
+		// registers a new consumer
+		err = sg.Commit()
+		if err != nil {
+			sg.Close()
+			return nil, err
+		}
+	}
+
+	return sg, nil
 }
 
 type SpoolGenerator struct {
+	lock    sync.Mutex
 	closed  bool
 	files   []*os.File
+	labels  []string
 	current int
+	opts    *cypress.CompressionOptions
 
 	dec *cypress.StreamDecoder
+
+	spool    *Spool
+	consumer string
+
+	follow   bool
+	interval time.Duration
+	done     chan struct{}
+	stop     sync.Once
+
+	// when current was opened
+	opened string
+
+	// how big current was when it was last read from the start
+	size int64
+
+	// how much of the file being read was already read
+	skip int64
 }
 
 var _ = cypress.Generator(&SpoolGenerator{})
 
+// Open the rotated files names, skipping any that were removed
+func (sg *SpoolGenerator) open(names []string) {
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(sg.spool.root, name))
+		if err == nil {
+			sg.files = append(sg.files, f)
+			sg.labels = append(sg.labels, name)
+		}
+	}
+}
+
+func (sg *SpoolGenerator) decoder(f *os.File) *cypress.StreamDecoder {
+	dec, _ := cypress.NewStreamDecoder(f)
+	dec.Options = sg.opts
+
+	return dec
+}
+
 func (sg *SpoolGenerator) Generate() (*cypress.Message, error) {
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+
 	if sg.closed {
 		return nil, io.EOF
 	}
@@ -254,24 +787,47 @@ func (sg *SpoolGenerator) Generate() (*cypress.Message, error) {
 	for {
 		m, err := sg.dec.Generate()
 		if err != nil {
+			last := sg.current == len(sg.files)-1
+
+			// the end of current may be a message that's still being
+			// written
+			if sg.follow && last && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				err = sg.waitForMore()
+				if err != nil {
+					if err == io.EOF {
+						sg.closed = true
+					}
+
+					return nil, err
+				}
+
+				continue
+			}
+
 			if err != io.EOF {
 				return nil, err
 			}
 
-			sg.current++
-
-			if sg.current == len(sg.files) {
+			if last {
 				sg.closed = true
 				return nil, io.EOF
 			}
 
-			dec, err := cypress.NewStreamDecoder(sg.files[sg.current])
-			if err != nil {
-				return nil, err
+			sg.current++
+			sg.skip = 0
+			sg.dec = sg.decoder(sg.files[sg.current])
+
+			if sg.consumer != "" {
+				err = sg.Commit()
+				if err != nil {
+					return nil, err
+				}
 			}
 
-			sg.dec = dec
+			continue
+		}
 
+		if sg.dec.Offset() <= sg.skip {
 			continue
 		}
 
@@ -279,10 +835,192 @@ func (sg *SpoolGenerator) Generate() (*cypress.Message, error) {
 	}
 }
 
+// Wait until current has more data or has been rotated and get ready to
+// read it. Returns io.EOF if the generator is closed first.
+func (sg *SpoolGenerator) waitForMore() error {
+	committed := false
+
+	for {
+		f := sg.files[sg.current]
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		// checked before the size, so that anything written before
+		// the rotation is read first. A missing current is being
+		// rotated, so wait for the new one.
+		now, err := os.Stat(filepath.Join(sg.spool.root, currentName))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		rotated := err == nil && !os.SameFile(fi, now)
+
+		fi, err = f.Stat()
+		if err != nil {
+			return err
+		}
+
+		if fi.Size() > sg.size {
+			return sg.reread(f, fi.Size())
+		}
+
+		if rotated {
+			return sg.followRotation()
+		}
+
+		// caught up, so save the consumer's place while waiting
+		if !committed {
+			err = sg.Commit()
+			if err != nil {
+				return err
+			}
+
+			committed = true
+		}
+
+		sg.lock.Unlock()
+
+		select {
+		case <-sg.done:
+		case <-time.After(sg.interval):
+		}
+
+		sg.lock.Lock()
+
+		select {
+		case <-sg.done:
+			return io.EOF
+		default:
+		}
+	}
+}
+
+// Read f from the start again, skipping what was already read. The
+// decoder can't continue after reaching the end since the compression
+// readers don't.
+func (sg *SpoolGenerator) reread(f *os.File, size int64) error {
+	skip := sg.Cursor().Offset
+
+	_, err := f.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	sg.dec = sg.decoder(f)
+	sg.skip = skip
+	sg.size = size
+
+	return nil
+}
+
+// The current file being read was rotated, so find it's new name and
+// open the files rotated after it, then the new current
+func (sg *SpoolGenerator) followRotation() error {
+	cur, opened, err := sg.spool.readCurrent()
+	if err != nil {
+		// rotated again and not recreated yet, so try again later
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	fi, err := cur.Stat()
+	if err != nil {
+		cur.Close()
+		return err
+	}
+
+	names, err := sg.spool.rotatedBetween(sg.opened, opened)
+	if err != nil {
+		cur.Close()
+		return err
+	}
+
+	// it's the first file rotated after it was opened, unless that's
+	// already been removed
+	label := sg.opened
+
+	if len(names) > 0 {
+		ours, err := sg.files[sg.current].Stat()
+		if err != nil {
+			cur.Close()
+			return err
+		}
+
+		first, err := os.Stat(filepath.Join(sg.spool.root, names[0]))
+		if err == nil && os.SameFile(ours, first) {
+			label = names[0]
+			names = names[1:]
+		}
+	}
+
+	sg.labels[sg.current] = label
+
+	sg.open(names)
+
+	sg.files = append(sg.files, cur)
+	sg.labels = append(sg.labels, currentName)
+
+	sg.opened = opened
+	sg.size = fi.Size()
+
+	return nil
+}
+
+// Where the generator is, after the messages Generate has returned
+func (sg *SpoolGenerator) Cursor() *Cursor {
+	c := &Cursor{
+		File:   sg.labels[sg.current],
+		Offset: sg.dec.Offset(),
+	}
+
+	if c.Offset < sg.skip {
+		c.Offset = sg.skip
+	}
+
+	if c.File == currentName {
+		c.Opened = sg.opened
+	}
+
+	return c
+}
+
+// Save the consumer's cursor, counting the messages Generate has
+// returned as read
+func (sg *SpoolGenerator) Commit() error {
+	if sg.consumer == "" {
+		return nil
+	}
+
+	return sg.spool.saveCursor(sg.consumer, sg.Cursor())
+}
+
+// Stop reading, saving the consumer's cursor first. Safe to call while
+// Generate is waiting when following.
 func (sg *SpoolGenerator) Close() error {
+	sg.stop.Do(func() { close(sg.done) })
+
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+
+	sg.closed = true
+
+	if sg.files == nil {
+		return nil
+	}
+
+	err := sg.Commit()
+
 	for _, file := range sg.files {
 		file.Close()
 	}
 
-	return nil
+	sg.files = nil
+
+	return err
 }
@@ -1,15 +1,20 @@
 package spool
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektra/cypress"
 	"github.com/vektra/neko"
+	"github.com/vektra/tai64n"
 )
 
 func TestSpool(t *testing.T) {
@@ -128,3 +133,725 @@ func TestSpool(t *testing.T) {
 
 	n.Meow()
 }
+
+func TestSpoolCompression(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("compresses files with the configured compression", func() {
+		opts, err := cypress.NewCompressionOptions(3, "../../testdata/zstd.dict")
+		require.NoError(t, err)
+
+		dir, err := ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(dir)
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{
+			Compression:        cypress.ZSTD,
+			CompressionOptions: opts,
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Rotate()
+		require.NoError(t, err)
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		f, err := os.Open(filepath.Join(dir, "current"))
+		require.NoError(t, err)
+
+		defer f.Close()
+
+		probe := cypress.NewProbe(f)
+
+		err = probe.Probe()
+		require.NoError(t, err)
+
+		assert.Equal(t, cypress.ZSTD, probe.Compression())
+
+		gen, err := s.Generator()
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		for i := 0; i < 2; i++ {
+			m2, err := gen.Generate()
+			require.NoError(t, err)
+
+			assert.Equal(t, m, m2)
+		}
+	})
+
+	n.It("compresses across messages when it doesn't sync each one", func() {
+		size := func(comp cypress.StreamHeader_Compression, mode SyncMode) int64 {
+			dir, err := ioutil.TempDir("", "spool")
+			require.NoError(t, err)
+
+			defer os.RemoveAll(dir)
+
+			s, err := NewSpoolOptions(dir, SpoolOptions{
+				Compression: comp,
+				SyncMode:    mode,
+			})
+			require.NoError(t, err)
+
+			for i := 0; i < 500; i++ {
+				m := cypress.Log()
+				m.Add("message", "the quick brown fox jumps over the lazy dog")
+
+				err = s.Receive(m)
+				require.NoError(t, err)
+			}
+
+			err = s.Close()
+			require.NoError(t, err)
+
+			fi, err := os.Stat(filepath.Join(dir, "current"))
+			require.NoError(t, err)
+
+			return fi.Size()
+		}
+
+		plain := size(cypress.NONE, SyncOS)
+
+		for _, mode := range []SyncMode{SyncCount, SyncInterval, SyncOS} {
+			compressed := size(cypress.ZSTD, mode)
+			assert.True(t, compressed < plain/4, "%d bytes compressed, %d plain", compressed, plain)
+		}
+	})
+
+	n.Meow()
+}
+
+func TestSpoolRetention(t *testing.T) {
+	n := neko.Start(t)
+
+	var dir string
+
+	n.Setup(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+	})
+
+	n.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	// Make a rotated file of size bytes that was rotated age ago
+	rotated := func(age time.Duration, size int) string {
+		name := tai64n.FromTime(time.Now().Add(-age)).Label()
+
+		err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+		require.NoError(t, err)
+
+		return name
+	}
+
+	remaining := func() []string {
+		ents, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+
+		var names []string
+
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+
+		sort.Strings(names)
+
+		return names
+	}
+
+	n.It("removes all the files over MaxFiles at once", func() {
+		var names []string
+
+		for i := 5; i > 0; i-- {
+			names = append(names, rotated(time.Duration(i)*time.Minute, 10))
+		}
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxFiles: 2})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		assert.Equal(t, append(names[3:], "current"), remaining())
+	})
+
+	n.It("keeps every file when MaxFiles is negative", func() {
+		for i := 20; i > 0; i-- {
+			rotated(time.Duration(i)*time.Minute, 10)
+		}
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxFiles: -1})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		assert.Equal(t, 21, len(remaining()))
+	})
+
+	n.It("removes the oldest files until they fit in MaxBytes", func() {
+		var names []string
+
+		for i := 4; i > 0; i-- {
+			names = append(names, rotated(time.Duration(i)*time.Minute, 100))
+		}
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxBytes: 250})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		assert.Equal(t, append(names[2:], "current"), remaining())
+	})
+
+	n.It("counts the current file towards MaxBytes", func() {
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxBytes: 150})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Rotate()
+		require.NoError(t, err)
+
+		first := remaining()[0]
+
+		err = ioutil.WriteFile(filepath.Join(dir, "current"), make([]byte, 149), 0644)
+		require.NoError(t, err)
+
+		s.pruneOldFiles()
+
+		assert.NotContains(t, remaining(), first)
+		assert.Contains(t, remaining(), "current")
+	})
+
+	n.It("removes files rotated more than MaxAge ago", func() {
+		rotated(3*time.Hour, 10)
+		rotated(2*time.Hour, 10)
+		recent := rotated(time.Minute, 10)
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxAge: time.Hour})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		assert.Equal(t, []string{recent, "current"}, remaining())
+	})
+
+	n.It("removes rotated files while the disk is short on space", func() {
+		for i := 3; i > 0; i-- {
+			rotated(time.Duration(i)*time.Minute, 10)
+		}
+
+		free, err := diskFree(dir)
+		if err != nil {
+			t.Skip("free disk space can't be checked here")
+		}
+
+		s, err := NewSpoolOptions(dir, SpoolOptions{MinFree: int64(free) + 1<<30})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		assert.Equal(t, []string{"current"}, remaining())
+	})
+
+	n.It("enforces the limits each time it rotates", func() {
+		s, err := NewSpoolOptions(dir, SpoolOptions{MaxFiles: 1})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		for i := 0; i < 3; i++ {
+			err = s.Receive(cypress.Log())
+			require.NoError(t, err)
+
+			err = s.Rotate()
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, len(remaining()))
+	})
+
+	n.Meow()
+}
+
+func TestSpoolFollow(t *testing.T) {
+	n := neko.Start(t)
+
+	var (
+		dir string
+		sf  *Spool
+		idx int
+	)
+
+	n.Setup(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+
+		sf, err = NewSpool(dir)
+		require.NoError(t, err)
+
+		idx = 0
+	})
+
+	n.Cleanup(func() {
+		sf.Close()
+		os.RemoveAll(dir)
+	})
+
+	write := func() {
+		m := cypress.Log()
+		m.Add("index", idx)
+		idx++
+
+		err := sf.Receive(m)
+		require.NoError(t, err)
+
+		err = sf.Flush()
+		require.NoError(t, err)
+	}
+
+	follow := func(consumer string) *SpoolGenerator {
+		gen, err := sf.GeneratorOptions(GeneratorOptions{
+			Consumer:       consumer,
+			Follow:         true,
+			FollowInterval: 5 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		return gen
+	}
+
+	type result struct {
+		m   *cypress.Message
+		err error
+	}
+
+	generate := func(gen *SpoolGenerator) chan result {
+		c := make(chan result, 1)
+
+		go func() {
+			m, err := gen.Generate()
+			c <- result{m, err}
+		}()
+
+		return c
+	}
+
+	next := func(gen *SpoolGenerator) int64 {
+		select {
+		case r := <-generate(gen):
+			require.NoError(t, r.err)
+
+			i, ok := r.m.GetInt("index")
+			require.True(t, ok)
+
+			return i
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message was read")
+		}
+
+		return -1
+	}
+
+	n.It("waits for more messages at the end of current", func() {
+		gen := follow("")
+		defer gen.Close()
+
+		c := generate(gen)
+
+		select {
+		case <-c:
+			t.Fatal("Generate didn't wait")
+		case <-time.After(30 * time.Millisecond):
+		}
+
+		write()
+
+		select {
+		case r := <-c:
+			require.NoError(t, r.err)
+
+			i, ok := r.m.GetInt("index")
+			require.True(t, ok)
+
+			assert.Equal(t, int64(0), i)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the message was never read")
+		}
+	})
+
+	n.It("moves on to the new current when it's rotated", func() {
+		write()
+
+		gen := follow("")
+		defer gen.Close()
+
+		assert.Equal(t, int64(0), next(gen))
+
+		write()
+
+		err := sf.Rotate()
+		require.NoError(t, err)
+
+		write()
+
+		assert.Equal(t, int64(1), next(gen))
+		assert.Equal(t, int64(2), next(gen))
+	})
+
+	n.It("reads every file rotated while it wasn't reading", func() {
+		gen := follow("")
+		defer gen.Close()
+
+		for i := 0; i < 3; i++ {
+			write()
+
+			err := sf.Rotate()
+			require.NoError(t, err)
+		}
+
+		write()
+
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, int64(i), next(gen))
+		}
+	})
+
+	n.It("returns EOF when closed while waiting", func() {
+		gen := follow("")
+
+		c := generate(gen)
+
+		time.Sleep(10 * time.Millisecond)
+
+		err := gen.Close()
+		require.NoError(t, err)
+
+		select {
+		case r := <-c:
+			assert.Equal(t, io.EOF, r.err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Generate didn't return")
+		}
+	})
+
+	n.It("saves the consumer's place once it's caught up", func() {
+		write()
+
+		gen := follow("shipper")
+		defer gen.Close()
+
+		assert.Equal(t, int64(0), next(gen))
+
+		generate(gen)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for {
+			c, err := sf.Cursor("shipper")
+			require.NoError(t, err)
+
+			if c.Offset > 0 {
+				break
+			}
+
+			require.True(t, time.Now().Before(deadline), "the cursor was never saved")
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	n.Meow()
+}
+
+func TestSpoolSync(t *testing.T) {
+	n := neko.Start(t)
+
+	var (
+		dir string
+		sf  *Spool
+	)
+
+	n.Setup(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		require.NoError(t, err)
+	})
+
+	n.Cleanup(func() {
+		if sf != nil {
+			sf.Close()
+			sf = nil
+		}
+
+		os.RemoveAll(dir)
+	})
+
+	open := func(opts SpoolOptions) {
+		var err error
+		sf, err = NewSpoolOptions(dir, opts)
+		require.NoError(t, err)
+	}
+
+	write := func(count int) {
+		for i := 0; i < count; i++ {
+			err := sf.Receive(cypress.Log())
+			require.NoError(t, err)
+		}
+	}
+
+	syncs := func() int {
+		sf.lock.Lock()
+		defer sf.lock.Unlock()
+
+		return sf.syncs
+	}
+
+	n.It("syncs every message by default", func() {
+		open(SpoolOptions{})
+
+		write(10)
+
+		assert.Equal(t, 10, syncs())
+	})
+
+	n.It("syncs every SyncEvery messages with SyncCount", func() {
+		open(SpoolOptions{SyncMode: SyncCount, SyncEvery: 4})
+
+		write(10)
+
+		assert.Equal(t, 2, syncs())
+
+		err := sf.Flush()
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, syncs())
+	})
+
+	n.It("syncs every SyncInterval with SyncInterval", func() {
+		open(SpoolOptions{SyncMode: SyncInterval, SyncInterval: 5 * time.Millisecond})
+
+		write(10)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for syncs() == 0 {
+			require.True(t, time.Now().Before(deadline), "the spool was never synced")
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		before := syncs()
+
+		time.Sleep(50 * time.Millisecond)
+
+		assert.Equal(t, before, syncs(), "synced with nothing new written")
+	})
+
+	n.It("leaves syncing to the OS with SyncOS", func() {
+		open(SpoolOptions{SyncMode: SyncOS})
+
+		write(10)
+
+		err := sf.Flush()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, syncs())
+	})
+
+	n.It("syncs before closing the file", func() {
+		open(SpoolOptions{SyncMode: SyncCount, SyncEvery: 100})
+
+		write(10)
+
+		err := sf.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, sf.syncs)
+		assert.Equal(t, cypress.ErrClosed, sf.Receive(cypress.Log()))
+	})
+
+	n.It("shares syncs between concurrent receives", func() {
+		open(SpoolOptions{})
+
+		sf.syncFile = func(f *os.File) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+
+		const (
+			writers = 8
+			each    = 50
+		)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < each; j++ {
+					err := sf.Receive(cypress.Log())
+					assert.NoError(t, err)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		assert.True(t, syncs() < writers*each/2, "%d syncs for %d messages", syncs(), writers*each)
+
+		sf.lock.Lock()
+		defer sf.lock.Unlock()
+
+		assert.Equal(t, uint64(writers*each), sf.synced)
+	})
+
+	n.It("rotates once when receives waiting on a sync fill current", func() {
+		open(SpoolOptions{MaxFiles: -1})
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		var once sync.Once
+
+		sf.syncFile = func(f *os.File) error {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+
+			return nil
+		}
+
+		var wg sync.WaitGroup
+
+		receive := func() {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				err := sf.Receive(cypress.Log())
+				assert.NoError(t, err)
+			}()
+		}
+
+		// hold a sync open, then fill current while it runs
+		receive()
+
+		<-started
+
+		sf.lock.Lock()
+		sf.PerFileSize = 1
+		sf.lock.Unlock()
+
+		receive()
+		receive()
+
+		time.Sleep(50 * time.Millisecond)
+
+		close(release)
+
+		wg.Wait()
+
+		err := sf.Close()
+		require.NoError(t, err)
+
+		results, err := Check(dir, nil, false)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(results))
+
+		assert.Equal(t, 3, results[0].Messages)
+		assert.Equal(t, 0, results[1].Messages)
+	})
+
+	n.Meow()
+}
+
+func benchmarkSpoolSync(b *testing.B, opts SpoolOptions, parallel bool) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	sf, err := NewSpoolOptions(dir, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer sf.Close()
+
+	m := cypress.Log()
+	m.Add("message", "the quick brown fox jumps over the lazy dog")
+
+	b.ResetTimer()
+
+	if parallel {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := sf.Receive(m); err != nil {
+					// FailNow can't be called from this goroutine
+					b.Error(err)
+					return
+				}
+			}
+		})
+	} else {
+		for i := 0; i < b.N; i++ {
+			if err := sf.Receive(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	err = sf.Flush()
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkSpoolSyncAlways(b *testing.B) {
+	benchmarkSpoolSync(b, SpoolOptions{SyncMode: SyncAlways}, false)
+}
+
+func BenchmarkSpoolSyncAlwaysParallel(b *testing.B) {
+	benchmarkSpoolSync(b, SpoolOptions{SyncMode: SyncAlways}, true)
+}
+
+func BenchmarkSpoolSyncCount(b *testing.B) {
+	benchmarkSpoolSync(b, SpoolOptions{SyncMode: SyncCount}, false)
+}
+
+func BenchmarkSpoolSyncInterval(b *testing.B) {
+	benchmarkSpoolSync(b, SpoolOptions{SyncMode: SyncInterval}, false)
+}
+
+func BenchmarkSpoolSyncOS(b *testing.B) {
+	benchmarkSpoolSync(b, SpoolOptions{SyncMode: SyncOS}, false)
+}
@@ -0,0 +1,135 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// How a Spool makes sure the messages it receives are on disk
+type SyncMode int
+
+const (
+	// Sync before Receive returns, the default. Receives running at the
+	// same time share a sync.
+	SyncAlways SyncMode = iota
+
+	// Sync once SyncEvery messages have been received since the last
+	// sync
+	SyncCount
+
+	// Sync every SyncInterval if any messages have been received
+	SyncInterval
+
+	// Leave writing the messages to disk to the OS
+	SyncOS
+)
+
+var ErrUnknownSyncMode = errors.New("unknown sync mode")
+
+// Find the mode for name, one of always, count, interval or os. An empty
+// name is SyncAlways.
+func ParseSyncMode(name string) (SyncMode, error) {
+	switch name {
+	case "", "always":
+		return SyncAlways, nil
+	case "count":
+		return SyncCount, nil
+	case "interval":
+		return SyncInterval, nil
+	case "os", "never":
+		return SyncOS, nil
+	default:
+		return SyncAlways, ErrUnknownSyncMode
+	}
+}
+
+const (
+	// How many messages SyncCount syncs after by default
+	DefaultSyncEvery = 100
+
+	// How often SyncInterval syncs by default
+	DefaultSyncInterval = time.Second
+)
+
+// Flush and sync the current file until the first seq messages written
+// are on disk. Only one sync runs at a time and it covers every message
+// written before it started, so callers waiting together share it,
+// along with the flush. The compression is only flushed here, as zstd
+// and lz4 end a frame on each flush. Must be called with the lock held.
+func (sf *Spool) syncTo(seq uint64) error {
+	for sf.synced < seq {
+		if sf.syncing {
+			sf.cond.Wait()
+			continue
+		}
+
+		err := sf.enc.Flush()
+		if err != nil {
+			return err
+		}
+
+		sf.syncing = true
+
+		target := sf.written
+		file := sf.file
+
+		sf.lock.Unlock()
+
+		err = sf.syncFile(file)
+
+		sf.lock.Lock()
+
+		sf.syncing = false
+		sf.syncs++
+
+		if err == nil && target > sf.synced {
+			sf.synced = target
+		}
+
+		sf.cond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Wait for a sync another Receive started to finish, so the file isn't
+// closed out from under it. Must be called with the lock held.
+func (sf *Spool) waitForSync() {
+	for sf.syncing {
+		sf.cond.Wait()
+	}
+}
+
+// Sync the messages received since the last sync every interval
+func (sf *Spool) syncTimer() error {
+	tick := time.NewTicker(sf.syncInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			sf.lock.Lock()
+
+			if !sf.closed {
+				err := sf.syncTo(sf.written)
+				if err != nil {
+					fmt.Printf("Error syncing %s: %s\n", sf.current, err)
+				}
+			}
+
+			sf.lock.Unlock()
+		case <-sf.t.Dying():
+			return nil
+		}
+	}
+}
+
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
@@ -0,0 +1,245 @@
+package tcp
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vektra/cypress"
+)
+
+// How messages are spread across the hosts of a TCPBalancer
+type BalanceMode int
+
+const (
+	// Connect to a random host, the default
+	Random BalanceMode = iota
+
+	// Use the first host that's up, in the order given
+	Failover
+
+	// Send each message to the next host in turn
+	RoundRobin
+
+	// Send messages with the same value of a field to the same host
+	ConsistentHash
+)
+
+var ErrUnknownBalanceMode = errors.New("unknown balance mode")
+
+// Find the mode for name, one of random, failover, round-robin or hash.
+// An empty name is Random.
+func ParseBalanceMode(name string) (BalanceMode, error) {
+	switch name {
+	case "", "random":
+		return Random, nil
+	case "failover":
+		return Failover, nil
+	case "round-robin", "roundrobin":
+		return RoundRobin, nil
+	case "hash", "consistent-hash":
+		return ConsistentHash, nil
+	default:
+		return Random, ErrUnknownBalanceMode
+	}
+}
+
+// How often the plugin and cli check hosts by default
+const DefaultHealthInterval = 10 * time.Second
+
+// The points each host has on the hash ring
+const ringReplicas = 100
+
+// Optional behavior of a TCPBalancer
+type BalanceOptions struct {
+	SendOptions
+
+	Mode BalanceMode
+
+	// The field to hash with ConsistentHash. "session_id" is the
+	// message's session id, otherwise it's the attribute or tag with
+	// the name. Messages without it are sent round robin.
+	HashKey string
+}
+
+// Sends messages to a set of hosts. With Random and Failover a single
+// TCPSend is used. Otherwise there is a TCPSend for each host which
+// fails over to the hosts after it and, with a HealthInterval, moves
+// back to it's own host when it recovers. Each of those keeps it's
+// queue in a directory named after it's host inside QueueDir.
+type TCPBalancer struct {
+	sends []*TCPSend
+
+	mode BalanceMode
+	key  string
+	next uint32
+
+	ring []ringPoint
+}
+
+type ringPoint struct {
+	hash uint32
+	send int
+}
+
+type ringPoints []ringPoint
+
+func (r ringPoints) Len() int           { return len(r) }
+func (r ringPoints) Less(i, j int) bool { return r[i].hash < r[j].hash }
+func (r ringPoints) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// md5 spreads similar strings, like the names of a host's points,
+// evenly across the ring
+func hashString(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Create a TCPBalancer sending to hosts as opts.Mode says
+func NewTCPBalancer(hosts []string, window, buffer int, opts BalanceOptions) (*TCPBalancer, error) {
+	if len(hosts) == 0 {
+		return nil, ErrNoAvailableHosts
+	}
+
+	b := &TCPBalancer{
+		mode: opts.Mode,
+		key:  opts.HashKey,
+	}
+
+	sopts := opts.SendOptions
+
+	switch opts.Mode {
+	case Random, Failover:
+		sopts.Failover = opts.Mode == Failover
+
+		send, err := NewTCPSendOptions(append([]string(nil), hosts...), window, buffer, sopts)
+		if err != nil {
+			return nil, err
+		}
+
+		b.sends = append(b.sends, send)
+
+		return b, nil
+	case RoundRobin, ConsistentHash:
+		// handled below
+	default:
+		return nil, ErrUnknownBalanceMode
+	}
+
+	sopts.Failover = true
+
+	for i := range hosts {
+		// prefer host i, then the ones after it
+		order := append(append([]string(nil), hosts[i:]...), hosts[:i]...)
+
+		if opts.QueueDir != "" {
+			sopts.QueueDir = filepath.Join(opts.QueueDir, strings.Replace(hosts[i], ":", "_", -1))
+		}
+
+		send, err := NewTCPSendOptions(order, window, buffer, sopts)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+
+		b.sends = append(b.sends, send)
+
+		for r := 0; r < ringReplicas; r++ {
+			b.ring = append(b.ring, ringPoint{
+				hash: hashString(hosts[i] + "#" + strconv.Itoa(r)),
+				send: i,
+			})
+		}
+	}
+
+	sort.Sort(ringPoints(b.ring))
+
+	return b, nil
+}
+
+// The value of the hash field in m
+func (b *TCPBalancer) hashValue(m *cypress.Message) (string, bool) {
+	if b.key == "session_id" {
+		id := m.GetSessionId()
+		return id, id != ""
+	}
+
+	if val, ok := m.Get(b.key); ok {
+		return fmt.Sprint(val), true
+	}
+
+	return m.GetTag(b.key)
+}
+
+// The TCPSend to send m with
+func (b *TCPBalancer) pick(m *cypress.Message) *TCPSend {
+	if len(b.sends) == 1 {
+		return b.sends[0]
+	}
+
+	if b.mode == ConsistentHash {
+		if val, ok := b.hashValue(m); ok {
+			h := hashString(val)
+
+			i := sort.Search(len(b.ring), func(i int) bool {
+				return b.ring[i].hash >= h
+			})
+
+			if i == len(b.ring) {
+				i = 0
+			}
+
+			return b.sends[b.ring[i].send]
+		}
+	}
+
+	n := atomic.AddUint32(&b.next, 1)
+
+	return b.sends[(n-1)%uint32(len(b.sends))]
+}
+
+// The stats of each connection
+func (b *TCPBalancer) Stats() []cypress.ReliableStats {
+	var stats []cypress.ReliableStats
+
+	for _, send := range b.sends {
+		stats = append(stats, send.Stats())
+	}
+
+	return stats
+}
+
+func (b *TCPBalancer) Receive(m *cypress.Message) error {
+	return b.pick(m).Receive(m)
+}
+
+func (b *TCPBalancer) Flush() error {
+	for _, send := range b.sends {
+		err := send.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *TCPBalancer) Close() error {
+	var err error
+
+	for _, send := range b.sends {
+		cerr := send.Close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,251 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestBalancer(t *testing.T) {
+	n := neko.Start(t)
+
+	var gens []*TCPRecvGenerator
+
+	n.Setup(func() {
+		gens = nil
+	})
+
+	n.Cleanup(func() {
+		for _, gen := range gens {
+			gen.Close()
+		}
+	})
+
+	listen := func(addr string) *TCPRecvGenerator {
+		gen, err := NewTCPRecvGenerator(addr)
+		require.NoError(t, err)
+
+		gens = append(gens, gen)
+
+		return gen
+	}
+
+	addrOf := func(gen *TCPRecvGenerator) string {
+		return gen.l.Addr().String()
+	}
+
+	// An address nothing is listening on
+	deadAddr := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		addr := l.Addr().String()
+		l.Close()
+
+		return addr
+	}
+
+	sendTo := func(r cypress.Receiver, m *cypress.Message) {
+		err := r.Receive(m)
+		require.NoError(t, err)
+
+		err = r.(*TCPBalancer).Flush()
+		require.NoError(t, err)
+	}
+
+	n.It("fails over to hosts in order", func() {
+		first := listen("127.0.0.1:0")
+		second := listen("127.0.0.1:0")
+
+		b, err := NewTCPBalancer([]string{deadAddr(), addrOf(first), addrOf(second)}, 0, 0, BalanceOptions{
+			Mode: Failover,
+		})
+		require.NoError(t, err)
+
+		defer b.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		sendTo(b, m)
+
+		m2, err := first.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("moves back to a preferred host when it recovers", func() {
+		addr := deadAddr()
+		backup := listen("127.0.0.1:0")
+
+		b, err := NewTCPBalancer([]string{addr, addrOf(backup)}, 0, 0, BalanceOptions{
+			SendOptions: SendOptions{HealthInterval: 10 * time.Millisecond},
+			Mode:        Failover,
+		})
+		require.NoError(t, err)
+
+		defer b.Close()
+
+		send := b.sends[0]
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		sendTo(b, m)
+
+		_, err = backup.Generate()
+		require.NoError(t, err)
+
+		primary := listen(addr)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for {
+			send.lock.Lock()
+			current := send.current
+			send.lock.Unlock()
+
+			if current == 0 {
+				break
+			}
+
+			require.True(t, time.Now().Before(deadline), "never reconnected to the primary")
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		sendTo(b, m)
+
+		m2, err := primary.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("sends to each host in turn", func() {
+		first := listen("127.0.0.1:0")
+		second := listen("127.0.0.1:0")
+
+		b, err := NewTCPBalancer([]string{addrOf(first), addrOf(second)}, 0, 0, BalanceOptions{
+			Mode: RoundRobin,
+		})
+		require.NoError(t, err)
+
+		defer b.Close()
+
+		for i := 0; i < 4; i++ {
+			m := cypress.Log()
+			m.Add("index", i)
+
+			sendTo(b, m)
+		}
+
+		for _, gen := range []*TCPRecvGenerator{first, second} {
+			for i := 0; i < 2; i++ {
+				_, err := gen.Generate()
+				require.NoError(t, err)
+			}
+		}
+	})
+
+	n.It("sends messages with the same key to the same host", func() {
+		for i := 0; i < 3; i++ {
+			listen("127.0.0.1:0")
+		}
+
+		var hosts []string
+
+		for _, gen := range gens {
+			hosts = append(hosts, addrOf(gen))
+		}
+
+		b, err := NewTCPBalancer(hosts, 0, 0, BalanceOptions{
+			Mode:    ConsistentHash,
+			HashKey: "session_id",
+		})
+		require.NoError(t, err)
+
+		defer b.Close()
+
+		used := map[*TCPSend]bool{}
+
+		for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			m := cypress.Log()
+			m.For(id)
+
+			send := b.pick(m)
+
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, send, b.pick(m))
+			}
+
+			used[send] = true
+		}
+
+		assert.True(t, len(used) > 1, "every key went to one host")
+
+		m := cypress.Log()
+		m.For("a")
+
+		sendTo(b, m)
+
+		idx := b.ring[0].send
+
+		for _, p := range b.ring {
+			if p.hash >= hashString("a") {
+				idx = p.send
+				break
+			}
+		}
+
+		m2, err := gens[idx].Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, "a", m2.GetSessionId())
+	})
+
+	n.It("hashes on attributes and tags", func() {
+		b := &TCPBalancer{key: "user"}
+
+		m := cypress.Log()
+		m.Add("user", 42)
+
+		val, ok := b.hashValue(m)
+		require.True(t, ok)
+
+		assert.Equal(t, "42", val)
+
+		m = cypress.Log()
+		m.AddTag("user", "evan")
+
+		val, ok = b.hashValue(m)
+		require.True(t, ok)
+
+		assert.Equal(t, "evan", val)
+
+		_, ok = b.hashValue(cypress.Log())
+		assert.False(t, ok)
+	})
+
+	n.It("parses mode names", func() {
+		mode, err := ParseBalanceMode("round-robin")
+		require.NoError(t, err)
+
+		assert.Equal(t, RoundRobin, mode)
+
+		mode, err = ParseBalanceMode("")
+		require.NoError(t, err)
+
+		assert.Equal(t, Random, mode)
+
+		_, err = ParseBalanceMode("fastest")
+		assert.Equal(t, ErrUnknownBalanceMode, err)
+	})
+
+	n.Meow()
+}
@@ -3,15 +3,62 @@ package tcp
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/vektra/cypress"
 	"github.com/vektra/cypress/cli/commands"
+	"github.com/vektra/cypress/keystore"
 )
 
 type Send struct {
-	Addr   string `short:"a" long:"addr" description:"Who to send the stream to"`
+	Addr   string `short:"a" long:"addr" description:"Who to send the stream to, comma separated"`
 	Window int    `short:"w" long:"window" description:"Window size to use when transmitting"`
 	Buffer int    `short:"b" long:"buffer" description:"How big of an internal buffer to use"`
+
+	Mode           string        `short:"m" long:"mode" description:"how to use the hosts: random, failover, round-robin or hash"`
+	HashKey        string        `long:"hash-key" description:"field to hash messages on, such as session_id"`
+	HealthInterval time.Duration `long:"health-interval" default:"10s" description:"how often to check if a preferred host is back"`
+
+	MaxOutstanding int           `long:"max-outstanding" description:"most messages to hold that aren't acked, waiting for acks at the limit"`
+	Backoff        time.Duration `long:"backoff" default:"1s" description:"how long to wait to reconnect after the first failure, doubling after each"`
+	MaxBackoff     time.Duration `long:"max-backoff" default:"30s" description:"longest to wait to reconnect"`
+	QueueDir       string        `long:"queue-dir" description:"directory to keep messages in until they're acked, so they survive restarts"`
+	Protocol       string        `long:"protocol" default:"legacy" description:"legacy, or sequenced so the receiver can drop resent messages, which needs a receiver that understands it"`
+
+	AuthKey string `long:"auth-key" description:"keystore key to authenticate the stream with"`
+
+	Compression    string `short:"c" long:"compression" default:"none" description:"comma separated compressions to offer the receiver in order of preference, from none, snappy, zlib, zstd and lz4"`
+	ZstdLevel      int    `long:"zstd-level" description:"zstd level from 1 (fastest) to 22 (smallest)"`
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of a dictionary trained with zstd --train"`
+
+	TLSOptions
+}
+
+// TLS flags shared by send and recv
+type TLSOptions struct {
+	TLS        bool     `long:"tls" description:"use TLS, implied by --cert and --ca"`
+	Cert       string   `long:"cert" description:"PEM certificate, the server's (recv) or the client's (send)"`
+	Key        string   `long:"key" description:"PEM key for --cert"`
+	CA         string   `long:"ca" description:"PEM CA certificates to verify the remote side with, required of clients if set (recv)"`
+	ServerName string   `long:"server-name" description:"name to verify the server's certificate with (send)"`
+	Peers      []string `long:"peer" description:"name allowed in the remote side's certificate, may be repeated"`
+	PeerTag    string   `long:"peer-tag" description:"tag to add to messages with the name in the client's certificate (recv)"`
+}
+
+// The TLS settings, nil if TLS isn't used
+func (o *TLSOptions) config() *TLSConfig {
+	if !o.TLS && o.Cert == "" && o.CA == "" {
+		return nil
+	}
+
+	return &TLSConfig{
+		Cert:       o.Cert,
+		Key:        o.Key,
+		CA:         o.CA,
+		ServerName: o.ServerName,
+		Peers:      o.Peers,
+		PeerTag:    o.PeerTag,
+	}
 }
 
 func (s *Send) Execute(args []string) error {
@@ -27,7 +74,55 @@ func (s *Send) Execute(args []string) error {
 
 	addrs := strings.Split(s.Addr, ",")
 
-	tcp, err := NewTCPSend(addrs, window, buffer)
+	offers, err := compressionOffers(s.Compression)
+	if err != nil {
+		return err
+	}
+
+	copts, err := cypress.NewCompressionOptions(s.ZstdLevel, s.ZstdDictionary)
+	if err != nil {
+		return err
+	}
+
+	opts := SendOptions{
+		TLS:                s.config(),
+		Compressions:       offers,
+		CompressionOptions: copts,
+		Reliable: cypress.ReliableOptions{
+			MinBackoff:     s.Backoff,
+			MaxBackoff:     s.MaxBackoff,
+			Jitter:         BackoffJitter,
+			MaxOutstanding: s.MaxOutstanding,
+		},
+		QueueDir: s.QueueDir,
+	}
+
+	if s.AuthKey != "" {
+		key, err := keystore.Default().GetPrivate(s.AuthKey)
+		if err != nil {
+			return err
+		}
+
+		opts.AuthKey = key
+	}
+
+	opts.Sequenced, err = ParseProtocol(s.Protocol)
+	if err != nil {
+		return err
+	}
+
+	mode, err := ParseBalanceMode(s.Mode)
+	if err != nil {
+		return err
+	}
+
+	opts.HealthInterval = s.HealthInterval
+
+	tcp, err := NewTCPBalancer(addrs, window, buffer, BalanceOptions{
+		SendOptions: opts,
+		Mode:        mode,
+		HashKey:     s.HashKey,
+	})
 	if err != nil {
 		return err
 	}
@@ -41,11 +136,45 @@ func (s *Send) Execute(args []string) error {
 }
 
 type Recv struct {
-	Listen string `short:"l" long:"listen" description:"host:port to listen on"`
+	Listen      string        `short:"l" long:"listen" description:"host:port to listen on"`
+	Dedup       bool          `long:"dedup" description:"drop messages resent after a reconnect"`
+	DedupWindow time.Duration `long:"dedup-window" description:"how long to remember messages to drop resends of"`
+	DedupSize   int           `long:"dedup-size" description:"how many messages to remember to drop resends of"`
+
+	Authenticate bool `long:"authenticate" description:"require senders to authenticate with a keystore key"`
+
+	Compression    string `short:"c" long:"compression" description:"comma separated compressions to accept from senders, default all"`
+	ZstdDictionary string `long:"zstd-dictionary" description:"path of the dictionary senders compress with"`
+
+	TLSOptions
 }
 
 func (r *Recv) Execute(args []string) error {
-	tcp, err := NewTCPRecvGenerator(r.Listen)
+	copts, err := cypress.NewCompressionOptions(0, r.ZstdDictionary)
+	if err != nil {
+		return err
+	}
+
+	accepted, err := cypress.ParseCompressions(r.Compression)
+	if err != nil {
+		return err
+	}
+
+	opts := RecvOptions{
+		TLS:                r.config(),
+		CompressionOptions: copts,
+		Compressions:       accepted,
+	}
+
+	if r.Dedup {
+		opts.Dedup = NewDedup(r.DedupWindow, r.DedupSize)
+	}
+
+	if r.Authenticate {
+		opts.Keys = keystore.Default()
+	}
+
+	tcp, err := NewTCPRecvGeneratorOptions(r.Listen, opts)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vektra/cypress"
+)
+
+const (
+	// How long messages are remembered if not configured
+	DefaultDedupWindow = 10 * time.Minute
+
+	// How many messages are remembered if not configured
+	DefaultDedupSize = 100000
+)
+
+// Remembers the messages received from each sender so that a message
+// resent after a reconnect is only generated once. Messages are
+// identified by the sender id and sequence number of the sequenced
+// protocol, so streams from older senders pass through untouched.
+// The sender id is picked by the sender, so when the sender proved who
+// it is, by a key or TLS client certificate, that identity is part of
+// the key too. Messages are forgotten once they're older than Window or more than
+// Size messages have been seen since.
+type Dedup struct {
+	Window time.Duration
+	Size   int
+
+	lock  sync.Mutex
+	seen  map[dedupKey]*list.Element
+	order *list.List
+
+	duplicates uint64
+}
+
+type dedupKey struct {
+	identity string
+	sender   string
+	seq      uint64
+}
+
+type dedupEntry struct {
+	key dedupKey
+	at  time.Time
+}
+
+// Create a Dedup, using the defaults for window or size if they're 0
+func NewDedup(window time.Duration, size int) *Dedup {
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+
+	if size <= 0 {
+		size = DefaultDedupSize
+	}
+
+	return &Dedup{
+		Window: window,
+		Size:   size,
+		seen:   make(map[dedupKey]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// Indicates if the message numbered seq from sender has been seen
+// already, remembering it if not.
+func (d *Dedup) Seen(sender string, seq uint64) bool {
+	return d.SeenFrom("", sender, seq)
+}
+
+// Like Seen, for a sender that proved it's identity. Messages from
+// different identities are never duplicates of each other.
+func (d *Dedup) SeenFrom(identity, sender string, seq uint64) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+
+	d.expire(now)
+
+	key := dedupKey{identity, sender, seq}
+
+	if _, ok := d.seen[key]; ok {
+		atomic.AddUint64(&d.duplicates, 1)
+		return true
+	}
+
+	d.seen[key] = d.order.PushBack(dedupEntry{key, now})
+
+	for d.order.Len() > d.Size {
+		d.forget(d.order.Front())
+	}
+
+	return false
+}
+
+// Forget the messages seen longer ago than the window
+func (d *Dedup) expire(now time.Time) {
+	cutoff := now.Add(-d.Window)
+
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		if e.Value.(dedupEntry).at.After(cutoff) {
+			return
+		}
+
+		d.forget(e)
+	}
+}
+
+func (d *Dedup) forget(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.seen, e.Value.(dedupEntry).key)
+}
+
+// How many duplicate messages have been dropped
+func (d *Dedup) Duplicates() uint64 {
+	return atomic.LoadUint64(&d.duplicates)
+}
+
+// A Recv that skips the messages its Dedup has seen. Duplicates are
+// still acked, so the sender stops resending them.
+type dedupRecv struct {
+	*cypress.Recv
+	dedup *Dedup
+
+	// the name of the TLS peer, if it had a certificate
+	peer string
+}
+
+// Who the sender proved it is, so that a sender can't reuse another's
+// sender id to have it's messages dropped
+func (d *dedupRecv) identity() string {
+	if id := d.KeyID(); id != "" {
+		return "key " + id
+	}
+
+	if d.peer != "" {
+		return "peer " + d.peer
+	}
+
+	return ""
+}
+
+func (d *dedupRecv) Generate() (*cypress.Message, error) {
+	for {
+		m, err := d.Recv.Generate()
+		if err != nil {
+			return nil, err
+		}
+
+		sender := d.SenderID()
+
+		if sender == "" || !d.dedup.SeenFrom(d.identity(), sender, d.Sequence()) {
+			return m, nil
+		}
+	}
+}
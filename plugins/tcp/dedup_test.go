@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestDedup(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("remembers messages by sender and number", func() {
+		d := NewDedup(0, 0)
+
+		assert.False(t, d.Seen("a", 1))
+		assert.True(t, d.Seen("a", 1))
+		assert.False(t, d.Seen("a", 2))
+		assert.False(t, d.Seen("b", 1))
+
+		assert.Equal(t, uint64(1), d.Duplicates())
+	})
+
+	n.It("keeps the messages of each identity apart", func() {
+		d := NewDedup(0, 0)
+
+		assert.False(t, d.SeenFrom("key a", "s", 1))
+		assert.False(t, d.SeenFrom("key b", "s", 1))
+		assert.False(t, d.Seen("s", 1))
+		assert.True(t, d.SeenFrom("key a", "s", 1))
+	})
+
+	n.It("forgets messages older than the window", func() {
+		d := NewDedup(10*time.Millisecond, 0)
+
+		assert.False(t, d.Seen("a", 1))
+
+		time.Sleep(20 * time.Millisecond)
+
+		assert.False(t, d.Seen("a", 1))
+	})
+
+	n.It("remembers at most size messages", func() {
+		d := NewDedup(0, 2)
+
+		d.Seen("a", 1)
+		d.Seen("a", 2)
+		d.Seen("a", 3)
+
+		assert.False(t, d.Seen("a", 1))
+		assert.True(t, d.Seen("a", 3))
+	})
+
+	n.It("drops messages resent on a new connection", func() {
+		gen, err := NewDedupTCPRecvGenerator(":0", NewDedup(0, 0))
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		addr := gen.l.Addr().String()
+
+		send := func(seqs ...uint64) {
+			c, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+
+			defer c.Close()
+
+			enc := cypress.NewStreamEncoder(c)
+
+			err = enc.WriteCustomHeader(&cypress.StreamHeader{
+				Mode:     cypress.StreamHeader_RELIABLE.Enum(),
+				Version:  proto.Uint32(cypress.ReliableSequenced),
+				SenderId: proto.String("sender"),
+			})
+			require.NoError(t, err)
+
+			for _, seq := range seqs {
+				m := cypress.Log()
+				m.Add("seq", seq)
+
+				err = enc.ReceiveSequenced(seq, m)
+				require.NoError(t, err)
+			}
+
+			err = enc.Flush()
+			require.NoError(t, err)
+
+			// wait for the acks, so the messages have been read
+			buf := make([]byte, 64)
+
+			var acked int
+
+			for acked < len(seqs) {
+				n, err := c.Read(buf)
+				require.NoError(t, err)
+
+				for _, b := range buf[:n] {
+					if b == 'a' {
+						acked++
+					}
+				}
+			}
+		}
+
+		send(1, 2)
+		send(2, 3)
+
+		for _, seq := range []int64{1, 2, 3} {
+			select {
+			case m := <-gen.buf:
+				v, ok := m.GetInt("seq")
+				require.True(t, ok)
+
+				assert.Equal(t, seq, v)
+			case <-time.After(1 * time.Second):
+				t.Fatal("message wasn't generated")
+			}
+		}
+
+		select {
+		case m := <-gen.buf:
+			t.Fatalf("duplicate message generated: %s", m)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		assert.Equal(t, uint64(1), gen.Dedup.Duplicates())
+	})
+
+	n.Meow()
+}
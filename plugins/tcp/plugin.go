@@ -1,9 +1,77 @@
 package tcp
 
-import "github.com/vektra/cypress"
+import (
+	"time"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/keystore"
+)
 
 type TCPPlugin struct {
 	Address string `description:"host:port to listen (input) or send to (output)"`
+
+	Hosts          []string `description:"host:ports to send to, in order of priority for failover (output)"`
+	Mode           string   `description:"random, failover, round-robin or hash, default random (output)"`
+	HashKey        string   `toml:"hash_key" description:"field to hash messages on, such as session_id (output)"`
+	HealthInterval string   `toml:"health_interval" description:"how often to check if a preferred host is back, default 10s (output)"`
+
+	MaxOutstanding int    `toml:"max_outstanding" description:"most messages to hold that aren't acked, waiting for acks at the limit (output)"`
+	Backoff        string `description:"how long to wait to reconnect after the first failure, doubling after each, default 1s (output)"`
+	MaxBackoff     string `toml:"max_backoff" description:"longest to wait to reconnect, default 30s (output)"`
+	QueueDir       string `toml:"queue_dir" description:"directory to keep messages in until they're acked, so they survive restarts (output)"`
+	Protocol       string `description:"legacy, or sequenced so receivers can drop resent messages, which needs receivers that understand it, default legacy (output)"`
+
+	Dedup       bool   `description:"drop messages resent after a reconnect (input)"`
+	DedupWindow string `toml:"dedup_window" description:"how long to remember messages to drop resends of, default 10m"`
+	DedupSize   int    `toml:"dedup_size" description:"how many messages to remember to drop resends of, default 100000"`
+
+	TLS        bool     `description:"use TLS, implied by cert and ca"`
+	Cert       string   `description:"PEM certificate, the server's (input) or the client's (output)"`
+	Key        string   `description:"PEM key for cert"`
+	CA         string   `description:"PEM CA certificates to verify the remote side with, required of clients if set (input)"`
+	ServerName string   `toml:"server_name" description:"name to verify the server's certificate with, default the host (output)"`
+	Peers      []string `description:"names allowed in the remote side's certificate"`
+	PeerTag    string   `toml:"peer_tag" description:"tag to add to messages with the name in the client's certificate (input)"`
+
+	AuthKey      string `toml:"auth_key" description:"keystore key to authenticate streams with (output)"`
+	Authenticate bool   `description:"require senders to authenticate with a keystore key (input)"`
+
+	Compression    string `description:"comma separated compressions to offer in order of preference (output) or to accept, default all (input), from none, snappy, zlib, zstd and lz4"`
+	ZstdLevel      int    `toml:"zstd_level" description:"zstd level from 1 (fastest) to 22 (smallest) (output)"`
+	ZstdDictionary string `toml:"zstd_dictionary" description:"path of a dictionary trained with zstd --train"`
+}
+
+// The compressions to offer from a comma separated list, nil if it has
+// none besides "none" so that streams aren't negotiated
+func compressionOffers(list string) ([]cypress.StreamHeader_Compression, error) {
+	comps, err := cypress.ParseCompressions(list)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, comp := range comps {
+		if comp != cypress.NONE {
+			return comps, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// The TLS settings, nil if TLS isn't used
+func (t *TCPPlugin) tlsConfig() *TLSConfig {
+	if !t.TLS && t.Cert == "" && t.CA == "" {
+		return nil
+	}
+
+	return &TLSConfig{
+		Cert:       t.Cert,
+		Key:        t.Key,
+		CA:         t.CA,
+		ServerName: t.ServerName,
+		Peers:      t.Peers,
+		PeerTag:    t.PeerTag,
+	}
 }
 
 func (t *TCPPlugin) Description() string {
@@ -11,11 +79,119 @@ func (t *TCPPlugin) Description() string {
 }
 
 func (r *TCPPlugin) Receiver() (cypress.Receiver, error) {
-	return NewTCPSend([]string{r.Address}, 0, DefaultTCPBuffer)
+	offers, err := compressionOffers(r.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	copts, err := cypress.NewCompressionOptions(r.ZstdLevel, r.ZstdDictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := SendOptions{
+		TLS:                r.tlsConfig(),
+		Compressions:       offers,
+		CompressionOptions: copts,
+		Reliable: cypress.ReliableOptions{
+			Jitter:         BackoffJitter,
+			MaxOutstanding: r.MaxOutstanding,
+		},
+		QueueDir: r.QueueDir,
+	}
+
+	if r.Backoff != "" {
+		opts.Reliable.MinBackoff, err = time.ParseDuration(r.Backoff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.MaxBackoff != "" {
+		opts.Reliable.MaxBackoff, err = time.ParseDuration(r.MaxBackoff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.AuthKey != "" {
+		key, err := keystore.Default().GetPrivate(r.AuthKey)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.AuthKey = key
+	}
+
+	opts.Sequenced, err = ParseProtocol(r.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	bopts := BalanceOptions{
+		SendOptions: opts,
+		HashKey:     r.HashKey,
+	}
+
+	bopts.Mode, err = ParseBalanceMode(r.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	bopts.HealthInterval = DefaultHealthInterval
+
+	if r.HealthInterval != "" {
+		bopts.HealthInterval, err = time.ParseDuration(r.HealthInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := r.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{r.Address}
+	}
+
+	return NewTCPBalancer(hosts, 0, DefaultTCPBuffer, bopts)
 }
 
 func (r *TCPPlugin) Generator() (cypress.Generator, error) {
-	return NewTCPRecvGenerator(r.Address)
+	accepted, err := cypress.ParseCompressions(r.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	copts, err := cypress.NewCompressionOptions(0, r.ZstdDictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := RecvOptions{
+		TLS:                r.tlsConfig(),
+		CompressionOptions: copts,
+		Compressions:       accepted,
+	}
+
+	if r.Authenticate {
+		opts.Keys = keystore.Default()
+	}
+
+	if r.Dedup {
+		var window time.Duration
+
+		if r.DedupWindow != "" {
+			var err error
+
+			window, err = time.ParseDuration(r.DedupWindow)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		opts.Dedup = NewDedup(window, r.DedupSize)
+	}
+
+	return NewTCPRecvGeneratorOptions(r.Address, opts)
 }
 
 func init() {
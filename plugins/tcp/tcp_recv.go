@@ -1,16 +1,34 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"io"
 	"net"
 
 	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/keystore"
 )
 
 type TCPRecv struct {
 	Addr    string
 	Handler cypress.GeneratorHandler
 
+	// If set, messages resent after a reconnect are dropped
+	Dedup *Dedup
+
+	// If set, connections must use TLS
+	TLS *TLSConfig
+
+	// If set, senders must authenticate with one of these keys
+	Keys keystore.Keys
+
+	// Settings to read compressed streams with, such as a zstd dictionary
+	CompressionOptions *cypress.CompressionOptions
+
+	// The compressions to pick from when senders offer them, all
+	// supported ones if nil
+	Compressions []cypress.StreamHeader_Compression
+
 	l net.Listener
 }
 
@@ -28,6 +46,16 @@ func (t *TCPRecv) Listen() error {
 		return err
 	}
 
+	if t.TLS != nil {
+		cfg, err := t.TLS.serverConfig()
+		if err != nil {
+			l.Close()
+			return err
+		}
+
+		l = tls.NewListener(l, cfg)
+	}
+
 	t.l = l
 
 	return nil
@@ -56,14 +84,50 @@ func (t *TCPRecv) ListenAndAccept() error {
 }
 
 func (t *TCPRecv) handle(c net.Conn) {
-	recv, err := cypress.NewRecv(c)
+	defer c.Close()
+
+	var peer string
+
+	if tc, ok := c.(*tls.Conn); ok {
+		var err error
+
+		peer, err = t.TLS.checkPeer(tc)
+		if err != nil {
+			return
+		}
+	}
+
+	var (
+		recv *cypress.Recv
+		err  error
+	)
+
+	if t.Keys != nil {
+		recv, err = cypress.NewAuthRecv(c, t.Keys)
+	} else {
+		recv, err = cypress.NewRecv(c)
+	}
+
 	if err != nil {
 		return
 	}
 
+	recv.SetCompressionOptions(t.CompressionOptions)
+	recv.SetAcceptedCompressions(t.Compressions)
+
 	defer recv.Close()
 
-	t.Handler.HandleGenerator(recv)
+	var g cypress.Generator = recv
+
+	if t.Dedup != nil {
+		g = &dedupRecv{recv, t.Dedup, peer}
+	}
+
+	if t.TLS != nil && t.TLS.PeerTag != "" && peer != "" {
+		g = &peerTagger{g, t.TLS.PeerTag, peer}
+	}
+
+	t.Handler.HandleGenerator(g)
 }
 
 type TCPRecvGenerator struct {
@@ -73,6 +137,34 @@ type TCPRecvGenerator struct {
 }
 
 func NewTCPRecvGenerator(host string) (*TCPRecvGenerator, error) {
+	return NewDedupTCPRecvGenerator(host, nil)
+}
+
+// Create a TCPRecvGenerator that drops messages dedup has seen
+func NewDedupTCPRecvGenerator(host string, dedup *Dedup) (*TCPRecvGenerator, error) {
+	return NewTCPRecvGeneratorOptions(host, RecvOptions{Dedup: dedup})
+}
+
+// Optional behavior of a TCPRecvGenerator
+type RecvOptions struct {
+	// Drop messages this has seen
+	Dedup *Dedup
+
+	// Require TLS on connections
+	TLS *TLSConfig
+
+	// Require senders to authenticate with one of these keys
+	Keys keystore.Keys
+
+	// Settings to read compressed streams with
+	CompressionOptions *cypress.CompressionOptions
+
+	// Only pick these compressions when senders offer them
+	Compressions []cypress.StreamHeader_Compression
+}
+
+// Create a TCPRecvGenerator with the behavior in opts
+func NewTCPRecvGeneratorOptions(host string, opts RecvOptions) (*TCPRecvGenerator, error) {
 	g := &TCPRecvGenerator{
 		buf: make(chan *cypress.Message, 10),
 	}
@@ -83,6 +175,11 @@ func NewTCPRecvGenerator(host string) (*TCPRecvGenerator, error) {
 	}
 
 	g.TCPRecv = tcp
+	g.Dedup = opts.Dedup
+	g.TLS = opts.TLS
+	g.Keys = opts.Keys
+	g.CompressionOptions = opts.CompressionOptions
+	g.Compressions = opts.Compressions
 
 	err = g.Listen()
 	if err != nil {
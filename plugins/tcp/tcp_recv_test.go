@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/keystore"
 	"github.com/vektra/neko"
 )
 
@@ -90,5 +91,39 @@ func TestTCPRecv(t *testing.T) {
 
 	})
 
+	n.It("tags messages from authenticated senders", func() {
+		var keys keystore.TestKeys
+		keys.Gen()
+
+		gen, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{Keys: &keys})
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		s, err := NewTCPSendOptions([]string{gen.l.Addr().String()}, 0, DefaultTCPBuffer, SendOptions{
+			AuthKey: keys.Key,
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := gen.Generate()
+		require.NoError(t, err)
+
+		id, ok := m2.GetTag(cypress.AuthKeyTag)
+		require.True(t, ok)
+
+		assert.Equal(t, keystore.KeyId(&keys.Key.PublicKey), id)
+	})
+
 	n.Meow()
 }
@@ -1,11 +1,17 @@
 package tcp
 
 import (
+	"crypto/ecdsa"
+	"crypto/tls"
 	"errors"
 	"math/rand"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/plugins/spool"
+	"gopkg.in/tomb.v2"
 )
 
 type TCPSend struct {
@@ -13,29 +19,154 @@ type TCPSend struct {
 
 	hosts  []string
 	window int
-	c      net.Conn
+	tls    *TLSConfig
+	key    *ecdsa.PrivateKey
+	comp   cypress.StreamHeader_Compression
+	opts   *cypress.CompressionOptions
+	offers []cypress.StreamHeader_Compression
+
+	sequenced bool
+
+	failover bool
+	health   time.Duration
+
+	lock    sync.Mutex
+	c       net.Conn
+	current int
+
+	t tomb.Tomb
 }
 
 const DefaultTCPBuffer = 128
 
+// How much the plugin and cli vary reconnect waits, +/- 20%
+const BackoffJitter = 0.2
+
 func NewTCPSend(hosts []string, window, buffer int) (*TCPSend, error) {
+	return NewTLSTCPSend(hosts, window, buffer, nil)
+}
+
+// Create a TCPSend that connects using TLS, if cfg is set
+func NewTLSTCPSend(hosts []string, window, buffer int, cfg *TLSConfig) (*TCPSend, error) {
+	return NewTCPSendOptions(hosts, window, buffer, SendOptions{TLS: cfg})
+}
+
+// Optional behavior of a TCPSend
+type SendOptions struct {
+	// Connect using TLS
+	TLS *TLSConfig
+
+	// Authenticate each stream with this key
+	AuthKey *ecdsa.PrivateKey
+
+	// Compress each stream, using CompressionOptions for it's settings
+	Compression        cypress.StreamHeader_Compression
+	CompressionOptions *cypress.CompressionOptions
+
+	// Offer these compressions to the remote side in order of
+	// preference, using the one it picks in place of Compression. The
+	// remote side must understand negotiation.
+	Compressions []cypress.StreamHeader_Compression
+
+	// Number messages with the sequenced protocol, so a receiver
+	// can drop ones resent after a reconnect. Receivers from before
+	// the protocol existed can't read it.
+	Sequenced bool
+
+	// Connect to the hosts in the order given rather than a random
+	// one, so the first that's up is always used
+	Failover bool
+
+	// With Failover, how often to check the hosts before the connected
+	// one, reconnecting to the first that's up again
+	HealthInterval time.Duration
+
+	// Backoff, limits and state reporting of the ReliableSend
+	Reliable cypress.ReliableOptions
+
+	// If set, messages are kept in a spool.Queue in this directory until
+	// they're acked, so they're sent after a restart
+	QueueDir string
+}
+
+// Create a TCPSend with the behavior in opts
+func NewTCPSendOptions(hosts []string, window, buffer int, opts SendOptions) (*TCPSend, error) {
+	// catch bad certificates now rather than failing every connect
+	if opts.TLS != nil {
+		_, err := opts.TLS.clientConfig("")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	tcp := &TCPSend{
 		hosts:  hosts,
 		window: window,
+		tls:    opts.TLS,
+		key:    opts.AuthKey,
+		comp:   opts.Compression,
+		opts:   opts.CompressionOptions,
+		offers: opts.Compressions,
+
+		sequenced: opts.Sequenced,
+
+		failover: opts.Failover,
+		health:   opts.HealthInterval,
+	}
+
+	if opts.QueueDir != "" {
+		q, err := spool.NewQueue(opts.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Reliable.Queue = q
 	}
 
-	tcp.ReliableSend = cypress.NewReliableSend(tcp, buffer)
+	tcp.ReliableSend = cypress.NewReliableSendOptions(tcp, buffer, opts.Reliable)
 
 	err := tcp.Start()
 	if err != nil {
+		if opts.Reliable.Queue != nil {
+			opts.Reliable.Queue.Close()
+		}
+
 		return nil, err
 	}
 
+	if tcp.failover && tcp.health > 0 {
+		tcp.t.Go(tcp.checkHealth)
+	}
+
 	return tcp, nil
 }
 
+func (t *TCPSend) Close() error {
+	if t.failover && t.health > 0 {
+		t.t.Kill(nil)
+		t.t.Wait()
+	}
+
+	return t.ReliableSend.Close()
+}
+
 var ErrNoAvailableHosts = errors.New("no available hosts")
 
+var ErrUnknownProtocol = errors.New("unknown protocol")
+
+// Indicates if name, legacy or sequenced, is the sequenced protocol. An
+// empty name is legacy.
+func ParseProtocol(name string) (bool, error) {
+	switch name {
+	case "", "legacy":
+		return false, nil
+	case "sequenced":
+		return true, nil
+	default:
+		return false, ErrUnknownProtocol
+	}
+}
+
 func shuffle(a []string) {
 	for i := range a {
 		j := rand.Intn(i + 1)
@@ -44,25 +175,110 @@ func shuffle(a []string) {
 }
 
 func (t *TCPSend) Connect() (*cypress.Send, error) {
-	shuffle(t.hosts)
+	if !t.failover {
+		shuffle(t.hosts)
+	}
 
-	for _, host := range t.hosts {
-		c, err := net.Dial("tcp", host)
+	for idx, host := range t.hosts {
+		c, err := t.dial(host)
 		if err != nil {
 			continue
 		}
 
-		s := cypress.NewSend(c, t.window)
+		s := cypress.NewSendOptions(c, t.window, cypress.SendOptions{
+			Session:      t.Session(),
+			AuthKey:      t.key,
+			Compressions: t.offers,
+			Legacy:       !t.sequenced,
+		})
+
+		s.SetCompression(t.comp, t.opts)
+
 		err = s.SendHandshake()
 		if err != nil {
 			c.Close()
 			continue
 		}
 
+		t.lock.Lock()
 		t.c = c
+		t.current = idx
+		t.lock.Unlock()
 
 		return s, nil
 	}
 
 	return nil, ErrNoAvailableHosts
 }
+
+func (t *TCPSend) dial(host string) (net.Conn, error) {
+	if t.tls == nil {
+		return net.Dial("tcp", host)
+	}
+
+	cfg, err := t.tls.clientConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := tls.Dial("tcp", host, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.tls.checkPeer(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// How long a health check waits to connect to a host
+const healthTimeout = 5 * time.Second
+
+func (t *TCPSend) checkHealth() error {
+	tick := time.NewTicker(t.health)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			t.rebalance()
+		case <-t.t.Dying():
+			return nil
+		}
+	}
+}
+
+// If a host before the connected one is up, close the connection. The
+// messages in flight are nacked and the ReliableSend reconnects, which
+// tries the hosts in order.
+func (t *TCPSend) rebalance() {
+	t.lock.Lock()
+	c, current := t.c, t.current
+	t.lock.Unlock()
+
+	if c == nil {
+		return
+	}
+
+	for _, host := range t.hosts[:current] {
+		if hostUp(host) {
+			c.Close()
+			return
+		}
+	}
+}
+
+func hostUp(host string) bool {
+	c, err := net.DialTimeout("tcp", host, healthTimeout)
+	if err != nil {
+		return false
+	}
+
+	c.Close()
+
+	return true
+}
@@ -55,6 +55,60 @@ func TestTCPSend(t *testing.T) {
 		assert.Equal(t, m, recvMesg)
 	})
 
+	n.It("uses the sequenced protocol only when asked to", func() {
+		l, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+
+		defer l.Close()
+
+		senderID := func(opts SendOptions) string {
+			var (
+				id string
+				wg sync.WaitGroup
+			)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				defer c.Close()
+
+				recv, err := cypress.NewRecv(c)
+				if err != nil {
+					return
+				}
+
+				_, err = recv.Generate()
+				if err != nil {
+					return
+				}
+
+				id = recv.SenderID()
+			}()
+
+			tcp, err := NewTCPSendOptions([]string{l.Addr().String()}, 0, 0, opts)
+			require.NoError(t, err)
+
+			err = tcp.Receive(cypress.Log())
+			require.NoError(t, err)
+
+			err = tcp.Close()
+			require.NoError(t, err)
+
+			wg.Wait()
+
+			return id
+		}
+
+		assert.Equal(t, "", senderID(SendOptions{}))
+		assert.NotEqual(t, "", senderID(SendOptions{Sequenced: true}))
+	})
+
 	n.It("tries servers until it finds one that works", func() {
 		l, err := net.Listen("tcp", ":0")
 		require.NoError(t, err)
@@ -422,5 +476,75 @@ func TestTCPSend(t *testing.T) {
 			assert.NoError(t, sent[i].VerboseEqual(remote[i]))
 		}
 	})
+	n.It("compresses the stream", func() {
+		gen, err := NewTCPRecvGenerator(":0")
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		tcp, err := NewTCPSendOptions([]string{gen.l.Addr().String()}, 0, 0, SendOptions{
+			Compression: cypress.ZSTD,
+		})
+		require.NoError(t, err)
+
+		defer tcp.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = tcp.Receive(m)
+		require.NoError(t, err)
+
+		err = tcp.Flush()
+		require.NoError(t, err)
+
+		m2, err := gen.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("negotiates the stream's compression", func() {
+		gen, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{
+			Compressions: []cypress.StreamHeader_Compression{cypress.SNAPPY},
+		})
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		tcp, err := NewTCPSendOptions([]string{gen.l.Addr().String()}, 0, 0, SendOptions{
+			Compressions: []cypress.StreamHeader_Compression{cypress.ZSTD, cypress.SNAPPY},
+		})
+		require.NoError(t, err)
+
+		defer tcp.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = tcp.Receive(m)
+		require.NoError(t, err)
+
+		err = tcp.Flush()
+		require.NoError(t, err)
+
+		m2, err := gen.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("only negotiates when offering a compression", func() {
+		comps, err := compressionOffers("none")
+		require.NoError(t, err)
+
+		assert.Nil(t, comps)
+
+		comps, err = compressionOffers("zstd,none")
+		require.NoError(t, err)
+
+		assert.Equal(t, []cypress.StreamHeader_Compression{cypress.ZSTD, cypress.NONE}, comps)
+	})
+
 	n.Meow()
 }
@@ -0,0 +1,191 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+
+	"github.com/vektra/cypress"
+)
+
+var (
+	ErrNoCertificate  = errors.New("a certificate and key are required to accept TLS")
+	ErrBadCA          = errors.New("no certificates found in CA file")
+	ErrPeerNotAllowed = errors.New("peer certificate has no allowed name")
+)
+
+// How the tcp input and output use TLS. All paths are to PEM files.
+type TLSConfig struct {
+	// The input's server certificate, or the output's client
+	// certificate
+	Cert string
+	Key  string
+
+	// The CAs to verify the remote side with. On the input, setting CA
+	// requires clients to present a certificate signed by one of them.
+	// The output uses the system's CAs if unset.
+	CA string
+
+	// The name to verify the server's certificate with, the host
+	// connected to if unset (output)
+	ServerName string
+
+	// If set, the remote side's certificate must have one of these as
+	// it's common name or one of it's DNS names
+	Peers []string
+
+	// If set, received messages are tagged with this tag and the name
+	// of the client's certificate (input)
+	PeerTag string
+}
+
+func (c *TLSConfig) loadCA() (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrBadCA
+	}
+
+	return pool, nil
+}
+
+// The tls.Config for accepting connections
+func (c *TLSConfig) serverConfig() (*tls.Config, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, ErrNoCertificate
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if c.CA != "" {
+		cfg.ClientCAs, err = c.loadCA()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// The tls.Config for connecting to host
+func (c *TLSConfig) clientConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: c.ServerName,
+	}
+
+	if cfg.ServerName == "" {
+		name, _, err := net.SplitHostPort(host)
+		if err != nil {
+			name = host
+		}
+
+		cfg.ServerName = name
+	}
+
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CA != "" {
+		var err error
+
+		cfg.RootCAs, err = c.loadCA()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// The name identifying the owner of cert, it's common name or it's
+// first DNS name if it has no common name.
+func certName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
+
+// Finish the handshake on conn and check the remote side is one of
+// the allowed peers, returning the name in it's certificate.
+func (c *TLSConfig) checkPeer(conn *tls.Conn) (string, error) {
+	err := conn.Handshake()
+	if err != nil {
+		return "", err
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+
+	if len(certs) == 0 {
+		if len(c.Peers) > 0 {
+			return "", ErrPeerNotAllowed
+		}
+
+		return "", nil
+	}
+
+	cert := certs[0]
+
+	if len(c.Peers) == 0 {
+		return certName(cert), nil
+	}
+
+	for _, peer := range c.Peers {
+		if peer == cert.Subject.CommonName {
+			return certName(cert), nil
+		}
+
+		for _, name := range cert.DNSNames {
+			if peer == name {
+				return certName(cert), nil
+			}
+		}
+	}
+
+	return "", ErrPeerNotAllowed
+}
+
+// A Generator that tags each message with the name of the peer that
+// sent it
+type peerTagger struct {
+	cypress.Generator
+	tag, name string
+}
+
+func (p *peerTagger) Generate() (*cypress.Message, error) {
+	m, err := p.Generator.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	m.AddTag(p.tag, p.name)
+
+	return m, nil
+}
@@ -0,0 +1,251 @@
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+// Writes a CA and certificates signed by it to a temp dir
+type testPKI struct {
+	t   *testing.T
+	dir string
+
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "tcp-tls")
+	require.NoError(t, err)
+
+	p := &testPKI{t: t, dir: dir}
+
+	p.ca, p.caKey = p.issue("ca", "test ca", true)
+
+	return p
+}
+
+func (p *testPKI) Close() {
+	os.RemoveAll(p.dir)
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func (p *testPKI) write(name, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+
+	err := ioutil.WriteFile(p.path(name), data, 0600)
+	require.NoError(p.t, err)
+}
+
+// Create name.crt and name.key, signed by the CA unless isCA
+func (p *testPKI) issue(name, cn string, isCA bool, dns ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(p.t, err)
+
+	p.serial++
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dns,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	parent, signer := tmpl, key
+
+	if !isCA {
+		parent, signer = p.ca, p.caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	require.NoError(p.t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(p.t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(p.t, err)
+
+	p.write(name+".crt", "CERTIFICATE", der)
+	p.write(name+".key", "EC PRIVATE KEY", keyDer)
+
+	return cert, key
+}
+
+func TestTLS(t *testing.T) {
+	n := neko.Start(t)
+
+	var pki *testPKI
+
+	n.Setup(func() {
+		pki = newTestPKI(t)
+		pki.issue("server", "server", false, "localhost")
+		pki.issue("client", "client", false)
+	})
+
+	n.Cleanup(func() {
+		pki.Close()
+	})
+
+	localAddr := func(gen *TCPRecvGenerator) string {
+		_, port, err := net.SplitHostPort(gen.l.Addr().String())
+		require.NoError(t, err)
+
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+
+	n.It("sends messages over TLS", func() {
+		gen, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{
+			TLS: &TLSConfig{
+				Cert: pki.path("server.crt"),
+				Key:  pki.path("server.key"),
+			},
+		})
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		s, err := NewTLSTCPSend([]string{localAddr(gen)}, 0, DefaultTCPBuffer, &TLSConfig{
+			CA:         pki.path("ca.crt"),
+			ServerName: "localhost",
+			Peers:      []string{"server"},
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := gen.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+	})
+
+	n.It("tags messages with the client's name", func() {
+		gen, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{
+			TLS: &TLSConfig{
+				Cert:    pki.path("server.crt"),
+				Key:     pki.path("server.key"),
+				CA:      pki.path("ca.crt"),
+				Peers:   []string{"client"},
+				PeerTag: "peer",
+			},
+		})
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		s, err := NewTLSTCPSend([]string{localAddr(gen)}, 0, DefaultTCPBuffer, &TLSConfig{
+			Cert: pki.path("client.crt"),
+			Key:  pki.path("client.key"),
+			CA:   pki.path("ca.crt"),
+		})
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		m := cypress.Log()
+		m.Add("hello", "world")
+
+		err = s.Receive(m)
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		m2, err := gen.Generate()
+		require.NoError(t, err)
+
+		peer, ok := m2.GetTag("peer")
+		require.True(t, ok)
+
+		assert.Equal(t, "client", peer)
+	})
+
+	n.It("rejects clients without an allowed certificate", func() {
+		gen, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{
+			TLS: &TLSConfig{
+				Cert:  pki.path("server.crt"),
+				Key:   pki.path("server.key"),
+				CA:    pki.path("ca.crt"),
+				Peers: []string{"someone-else"},
+			},
+		})
+		require.NoError(t, err)
+
+		defer gen.Close()
+
+		cert, err := tls.LoadX509KeyPair(pki.path("client.crt"), pki.path("client.key"))
+		require.NoError(t, err)
+
+		c, err := tls.Dial("tcp", localAddr(gen), &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		})
+		require.NoError(t, err)
+
+		defer c.Close()
+
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = c.Read(make([]byte, 1))
+		require.Error(t, err)
+
+		ne, ok := err.(net.Error)
+		assert.False(t, ok && ne.Timeout(), "connection was not closed")
+	})
+
+	n.It("checks the certificates before connecting", func() {
+		_, err := NewTLSTCPSend([]string{"127.0.0.1:0"}, 0, DefaultTCPBuffer, &TLSConfig{
+			CA: pki.path("missing.crt"),
+		})
+
+		assert.Error(t, err)
+	})
+
+	n.It("requires a certificate to listen with", func() {
+		_, err := NewTCPRecvGeneratorOptions(":0", RecvOptions{
+			TLS: &TLSConfig{CA: pki.path("ca.crt")},
+		})
+
+		assert.Equal(t, ErrNoCertificate, err)
+	})
+
+	n.Meow()
+}
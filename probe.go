@@ -8,6 +8,9 @@ type Probe struct {
 
 	Header *StreamHeader
 	Stream io.Reader
+
+	// Settings for the stream's compression, nil for the defaults
+	Options *CompressionOptions
 }
 
 // Create a new Probe from the data in r
@@ -47,11 +50,11 @@ func (p *Probe) Compression() StreamHeader_Compression {
 
 // Create an io.Reader for the remainder of the stream
 func (p *Probe) Reader() io.Reader {
-	return ReadCompressed(p.Stream, p.Compression())
+	return ReadCompressedOptions(p.Stream, p.Compression(), p.Options)
 }
 
 // Create an io.Writer that will match the parameters of the probed
 // stream.
 func (p *Probe) Writer(w io.WriteCloser) io.WriteCloser {
-	return WriteCompressed(w, p.Compression())
+	return WriteCompressedOptions(w, p.Compression(), p.Options)
 }
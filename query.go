@@ -0,0 +1,581 @@
+package cypress
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vektra/errors"
+)
+
+// Error indicating a query could not be parsed
+var ErrBadQuery = errors.New("invalid query")
+
+// A compiled query, used to select messages. Queries compare fields
+// of the message against values and combine the results with
+// and/&&, or/|| and not/!. For example:
+//
+//	severity <= 3 and host =~ web
+//	type == "metric" && tags.env == "prod"
+//	timestamp >= -1h and latency > 250ms
+//
+// The fields available are type, session_id, timestamp, tags.<name>
+// and the message's attributes, either by name or as attrs.<name>.
+// A field on it's own is true if the message has it (or, for a bool
+// attribute, if it's true).
+//
+// Values are strings (quoted, or a bare word), numbers, durations
+// such as 250ms which compare against interval attributes, true and
+// false. The timestamp is compared against a RFC3339 time or date,
+// or a negative duration meaning that long ago.
+//
+// The comparisons are ==, =, !=, <, <=, >, >=, and =~ and !~ which
+// match a regexp.
+type Query struct {
+	Source string
+
+	eval queryFunc
+}
+
+// Parse and compile a query
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.at(tokEOF) {
+		return nil, p.unexpected()
+	}
+
+	return &Query{Source: src, eval: eval}, nil
+}
+
+// Indicates if m satisfies the query
+func (q *Query) Match(m *Message) bool {
+	return q.eval(m)
+}
+
+// Pass through only the messages that match the query
+func (q *Query) Filter(m *Message) (*Message, error) {
+	if q.eval(m) {
+		return m, nil
+	}
+
+	return nil, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type queryParser struct {
+	src    string
+	tokens []token
+	cur    int
+}
+
+var queryOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "=", "!"}
+
+// Word forms of the boolean operators
+var queryWords = map[string]string{
+	"and": "&&",
+	"or":  "||",
+	"not": "!",
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func (p *queryParser) lex() error {
+	s := p.src
+	i := 0
+
+outer:
+	for i < len(s) {
+		c := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")", i})
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			i++
+
+			var buf []byte
+
+			for i < len(s) && rune(s[i]) != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+
+				buf = append(buf, s[i])
+				i++
+			}
+
+			if i >= len(s) {
+				return errors.Subject(ErrBadQuery, fmt.Sprintf("unterminated string at %d", start))
+			}
+
+			i++
+
+			p.tokens = append(p.tokens, token{tokString, string(buf), start})
+		case c == '-' || unicode.IsDigit(c):
+			start := i
+			i++
+
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+
+			kind := tokNumber
+
+			// A unit makes it a duration, eg. 250ms or 1h30m
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				kind = tokDuration
+				i++
+			}
+
+			p.tokens = append(p.tokens, token{kind, s[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+
+			word := s[start:i]
+
+			if op, ok := queryWords[word]; ok {
+				p.tokens = append(p.tokens, token{tokOp, op, start})
+			} else {
+				p.tokens = append(p.tokens, token{tokIdent, word, start})
+			}
+		default:
+			for _, op := range queryOps {
+				if strings.HasPrefix(s[i:], op) {
+					if op == "=" {
+						op = "=="
+					}
+
+					p.tokens = append(p.tokens, token{tokOp, op, i})
+					i += len(op)
+					continue outer
+				}
+			}
+
+			return errors.Subject(ErrBadQuery, fmt.Sprintf("unexpected '%c' at %d", c, i))
+		}
+	}
+
+	p.tokens = append(p.tokens, token{tokEOF, "", len(s)})
+
+	return nil
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.cur]
+
+	if t.kind != tokEOF {
+		p.cur++
+	}
+
+	return t
+}
+
+func (p *queryParser) at(kind tokenKind) bool {
+	return p.peek().kind == kind
+}
+
+func (p *queryParser) atOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *queryParser) unexpected() error {
+	return unexpectedToken(p.peek())
+}
+
+func unexpectedToken(t token) error {
+	if t.kind == tokEOF {
+		return errors.Subject(ErrBadQuery, "unexpected end of query")
+	}
+
+	return errors.Subject(ErrBadQuery, fmt.Sprintf("unexpected '%s' at %d", t.text, t.pos))
+}
+
+type queryFunc func(m *Message) bool
+
+func (p *queryParser) parseOr() (queryFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.atOp("||") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(m *Message) bool { return l(m) || right(m) }
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryFunc, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.atOp("&&") {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(m *Message) bool { return l(m) && right(m) }
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (queryFunc, error) {
+	if p.atOp("!") {
+		p.next()
+
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(m *Message) bool { return !inner(m) }, nil
+	}
+
+	if p.at(tokLParen) {
+		p.next()
+
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.at(tokRParen) {
+			return nil, p.unexpected()
+		}
+
+		p.next()
+
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// A field of the message, returning false if the message doesn't
+// have it.
+type fieldFunc func(m *Message) (interface{}, bool)
+
+func queryField(name string) fieldFunc {
+	switch {
+	case name == "type":
+		return func(m *Message) (interface{}, bool) {
+			return m.StringType(), true
+		}
+	case name == "session_id":
+		return func(m *Message) (interface{}, bool) {
+			if m.SessionId == nil {
+				return nil, false
+			}
+
+			return *m.SessionId, true
+		}
+	case name == "timestamp":
+		return func(m *Message) (interface{}, bool) {
+			if m.Timestamp == nil {
+				return nil, false
+			}
+
+			return m.Timestamp.Time(), true
+		}
+	case strings.HasPrefix(name, "tags."):
+		tag := name[len("tags."):]
+
+		return func(m *Message) (interface{}, bool) {
+			return m.GetTag(tag)
+		}
+	default:
+		attr := strings.TrimPrefix(name, "attrs.")
+
+		return func(m *Message) (interface{}, bool) {
+			val, ok := m.Get(attr)
+
+			switch v := val.(type) {
+			case []byte:
+				return string(v), ok
+			case *Interval:
+				return v.Duration(), ok
+			}
+
+			return val, ok
+		}
+	}
+}
+
+// A point in time relative to when the query is evaluated
+type sinceNow time.Duration
+
+var timeFormats = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// Convert a literal into a value to compare the timestamp against
+func timeValue(lit token) (interface{}, error) {
+	switch lit.kind {
+	case tokDuration:
+		dur, err := time.ParseDuration(lit.text)
+		if err != nil || dur > 0 {
+			break
+		}
+
+		return sinceNow(dur), nil
+	case tokString, tokIdent:
+		for _, format := range timeFormats {
+			t, err := time.Parse(format, lit.text)
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return nil, errors.Subject(ErrBadQuery, fmt.Sprintf("bad time '%s' at %d", lit.text, lit.pos))
+}
+
+func (p *queryParser) parseComparison() (queryFunc, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, unexpectedToken(t)
+	}
+
+	field := queryField(t.text)
+
+	if !p.at(tokOp) || p.atOp("&&") || p.atOp("||") || p.atOp("!") {
+		// A bare field tests for presence, or the value itself if it's a bool
+		return func(m *Message) bool {
+			val, ok := field(m)
+			if b, isBool := val.(bool); isBool {
+				return b
+			}
+
+			return ok
+		}, nil
+	}
+
+	op := p.next().text
+
+	lit := p.next()
+
+	switch op {
+	case "=~", "!~":
+		if lit.kind != tokString && lit.kind != tokIdent {
+			return nil, unexpectedToken(lit)
+		}
+
+		re, err := regexp.Compile(lit.text)
+		if err != nil {
+			return nil, errors.Subject(ErrBadQuery, err.Error())
+		}
+
+		want := op == "=~"
+
+		return func(m *Message) bool {
+			val, ok := field(m)
+			if !ok {
+				return !want
+			}
+
+			return re.MatchString(fmt.Sprint(val)) == want
+		}, nil
+	}
+
+	var value interface{}
+
+	switch {
+	case t.text == "timestamp":
+		var err error
+
+		value, err = timeValue(lit)
+		if err != nil {
+			return nil, err
+		}
+	case lit.kind == tokString:
+		value = lit.text
+	case lit.kind == tokNumber:
+		f, err := strconv.ParseFloat(lit.text, 64)
+		if err != nil {
+			return nil, errors.Subject(ErrBadQuery, fmt.Sprintf("bad number '%s' at %d", lit.text, lit.pos))
+		}
+
+		value = f
+	case lit.kind == tokDuration:
+		dur, err := time.ParseDuration(lit.text)
+		if err != nil {
+			return nil, errors.Subject(ErrBadQuery, fmt.Sprintf("bad duration '%s' at %d", lit.text, lit.pos))
+		}
+
+		value = dur
+	case lit.kind == tokIdent:
+		switch lit.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		default:
+			value = lit.text
+		}
+	default:
+		return nil, unexpectedToken(lit)
+	}
+
+	return func(m *Message) bool {
+		val, ok := field(m)
+		if !ok {
+			return op == "!="
+		}
+
+		cmp, ok := compareValues(val, value)
+		if !ok {
+			return op == "!="
+		}
+
+		switch op {
+		case "==":
+			return cmp == 0
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case ">=":
+			return cmp >= 0
+		default:
+			return false
+		}
+	}, nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Compare a message value to a literal, returning false if they can't
+// be compared.
+func compareValues(val, lit interface{}) (int, bool) {
+	switch l := lit.(type) {
+	case float64:
+		switch v := val.(type) {
+		case int64:
+			return compareFloats(float64(v), l), true
+		case float64:
+			return compareFloats(v, l), true
+		case time.Duration:
+			// plain numbers are seconds when compared to an interval
+			return compareFloats(v.Seconds(), l), true
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, false
+			}
+
+			return compareFloats(f, l), true
+		}
+	case time.Duration:
+		switch v := val.(type) {
+		case time.Duration:
+			return compareFloats(float64(v), float64(l)), true
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return 0, false
+			}
+
+			return compareFloats(float64(d), float64(l)), true
+		}
+	case time.Time:
+		if v, ok := val.(time.Time); ok {
+			return compareFloats(float64(v.Sub(l)), 0), true
+		}
+	case sinceNow:
+		if v, ok := val.(time.Time); ok {
+			return compareFloats(float64(v.Sub(time.Now().Add(time.Duration(l)))), 0), true
+		}
+	case string:
+		return strings.Compare(fmt.Sprint(val), l), true
+	case bool:
+		b, ok := val.(bool)
+		if !ok {
+			return 0, false
+		}
+
+		if b == l {
+			return 0, true
+		}
+
+		return 1, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,124 @@
+package cypress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+	"github.com/vektra/tai64n"
+)
+
+func TestQuery(t *testing.T) {
+	n := neko.Start(t)
+
+	match := func(query string, m *Message) bool {
+		q, err := ParseQuery(query)
+		require.NoError(t, err)
+
+		return q.Match(m)
+	}
+
+	n.It("matches on the message type and tags", func() {
+		m := Metric()
+		m.AddTag("env", "prod")
+
+		assert.True(t, match(`type == "metric" && tags.env == "prod"`, m))
+		assert.False(t, match(`type == "audit" || tags.env == "dev"`, m))
+		assert.True(t, match(`type = metric`, m))
+		assert.True(t, match(`tags.env`, m))
+		assert.False(t, match(`tags.region`, m))
+		assert.True(t, match(`!tags.region`, m))
+		assert.True(t, match(`not tags.region`, m))
+	})
+
+	n.It("compares attributes", func() {
+		m := Log()
+		m.Add("severity", 3)
+		m.Add("host", "web01")
+		m.Add("load", 0.5)
+		m.Add("ok", true)
+
+		assert.True(t, match(`severity <= 3`, m))
+		assert.False(t, match(`attrs.severity > 3`, m))
+		assert.True(t, match(`load < 1`, m))
+		assert.True(t, match(`host =~ "^web"`, m))
+		assert.True(t, match(`host !~ "^db"`, m))
+		assert.True(t, match(`ok`, m))
+		assert.True(t, match(`ok == true && (severity == 1 || host == 'web01')`, m))
+		assert.False(t, match(`missing == 1`, m))
+		assert.True(t, match(`missing != 1`, m))
+	})
+
+	n.It("supports the word forms of the operators", func() {
+		m := Log()
+		m.Add("severity", 2)
+		m.Add("host", "web01")
+
+		assert.True(t, match(`severity<=3 and host=~web`, m))
+		assert.True(t, match(`severity > 5 or not host =~ db`, m))
+		assert.False(t, match(`severity<=3 and host=~db`, m))
+	})
+
+	n.It("compares intervals against durations", func() {
+		m := Trace()
+		m.AddDuration("latency", 300*time.Millisecond)
+
+		assert.True(t, match(`latency > 250ms`, m))
+		assert.False(t, match(`latency > 1s`, m))
+		assert.True(t, match(`latency < 0.5`, m))
+	})
+
+	n.It("compares the timestamp against times", func() {
+		m := Log()
+		m.Timestamp = tai64n.FromTime(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+		assert.True(t, match(`timestamp >= "2015-06-01" and timestamp < "2015-06-02"`, m))
+		assert.True(t, match(`timestamp > "2015-06-01T11:59:00Z"`, m))
+		assert.False(t, match(`timestamp > -1h`, m))
+		assert.True(t, match(`timestamp > -1h`, Log()))
+	})
+
+	n.It("matches on the session id", func() {
+		m := Log()
+		m.For("abc")
+
+		assert.True(t, match(`session_id == "abc"`, m))
+		assert.False(t, match(`session_id == "def"`, Log()))
+	})
+
+	n.It("can be used as a filter", func() {
+		q, err := ParseQuery(`type == "log"`)
+		require.NoError(t, err)
+
+		m := Log()
+
+		m2, err := q.Filter(m)
+		require.NoError(t, err)
+		assert.Equal(t, m, m2)
+
+		m2, err = q.Filter(Metric())
+		require.NoError(t, err)
+		assert.Nil(t, m2)
+	})
+
+	n.It("rejects invalid queries", func() {
+		for _, query := range []string{
+			`type ==`,
+			`(type == "log"`,
+			`type == "log" &&`,
+			`host =~ 3`,
+			`host =~ "("`,
+			`"log" == type`,
+			`type == "log" $`,
+			`timestamp > "yesterday"`,
+			`timestamp > 1h`,
+		} {
+			_, err := ParseQuery(query)
+			assert.Error(t, err, query)
+		}
+	})
+
+	n.Meow()
+}
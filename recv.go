@@ -1,6 +1,11 @@
 package cypress
 
-import "io"
+import (
+	"errors"
+	"io"
+
+	"github.com/vektra/cypress/keystore"
+)
 
 // A type which can recieve a stream of Messages reliabliy.
 // Recv works in coordination with Send to reliablity send Messages
@@ -8,20 +13,92 @@ import "io"
 type Recv struct {
 	rw  io.ReadWriter
 	dec *StreamDecoder
+
+	// Set to require senders to authenticate with one of these keys
+	keys  keystore.Keys
+	keyID string
+
+	opts *CompressionOptions
+
+	// The compressions to pick from when the sender offers them
+	accepted []StreamHeader_Compression
 }
 
 // Create a new Recv, reading and writing from rw.
 func NewRecv(rw io.ReadWriter) (*Recv, error) {
-	dec, err := NewStreamDecoder(rw)
+	return &Recv{rw: rw}, nil
+}
+
+// Create a Recv that only accepts streams from senders that prove they
+// hold one of keys. Each message is tagged with AuthKeyTag and the id
+// of the sender's key.
+func NewAuthRecv(rw io.ReadWriter, keys keystore.Keys) (*Recv, error) {
+	return &Recv{rw: rw, keys: keys}, nil
+}
+
+// Use opts to read the stream's compression, such as a zstd dictionary.
+// Must be called before the first Generate.
+func (r *Recv) SetCompressionOptions(opts *CompressionOptions) {
+	r.opts = opts
+}
+
+// Only pick from comps when the sender offers compressions, in place
+// of SupportedCompressions. The sender's order of preference is used.
+// Must be called before the first Generate.
+func (r *Recv) SetAcceptedCompressions(comps []StreamHeader_Compression) {
+	r.accepted = comps
+}
+
+// Read the stream's header, authenticating the sender and picking a
+// compression if needed, and setup the decoder for the rest of the stream
+func (r *Recv) start() error {
+	probe := NewProbe(r.rw)
+	probe.Options = r.opts
+
+	err := probe.Probe()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if r.keys != nil || probe.Header.GetAuthKeyId() != "" {
+		err = r.authenticate(probe.Header)
+		if err != nil {
+			return err
+		}
 	}
 
-	return &Recv{rw, dec}, nil
+	if len(probe.Header.GetOfferCompression()) > 0 {
+		comp, err := r.negotiate(probe.Header)
+		if err != nil {
+			return err
+		}
+
+		probe.Header.Compression = comp.Enum()
+	}
+
+	r.dec = newProbedStreamDecoder(probe)
+
+	return nil
 }
 
 func (r *Recv) recvMessage() (*Message, error) {
-	return r.dec.Generate()
+	if r.dec == nil {
+		err := r.start()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := r.dec.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.keyID != "" {
+		m.AddTag(AuthKeyTag, r.keyID)
+	}
+
+	return m, nil
 }
 
 var reliableAckBytes = []byte{'k'}
@@ -31,6 +108,12 @@ func (r *Recv) sendAck() error {
 	return err
 }
 
+func (r *Recv) sendSequencedAck(seq uint64) error {
+	return writeAckFrame(r.rw, []seqRange{{seq, seq}})
+}
+
+var ErrUnknownReliableVersion = errors.New("unknown reliable protocol version")
+
 // Generate a new Message reading from the stream. If the stream
 // is in reliable mode (the default) then an ack is sent back.
 func (r *Recv) Generate() (*Message, error) {
@@ -39,13 +122,49 @@ func (r *Recv) Generate() (*Message, error) {
 		return nil, err
 	}
 
-	if r.dec.Header.GetMode() == StreamHeader_RELIABLE {
-		r.sendAck()
+	if r.header().GetMode() == StreamHeader_RELIABLE {
+		switch r.header().GetVersion() {
+		case ReliableLegacy:
+			r.sendAck()
+		case ReliableSequenced:
+			r.sendSequencedAck(r.dec.Sequence())
+		default:
+			return nil, ErrUnknownReliableVersion
+		}
 	}
 
 	return m, nil
 }
 
+func (r *Recv) header() *StreamHeader {
+	if r.dec == nil {
+		return nil
+	}
+
+	return r.dec.Header
+}
+
+// The id the sender gave the stream, if it's using the sequenced protocol
+func (r *Recv) SenderID() string {
+	return r.header().GetSenderId()
+}
+
+// The id of the key the sender authenticated with, if it did
+func (r *Recv) KeyID() string {
+	return r.keyID
+}
+
+// The sequence number of the last Message generated, if the stream is
+// using the sequenced protocol. Together with SenderID, it identifies
+// a message even if it's resent on another connection.
+func (r *Recv) Sequence() uint64 {
+	if r.dec == nil {
+		return 0
+	}
+
+	return r.dec.Sequence()
+}
+
 // To satisify the Generator interface
 func (r *Recv) Close() error {
 	return nil
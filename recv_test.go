@@ -2,7 +2,9 @@ package cypress
 
 import (
 	"testing"
+	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektra/neko"
@@ -112,5 +114,67 @@ func TestRecv(t *testing.T) {
 		assert.Equal(t, 0, db.write.Len())
 	})
 
+	n.It("acks sequenced messages by number", func() {
+		send, recv := newPair()
+		defer send.Close()
+		defer recv.Close()
+
+		s := NewSequencedSend(send, 0, nil)
+
+		err := s.SendHandshake()
+		require.NoError(t, err)
+
+		r, err := NewRecv(recv)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			m := Log()
+			m.Add("iter", i)
+
+			err = s.Receive(m)
+			require.NoError(t, err)
+
+			err = s.Flush()
+			require.NoError(t, err)
+
+			m2, err := r.Generate()
+			require.NoError(t, err)
+
+			assert.Equal(t, m, m2)
+			assert.Equal(t, uint64(i+1), r.Sequence())
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		s.ackLock.Lock()
+		defer s.ackLock.Unlock()
+
+		assert.Equal(t, 0, s.reqs.Len())
+	})
+
+	n.It("rejects an unknown reliable version", func() {
+		db := newDualBuffer()
+
+		s := NewStreamEncoder(db.Flip())
+
+		err := s.WriteCustomHeader(&StreamHeader{
+			Mode:    StreamHeader_RELIABLE.Enum(),
+			Version: proto.Uint32(99),
+		})
+		require.NoError(t, err)
+
+		err = s.Receive(Log())
+		require.NoError(t, err)
+
+		err = s.Flush()
+		require.NoError(t, err)
+
+		r, err := NewRecv(db)
+		require.NoError(t, err)
+
+		_, err = r.Generate()
+		assert.Equal(t, ErrUnknownReliableVersion, err)
+	})
+
 	n.Meow()
 }
@@ -1,6 +1,8 @@
 package cypress
 
 import (
+	"errors"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
@@ -12,13 +14,121 @@ type Connector interface {
 	Connect() (*Send, error)
 }
 
+// The state of a ReliableSend's connection
+type ReliableState int
+
+const (
+	// Connecting when starting or after the connection was lost
+	ReliableConnecting ReliableState = iota
+
+	// Connected and sending messages
+	ReliableConnected
+
+	// Closed, no longer sending
+	ReliableClosed
+)
+
+func (s ReliableState) String() string {
+	switch s {
+	case ReliableConnecting:
+		return "connecting"
+	case ReliableConnected:
+		return "connected"
+	case ReliableClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Indicates Receive was called with MaxOutstanding messages that aren't
+// acked yet and the ReliableSend doesn't block
+var ErrTooManyOutstanding = errors.New("too many outstanding messages")
+
+const (
+	// How long to wait after the first failed connect
+	DefaultMinBackoff = 1 * time.Second
+
+	// The longest to wait between connects
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Optional behavior of a ReliableSend
+type ReliableOptions struct {
+	// How long to wait after a failed connect, doubling after each
+	// failure up to MaxBackoff. DefaultMinBackoff and DefaultMaxBackoff
+	// are used if unset.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Vary each wait by up to this fraction of it so that senders
+	// don't all reconnect at once, 0.2 is +/- 20%
+	Jitter float64
+
+	// The most messages that can be received but not acked, no limit
+	// if 0. Receive waits for acks when there are this many.
+	MaxOutstanding int
+
+	// Return ErrTooManyOutstanding from Receive rather than waiting
+	// when there are MaxOutstanding messages
+	NoBlock bool
+
+	// Called with the new state whenever it changes
+	OnStateChange func(ReliableState)
+
+	// If set, messages are stored in it before being sent and the ones
+	// it has that weren't acked are resent on Start. Close closes it.
+	Queue SendQueue
+}
+
+// Persists the messages a ReliableSend hasn't had acked, so they can be
+// sent after a restart
+type SendQueue interface {
+	// Store m, called before it's sent
+	Append(m *Message) error
+
+	// m was acked and no longer needs to be stored
+	Ack(m *Message) error
+
+	// The messages stored and not acked when the queue was opened
+	Unacked() ([]*Message, error)
+
+	Close() error
+}
+
+// A snapshot of a ReliableSend's connection and messages
+type ReliableStats struct {
+	Connected bool
+
+	// How many times it's connected again after the first connect
+	Reconnects int
+
+	// Messages sent and not yet acked
+	Outstanding int
+
+	// Messages nacked and waiting to be resent
+	Nacked int
+
+	// Messages received and not yet acked, which MaxOutstanding
+	// limits
+	Pending int
+}
+
 type ReliableSend struct {
 	connector Connector
+	opts      ReliableOptions
+
+	// numbers messages across connections
+	session *SendSession
 
 	s *Send
 
 	lock        sync.Mutex
+	cond        *sync.Cond
 	outstanding int
+	pending     int
+	connected   bool
+	connects    int
 
 	newMessages chan *Message
 	closed      chan bool
@@ -32,28 +142,86 @@ type ReliableSend struct {
 }
 
 func NewReliableSend(c Connector, buffer int) *ReliableSend {
-	return &ReliableSend{
+	return NewReliableSendOptions(c, buffer, ReliableOptions{})
+}
+
+// Create a ReliableSend with the behavior in opts
+func NewReliableSendOptions(c Connector, buffer int, opts ReliableOptions) *ReliableSend {
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+
+	r := &ReliableSend{
 		connector:   c,
+		opts:        opts,
+		session:     NewSendSession(),
 		newMessages: make(chan *Message, buffer),
 		closed:      make(chan bool, 1),
 		flush:       make(chan struct{}),
 	}
+
+	r.cond = sync.NewCond(&r.lock)
+
+	return r
 }
 
+// Begin connecting and sending, resending the messages in the Queue
+// that weren't acked. Start doesn't wait for the connection, messages
+// received before it's made are sent once it is.
 func (r *ReliableSend) Start() error {
-	r.reconnect()
+	if r.opts.Queue != nil {
+		unacked, err := r.opts.Queue.Unacked()
+		if err != nil {
+			return err
+		}
+
+		// reconnect resends them
+		r.lock.Lock()
+		r.nacked = append(r.nacked, unacked...)
+		r.pending += len(unacked)
+		r.lock.Unlock()
+	}
 
-	r.t.Go(r.drain)
+	r.t.Go(r.run)
 
 	return nil
 }
 
 func (r *ReliableSend) Close() error {
+	r.lock.Lock()
 	r.shutdown = true
+	r.cond.Broadcast()
+	r.lock.Unlock()
 
 	r.t.Kill(nil)
 
-	return r.t.Wait()
+	err := r.t.Wait()
+
+	r.lock.Lock()
+	r.connected = false
+	r.nacked = nil
+	r.lock.Unlock()
+
+	r.session.clearNacked()
+
+	r.setState(ReliableClosed)
+
+	if r.opts.Queue != nil {
+		qerr := r.opts.Queue.Close()
+		if err == nil {
+			err = qerr
+		}
+	}
+
+	return err
 }
 
 func (r *ReliableSend) Flush() error {
@@ -61,15 +229,50 @@ func (r *ReliableSend) Flush() error {
 	return nil
 }
 
+// The session to number messages with. Connectors using the sequenced
+// protocol should pass it to NewSequencedSend so that resent messages
+// keep their number.
+func (r *ReliableSend) Session() *SendSession {
+	return r.session
+}
+
 func (r *ReliableSend) Outstanding() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	return r.outstanding
 }
 
-func (r *ReliableSend) Ack(m *Message) {
+// Return the current connection state and message counts
+func (r *ReliableSend) Stats() ReliableStats {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	stats := ReliableStats{
+		Connected:   r.connected,
+		Outstanding: r.outstanding,
+		Nacked:      len(r.nacked),
+		Pending:     r.pending,
+	}
+
+	if r.connects > 1 {
+		stats.Reconnects = r.connects - 1
+	}
+
+	return stats
+}
+
+func (r *ReliableSend) Ack(m *Message) {
+	r.lock.Lock()
 	r.outstanding--
+	r.pending--
+	r.cond.Signal()
+	r.lock.Unlock()
+
+	if r.opts.Queue != nil {
+		// A failure only means m is resent after a restart
+		r.opts.Queue.Ack(m)
+	}
 }
 
 func (r *ReliableSend) Nack(m *Message) {
@@ -84,41 +287,94 @@ func (r *ReliableSend) onClosed() {
 	r.closed <- true
 }
 
+func (r *ReliableSend) setState(state ReliableState) {
+	if r.opts.OnStateChange != nil {
+		r.opts.OnStateChange(state)
+	}
+}
+
+// How long to wait before the next connect, d varied by the jitter
+func (r *ReliableSend) backoff(d time.Duration) time.Duration {
+	if r.opts.Jitter <= 0 {
+		return d
+	}
+
+	delta := r.opts.Jitter * float64(d)
+
+	return d + time.Duration(delta*(2*rand.Float64()-1))
+}
+
 func (r *ReliableSend) reconnect() {
 	r.lock.Lock()
 
-	var err error
-
 	if r.s != nil {
 		r.s.Close()
 	}
 
+	r.connected = false
+
+	r.lock.Unlock()
+
+	r.setState(ReliableConnecting)
+
+	var (
+		s   *Send
+		err error
+
+		wait = r.opts.MinBackoff
+	)
+
+	// The lock isn't held while connecting so that acks and nacks from
+	// the old connection and Stats aren't held up.
 	for {
-		s, err := r.connector.Connect()
-		if err != nil {
-			if r.shutdown {
-				r.lock.Unlock()
-				return
-			}
+		s, err = r.connector.Connect()
+		if err == nil {
+			break
+		}
 
-			time.Sleep(1 * time.Second)
-			continue
+		select {
+		case <-time.After(r.backoff(wait)):
+		case <-r.t.Dying():
+			return
 		}
 
-		s.OnClosed = r.onClosed
-		r.s = s
+		wait *= 2
+
+		if wait > r.opts.MaxBackoff {
+			wait = r.opts.MaxBackoff
+		}
+	}
+
+	// The Send may already be reading acks, so set OnClosed under it's
+	// lock and catch a connection that closed before it was set.
+	s.ackLock.Lock()
+	s.OnClosed = r.onClosed
+	closed := s.closed
+	s.ackLock.Unlock()
 
-		break
+	if closed {
+		r.onClosed()
 	}
 
+	r.lock.Lock()
+
+	r.s = s
+	r.connected = true
+	r.connects++
+
 	nacked := r.nacked
 	r.nacked = nil
 
 	r.lock.Unlock()
 
+	r.setState(ReliableConnected)
+
 	for idx, msg := range nacked {
+		r.lock.Lock()
 		r.outstanding++
-		err = r.s.Send(msg, r)
+		r.lock.Unlock()
+
+		err = s.Send(msg, r)
 		if err != nil {
 			r.lock.Lock()
 			r.nacked = append(nacked[idx+1:], r.nacked...)
@@ -133,36 +389,90 @@ func (r *ReliableSend) reconnect() {
 	}
 }
 
+// Queue m to be sent, storing it in the Queue if there is one. If
+// MaxOutstanding is set and that many messages aren't acked yet, wait
+// for an ack or return ErrTooManyOutstanding.
 func (r *ReliableSend) Receive(m *Message) error {
+	r.lock.Lock()
+
+	for r.opts.MaxOutstanding > 0 && r.pending >= r.opts.MaxOutstanding {
+		if r.shutdown {
+			r.lock.Unlock()
+			return ErrClosed
+		}
+
+		if r.opts.NoBlock {
+			r.lock.Unlock()
+			return ErrTooManyOutstanding
+		}
+
+		r.cond.Wait()
+	}
+
+	if r.opts.Queue != nil {
+		err := r.opts.Queue.Append(m)
+		if err != nil {
+			r.lock.Unlock()
+			return err
+		}
+	}
+
+	r.pending++
+
+	r.lock.Unlock()
+
 	r.newMessages <- m
 	return nil
 }
 
+// Connect, then send messages until closed
+func (r *ReliableSend) run() error {
+	r.reconnect()
+
+	// closed before it ever connected
+	if r.s == nil {
+		return nil
+	}
+
+	return r.drain()
+}
+
 func (r *ReliableSend) drain() error {
 	for {
 		select {
 		case <-r.closed:
 			r.reconnect()
 		case <-r.flush:
+			// messages received before the flush may be waiting
+			// if it came in while connecting
+			r.sendBuffered()
 			r.s.Flush()
 		case m := <-r.newMessages:
-			r.lock.Lock()
-			r.outstanding++
-			r.lock.Unlock()
-			r.s.Send(m, r)
+			r.send(m)
 		case <-r.t.Dying():
-			for {
-				select {
-				case m := <-r.newMessages:
-					r.lock.Lock()
-					r.outstanding++
-					r.lock.Unlock()
-					r.s.Send(m, r)
-				default:
-					r.s.Close()
-					return nil
-				}
-			}
+			r.sendBuffered()
+			r.s.Close()
+			return nil
+		}
+	}
+}
+
+func (r *ReliableSend) send(m *Message) {
+	r.lock.Lock()
+	r.outstanding++
+	r.lock.Unlock()
+
+	r.s.Send(m, r)
+}
+
+// Send the messages received and not yet sent
+func (r *ReliableSend) sendBuffered() {
+	for {
+		select {
+		case m := <-r.newMessages:
+			r.send(m)
+		default:
+			return
 		}
 	}
 }
@@ -0,0 +1,406 @@
+package cypress
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+var errTestConnect = errors.New("connect failed")
+
+// Fails the first failures connects, then connects to a remote side
+// that acks (or just reads, if silent) everything
+type testConnector struct {
+	failures int
+	silent   bool
+
+	lock  sync.Mutex
+	calls []time.Time
+	conns []net.Conn
+}
+
+func (c *testConnector) Connect() (*Send, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.calls = append(c.calls, time.Now())
+
+	if len(c.calls) <= c.failures {
+		return nil, errTestConnect
+	}
+
+	local, remote := net.Pipe()
+
+	c.conns = append(c.conns, local, remote)
+
+	if c.silent {
+		go io.Copy(ioutil.Discard, remote)
+	} else {
+		go func() {
+			r, err := NewRecv(remote)
+			if err != nil {
+				return
+			}
+
+			for {
+				_, err := r.Generate()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	s := NewSequencedSend(local, 0, nil)
+
+	err := s.SendHandshake()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (c *testConnector) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// A SendQueue in memory
+type memQueue struct {
+	lock    sync.Mutex
+	msgs    []*Message
+	unacked []*Message
+	closed  bool
+}
+
+func (q *memQueue) Append(m *Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.msgs = append(q.msgs, m)
+	return nil
+}
+
+func (q *memQueue) Ack(m *Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, qm := range q.msgs {
+		if qm == m {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (q *memQueue) Unacked() ([]*Message, error) {
+	return q.unacked, nil
+}
+
+func (q *memQueue) Close() error {
+	q.closed = true
+	return nil
+}
+
+func (q *memQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.msgs)
+}
+
+func TestReliableSend(t *testing.T) {
+	n := neko.Start(t)
+
+	// Start connects in the background
+	waitConnected := func(r *ReliableSend) {
+		deadline := time.Now().Add(5 * time.Second)
+
+		for !r.Stats().Connected {
+			require.True(t, time.Now().Before(deadline), "never connected")
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	n.It("backs off between failed connects", func() {
+		c := &testConnector{failures: 4}
+		defer c.Close()
+
+		r := NewReliableSendOptions(c, 0, ReliableOptions{
+			MinBackoff: 20 * time.Millisecond,
+			MaxBackoff: 60 * time.Millisecond,
+		})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		waitConnected(r)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		require.Equal(t, 5, len(c.calls))
+
+		expected := []time.Duration{20, 40, 60, 60}
+
+		for i, exp := range expected {
+			wait := c.calls[i+1].Sub(c.calls[i])
+			assert.True(t, wait >= exp*time.Millisecond, "wait %d was %s", i, wait)
+		}
+	})
+
+	n.It("returns from Start while the remote side is down", func() {
+		c := &testConnector{failures: 1000}
+		defer c.Close()
+
+		r := NewReliableSendOptions(c, 10, ReliableOptions{MinBackoff: time.Second})
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- r.Start()
+		}()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("Start waited for the connection")
+		}
+
+		err := r.Receive(Log())
+		require.NoError(t, err)
+
+		assert.False(t, r.Stats().Connected)
+		assert.Equal(t, 1, r.Stats().Pending)
+
+		err = r.Close()
+		require.NoError(t, err)
+	})
+
+	n.It("varies the backoff by the jitter", func() {
+		r := NewReliableSendOptions(&testConnector{}, 0, ReliableOptions{Jitter: 0.5})
+
+		for i := 0; i < 100; i++ {
+			wait := r.backoff(time.Second)
+			assert.True(t, wait >= 500*time.Millisecond && wait <= 1500*time.Millisecond)
+		}
+
+		r = NewReliableSendOptions(&testConnector{}, 0, ReliableOptions{})
+
+		assert.Equal(t, time.Second, r.backoff(time.Second))
+	})
+
+	n.It("reports changes to it's state", func() {
+		c := &testConnector{}
+		defer c.Close()
+
+		var (
+			lock   sync.Mutex
+			states []ReliableState
+		)
+
+		r := NewReliableSendOptions(c, 0, ReliableOptions{
+			OnStateChange: func(s ReliableState) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				states = append(states, s)
+			},
+		})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		waitConnected(r)
+
+		err = r.Close()
+		require.NoError(t, err)
+
+		assert.False(t, r.Stats().Connected)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		assert.Equal(t, []ReliableState{ReliableConnecting, ReliableConnected, ReliableClosed}, states)
+	})
+
+	n.It("counts reconnects", func() {
+		c := &testConnector{}
+		defer c.Close()
+
+		r := NewReliableSendOptions(c, 0, ReliableOptions{MinBackoff: time.Millisecond})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		waitConnected(r)
+
+		assert.Equal(t, 0, r.Stats().Reconnects)
+
+		c.lock.Lock()
+		c.conns[0].Close()
+		c.lock.Unlock()
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for r.Stats().Reconnects == 0 {
+			require.True(t, time.Now().Before(deadline), "never reconnected")
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.True(t, r.Stats().Connected)
+	})
+
+	n.It("tracks messages that aren't acked", func() {
+		c := &testConnector{silent: true}
+
+		r := NewReliableSendOptions(c, 0, ReliableOptions{})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		defer r.Close()
+		defer c.Close()
+
+		err = r.Receive(Log())
+		require.NoError(t, err)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for r.Stats().Outstanding == 0 {
+			require.True(t, time.Now().Before(deadline), "never sent")
+			time.Sleep(time.Millisecond)
+		}
+
+		stats := r.Stats()
+
+		assert.Equal(t, 1, stats.Outstanding)
+		assert.Equal(t, 1, stats.Pending)
+		assert.Equal(t, 0, stats.Nacked)
+	})
+
+	n.It("returns an error when too many messages aren't acked", func() {
+		c := &testConnector{silent: true}
+
+		r := NewReliableSendOptions(c, 10, ReliableOptions{
+			MaxOutstanding: 2,
+			NoBlock:        true,
+		})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		defer r.Close()
+		defer c.Close()
+
+		for i := 0; i < 2; i++ {
+			err = r.Receive(Log())
+			require.NoError(t, err)
+		}
+
+		err = r.Receive(Log())
+		assert.Equal(t, ErrTooManyOutstanding, err)
+	})
+
+	n.It("waits for acks when too many messages aren't acked", func() {
+		c := &testConnector{silent: true}
+
+		r := NewReliableSendOptions(c, 10, ReliableOptions{MaxOutstanding: 1})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		err = r.Receive(Log())
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- r.Receive(Log())
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("Receive didn't wait")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		r.Ack(nil)
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Receive didn't return after an ack")
+		}
+
+		go func() {
+			done <- r.Receive(Log())
+		}()
+
+		c.Close()
+		r.Close()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, ErrClosed, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Receive didn't return after closing")
+		}
+	})
+
+	n.It("stores messages in the queue until they're acked", func() {
+		c := &testConnector{}
+		defer c.Close()
+
+		old := Log()
+		old.Add("from", "before")
+
+		q := &memQueue{unacked: []*Message{old}, msgs: []*Message{old}}
+
+		r := NewReliableSendOptions(c, 0, ReliableOptions{Queue: q})
+
+		err := r.Start()
+		require.NoError(t, err)
+
+		err = r.Receive(Log())
+		require.NoError(t, err)
+
+		err = r.Flush()
+		require.NoError(t, err)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for q.Len() > 0 {
+			require.True(t, time.Now().Before(deadline), "messages were never acked")
+			time.Sleep(time.Millisecond)
+		}
+
+		err = r.Close()
+		require.NoError(t, err)
+
+		assert.True(t, q.closed)
+	})
+
+	n.Meow()
+}
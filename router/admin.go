@@ -0,0 +1,367 @@
+package router
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/cypress/httputil"
+	"github.com/vektra/errors"
+)
+
+// Settings for the router's admin HTTP API, from the admin table
+type AdminConfig struct {
+	// The address to listen on, the API is disabled if empty
+	Listen string
+}
+
+var ErrUnknownRoute = errors.New("unknown route")
+
+// The state of one output's queue
+type QueueStatus struct {
+	Output    string `json:"output"`
+	Depth     int    `json:"depth"`
+	Capacity  int    `json:"capacity"`
+	Overflow  string `json:"overflow"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	Spilled   uint64 `json:"spilled"`
+	Errors    uint64 `json:"errors"`
+}
+
+// The configuration and state of a Route
+type RouteStatus struct {
+	Name    string        `json:"name"`
+	Enabled bool          `json:"enabled"`
+	Running bool          `json:"running"`
+	Match   string        `json:"match,omitempty"`
+	Workers int           `json:"workers"`
+	Ordered bool          `json:"ordered"`
+	Input   []string      `json:"input"`
+	Filter  []string      `json:"filter"`
+	Output  []string      `json:"output"`
+	Queues  []QueueStatus `json:"queues"`
+
+	DeadLetter *QueueStatus `json:"dead_letter,omitempty"`
+}
+
+// A configured plugin and the counters for the messages it's handled
+type PluginStatus struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Kinds    []string `json:"kinds"`
+	Messages uint64   `json:"messages"`
+	Bytes    uint64   `json:"bytes"`
+	Errors   uint64   `json:"errors"`
+}
+
+func (r *Route) status() RouteStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	rs := RouteStatus{
+		Name:    r.Name,
+		Enabled: r.Enabled,
+		Running: r.running(),
+		Match:   r.Match,
+		Workers: r.workers(),
+		Ordered: r.Ordered,
+		Input:   r.Input,
+		Filter:  r.Filter,
+		Output:  r.Output,
+	}
+
+	for _, q := range r.queues {
+		rs.Queues = append(rs.Queues, q.status())
+	}
+
+	if r.deadLetter != nil {
+		dl := r.deadLetter.status()
+		rs.DeadLetter = &dl
+	}
+
+	return rs
+}
+
+func (q *OutputQueue) status() QueueStatus {
+	return QueueStatus{
+		Output:    q.Name,
+		Depth:     q.Len(),
+		Capacity:  q.Cap(),
+		Overflow:  q.Overflow,
+		Delivered: q.Delivered(),
+		Dropped:   q.Dropped(),
+		Spilled:   q.Spilled(),
+		Errors:    q.Errors(),
+	}
+}
+
+// The status of every route, sorted by name
+func (r *Router) RouteStatus() []RouteStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var out []RouteStatus
+
+	for _, route := range r.routes {
+		out = append(out, route.status())
+	}
+
+	sort.Sort(routeStatusByName(out))
+
+	return out
+}
+
+type routeStatusByName []RouteStatus
+
+func (s routeStatusByName) Len() int           { return len(s) }
+func (s routeStatusByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s routeStatusByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Which roles a plugin can play in a route: input, output and filter
+func PluginKinds(pl cypress.Plugin) []string {
+	kinds := []string{}
+
+	if _, ok := pl.(cypress.GeneratorPlugin); ok {
+		kinds = append(kinds, "input")
+	}
+
+	if _, ok := pl.(cypress.ReceiverPlugin); ok {
+		kinds = append(kinds, "output")
+	}
+
+	if _, ok := pl.(cypress.FiltererPlugin); ok {
+		kinds = append(kinds, "filter")
+	}
+
+	return kinds
+}
+
+// The status of every configured plugin, sorted by name
+func (r *Router) PluginStatus() []PluginStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var names []string
+
+	for name := range r.plugins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var out []PluginStatus
+
+	for _, name := range names {
+		def := r.plugins[name]
+
+		out = append(out, PluginStatus{
+			Name:     name,
+			Type:     def.Type,
+			Kinds:    PluginKinds(def.Plugin),
+			Messages: def.stats.Messages(),
+			Bytes:    def.stats.Bytes(),
+			Errors:   def.stats.Errors(),
+		})
+	}
+
+	return out
+}
+
+// A copy of the route's configuration, ready to be wired up again
+func (r *Route) unwired(enabled bool) *Route {
+	return &Route{
+		Name:         r.Name,
+		Enabled:      enabled,
+		Input:        r.Input,
+		Output:       r.Output,
+		Filter:       r.Filter,
+		Match:        r.Match,
+		DeadLetter:   r.DeadLetter,
+		Workers:      r.Workers,
+		Ordered:      r.Ordered,
+		QueueSize:    r.QueueSize,
+		Overflow:     r.Overflow,
+		Spill:        r.Spill,
+		matcher:      r.matcher,
+		when:         r.when,
+		queueConfigs: r.queueConfigs,
+	}
+}
+
+// Start a route that was disabled in the config or by DisableRoute
+func (r *Router) EnableRoute(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	route, ok := r.routes[name]
+	if !ok {
+		return errors.Subject(ErrUnknownRoute, name)
+	}
+
+	if route.Enabled && route.running() {
+		return nil
+	}
+
+	if route.done != nil {
+		logReport(route.stop(0))
+	}
+
+	nr := route.unwired(true)
+
+	err := r.wireRoute(nr)
+	if err != nil {
+		logReport(nr.stop(0))
+		return err
+	}
+
+	nr.start()
+
+	r.routes[name] = nr
+
+	return nil
+}
+
+// Stop a route, delivering the messages already in it. The route
+// remains configured and can be started again with EnableRoute.
+func (r *Router) DisableRoute(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	route, ok := r.routes[name]
+	if !ok {
+		return errors.Subject(ErrUnknownRoute, name)
+	}
+
+	if !route.Enabled {
+		return nil
+	}
+
+	logReport(route.stop(DefaultShutdownTimeout))
+
+	r.routes[name] = route.unwired(false)
+
+	return nil
+}
+
+// An http.Handler serving the admin API:
+//
+//	GET  /routes                the status of every route
+//	GET  /routes/<name>         the status of one route
+//	POST /routes/<name>/enable  start the route
+//	POST /routes/<name>/disable stop the route
+//	GET  /plugins               every plugin and it's counters
+func (r *Router) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeValue(w, req, r.RouteStatus())
+	})
+
+	mux.HandleFunc("/routes/", r.serveRoute)
+
+	mux.HandleFunc("/plugins", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeValue(w, req, r.PluginStatus())
+	})
+
+	return mux
+}
+
+func (r *Router) serveRoute(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/routes/"), "/")
+
+	name := parts[0]
+
+	if _, ok := r.routeStatus(name); !ok || len(parts) > 2 {
+		http.NotFound(w, req)
+		return
+	}
+
+	var err error
+
+	switch {
+	case len(parts) == 1 && req.Method == "GET":
+	case len(parts) == 2 && parts[1] == "enable" && req.Method == "POST":
+		err = r.EnableRoute(name)
+	case len(parts) == 2 && parts[1] == "disable" && req.Method == "POST":
+		err = r.DisableRoute(name)
+	case len(parts) == 1 || parts[1] == "enable" || parts[1] == "disable":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rs, _ := r.routeStatus(name)
+
+	writeValue(w, req, rs)
+}
+
+func (r *Router) routeStatus(name string) (RouteStatus, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	route, ok := r.routes[name]
+	if !ok {
+		return RouteStatus{}, false
+	}
+
+	return route.status(), true
+}
+
+func writeValue(w http.ResponseWriter, req *http.Request, v interface{}) {
+	err := httputil.WriteValue(req, w, v)
+	if err == httputil.ErrNotAcceptable {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+	}
+}
+
+// Serve the admin API on the configured address
+func (r *Router) startAdmin() error {
+	l, err := net.Listen("tcp", r.admin.Listen)
+	if err != nil {
+		return errors.Subject(err, "admin")
+	}
+
+	r.adminListener = l
+
+	go func() {
+		err := http.Serve(l, r.AdminHandler())
+		if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error serving admin API: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// The address the admin API is listening on, or nil if it's not
+func (r *Router) AdminAddr() net.Addr {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.adminListener == nil {
+		return nil
+	}
+
+	return r.adminListener.Addr()
+}
@@ -0,0 +1,197 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+// Creates a new generator each time, like most real plugins do
+type freshPlugin struct{}
+
+func (f *freshPlugin) Generator() (cypress.Generator, error) {
+	t := &cypress.TestPlugin{}
+	t.Init()
+
+	return t, nil
+}
+
+func (f *freshPlugin) Receiver() (cypress.Receiver, error) {
+	t := &cypress.TestPlugin{}
+	t.Init()
+
+	return t, nil
+}
+
+func init() {
+	cypress.AddPlugin("Fresh", func() cypress.Plugin {
+		return &freshPlugin{}
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	n := neko.Start(t)
+
+	var r *Router
+
+	n.Setup(func() {
+		r = NewRouter()
+
+		err := r.LoadConfig(strings.NewReader(`
+[input.Fresh]
+
+[output.Fresh]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+	})
+
+	n.Cleanup(func() {
+		r.Shutdown(100 * time.Millisecond)
+	})
+
+	request := func(method, path string, v interface{}) int {
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+
+		r.AdminHandler().ServeHTTP(w, req)
+
+		if w.Code == 200 && v != nil {
+			err = json.NewDecoder(w.Body).Decode(v)
+			require.NoError(t, err)
+		}
+
+		return w.Code
+	}
+
+	flow := func() {
+		rt := r.routes["Default"]
+
+		rt.generators[0].(*cypress.TestPlugin).Messages <- cypress.Log()
+
+		select {
+		case <-rt.receivers[0].(*cypress.TestPlugin).Messages:
+		case <-time.After(1 * time.Second):
+			t.Fatal("message did not flow through the router")
+		}
+	}
+
+	n.It("lists the routes", func() {
+		var routes []RouteStatus
+
+		code := request("GET", "/routes", &routes)
+		require.Equal(t, 200, code)
+
+		require.Equal(t, 1, len(routes))
+
+		rs := routes[0]
+
+		assert.Equal(t, "Default", rs.Name)
+		assert.True(t, rs.Enabled)
+		assert.True(t, rs.Running)
+		assert.Equal(t, []string{"input"}, rs.Input)
+		assert.Equal(t, []string{"output"}, rs.Output)
+
+		require.Equal(t, 1, len(rs.Queues))
+		assert.Equal(t, "output", rs.Queues[0].Output)
+		assert.Equal(t, DefaultQueueSize, rs.Queues[0].Capacity)
+	})
+
+	n.It("shows the counters for each plugin", func() {
+		flow()
+
+		var plugins []PluginStatus
+
+		code := request("GET", "/plugins", &plugins)
+		require.Equal(t, 200, code)
+
+		require.Equal(t, 2, len(plugins))
+
+		assert.Equal(t, "input", plugins[0].Name)
+		assert.Equal(t, "Fresh", plugins[0].Type)
+		assert.Equal(t, []string{"input", "output"}, plugins[0].Kinds)
+		assert.Equal(t, uint64(1), plugins[0].Messages)
+		assert.True(t, plugins[0].Bytes > 0)
+
+		assert.Equal(t, "output", plugins[1].Name)
+		assert.Equal(t, uint64(1), plugins[1].Messages)
+	})
+
+	n.It("can disable and enable a route", func() {
+		var rs RouteStatus
+
+		code := request("POST", "/routes/Default/disable", &rs)
+		require.Equal(t, 200, code)
+
+		assert.False(t, rs.Enabled)
+		assert.False(t, rs.Running)
+
+		code = request("POST", "/routes/Default/enable", &rs)
+		require.Equal(t, 200, code)
+
+		assert.True(t, rs.Enabled)
+		assert.True(t, rs.Running)
+
+		flow()
+	})
+
+	n.It("returns 404 for unknown routes", func() {
+		assert.Equal(t, 404, request("GET", "/routes/Nope", nil))
+		assert.Equal(t, 404, request("POST", "/routes/Nope/enable", nil))
+		assert.Equal(t, 405, request("GET", "/routes/Default/enable", nil))
+	})
+
+	n.It("listens on the configured address", func() {
+		r2 := NewRouter()
+
+		err := r2.LoadConfig(strings.NewReader(`
+[admin]
+listen = "127.0.0.1:0"
+
+[in.Test]
+
+[out.Test]
+`))
+		require.NoError(t, err)
+
+		err = r2.Open()
+		require.NoError(t, err)
+
+		addr := r2.AdminAddr()
+		require.NotNil(t, addr)
+
+		client := &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true},
+		}
+
+		resp, err := client.Get("http://" + addr.String() + "/routes")
+		require.NoError(t, err)
+
+		resp.Body.Close()
+
+		assert.Equal(t, 200, resp.StatusCode)
+
+		r2.Shutdown(100 * time.Millisecond)
+
+		_, err = client.Get("http://" + addr.String() + "/routes")
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,314 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/naoina/toml"
+	"github.com/naoina/toml/ast"
+	"github.com/vektra/cypress"
+	"github.com/vektra/errors"
+)
+
+// A problem found in a router config
+type Problem struct {
+	// The line of the config the problem is on, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+type problemsByLine []Problem
+
+func (s problemsByLine) Len() int           { return len(s) }
+func (s problemsByLine) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s problemsByLine) Less(i, j int) bool { return s[i].Line < s[j].Line }
+
+type checker struct {
+	r        *Router
+	problems []Problem
+
+	// the line each route and plugin is defined on
+	lines map[string]int
+}
+
+func (c *checker) add(line int, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{line, fmt.Sprintf(format, args...)})
+}
+
+// The line a value in a table starts on
+func lineOf(val interface{}) int {
+	switch v := val.(type) {
+	case *ast.Table:
+		return v.Line
+	case *ast.KeyValue:
+		return v.Line
+	case []*ast.Table:
+		if len(v) > 0 {
+			return v[0].Line
+		}
+	}
+
+	return 0
+}
+
+// Check each key of tbl can be set on the value returned by newValue,
+// reporting every key that can't rather than just the first.
+func (c *checker) checkKeys(subject string, tbl *ast.Table, newValue func() interface{}, skip ...string) []string {
+	var bad []string
+
+	for key, val := range tbl.Fields {
+		if contains(skip, key) {
+			continue
+		}
+
+		single := &ast.Table{
+			Position: tbl.Position,
+			Line:     tbl.Line,
+			Name:     tbl.Name,
+			Fields:   map[string]interface{}{key: val},
+			Type:     tbl.Type,
+		}
+
+		err := toml.UnmarshalTable(single, newValue())
+		if err != nil {
+			c.add(lineOf(val), "%s: %s", subject, err)
+			bad = append(bad, key)
+		}
+	}
+
+	return bad
+}
+
+// Read a config and report every problem with it, without starting
+// any plugins. Plugins are configured, so bad settings are found, and
+// each route is checked to only use plugins that can fill the role
+// they're used in.
+func CheckConfig(i io.Reader) []Problem {
+	data, err := ioutil.ReadAll(i)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+
+	top, err := toml.Parse(data)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+
+	c := &checker{
+		r:     NewRouter(),
+		lines: make(map[string]int),
+	}
+
+	for key, val := range top.Fields {
+		line := lineOf(val)
+
+		if key == "route" {
+			if tbl, ok := val.(*ast.Table); ok {
+				c.loadRoutes(tbl)
+				continue
+			}
+		}
+
+		err := c.r.loadEntry(key, val)
+		if err != nil {
+			c.add(line, "%s", err)
+			continue
+		}
+
+		if key == "admin" {
+			continue
+		}
+
+		c.lines[key] = line
+		c.checkPlugin(c.r.plugins[key])
+	}
+
+	c.r.addDefaultRoute()
+
+	for _, route := range c.r.routes {
+		c.checkRoute(route)
+	}
+
+	sort.Stable(problemsByLine(c.problems))
+
+	return c.problems
+}
+
+func (c *checker) loadRoutes(top *ast.Table) {
+	for name, tbl := range routeTables(top) {
+		subject := "route " + name
+
+		c.lines[subject] = tbl.Line
+
+		bad := c.checkKeys(subject, tbl, func() interface{} { return &Route{} }, "queue", "when")
+
+		if qv, ok := tbl.Fields["queue"].(*ast.Table); ok {
+			for output, val := range qv.Fields {
+				if qt, ok := val.(*ast.Table); ok {
+					c.checkKeys(subject+" queue "+output, qt, func() interface{} { return &QueueConfig{} })
+				}
+			}
+		}
+
+		// Leave out the keys already reported so the rest of the
+		// route can still be checked.
+		if len(bad) > 0 {
+			fields := make(map[string]interface{}, len(tbl.Fields))
+
+			for k, v := range tbl.Fields {
+				if !contains(bad, k) {
+					fields[k] = v
+				}
+			}
+
+			stripped := *tbl
+			stripped.Fields = fields
+			tbl = &stripped
+		}
+
+		err := c.r.loadRoute(name, tbl)
+		if err != nil {
+			c.add(tbl.Line, "%s", err)
+		}
+	}
+}
+
+func (c *checker) checkPlugin(def *PluginDefinition) {
+	line := c.lines[def.Name]
+
+	plug, ok := cypress.FindPlugin(def.Type)
+	if !ok {
+		c.add(line, "%s: unknown plugin type %s", def.Name, def.Type)
+		return
+	}
+
+	def.Plugin = plug
+
+	c.checkKeys(def.Name, def.Config, func() interface{} {
+		p, _ := cypress.FindPlugin(def.Type)
+		return p
+	})
+}
+
+// Check that name is a plugin that can be used as kind (an input,
+// filter or output). use describes how the route uses it.
+func (c *checker) checkUse(line int, route, use, kind, name string) {
+	def, ok := c.r.plugins[name]
+	if !ok {
+		c.add(line, "route %s: %s %s is not defined", route, use, name)
+		return
+	}
+
+	// the plugin's type was unknown, which is already reported
+	if def.Plugin == nil {
+		return
+	}
+
+	var can bool
+
+	switch kind {
+	case "input":
+		_, can = def.Plugin.(cypress.GeneratorPlugin)
+	case "filter":
+		_, can = def.Plugin.(cypress.FiltererPlugin)
+	case "output":
+		_, can = def.Plugin.(cypress.ReceiverPlugin)
+	}
+
+	if !can {
+		article := "an"
+		if kind == "filter" {
+			article = "a"
+		}
+
+		c.add(line, "route %s: %s %s (%s) is not %s %s plugin", route, use, name, def.Type, article, kind)
+	}
+}
+
+func (c *checker) checkRoute(route *Route) {
+	line := c.lines["route "+route.Name]
+
+	if len(route.Input) == 0 {
+		c.add(line, "route %s: has no inputs", route.Name)
+	}
+
+	if len(route.Output) == 0 {
+		c.add(line, "route %s: has no outputs", route.Name)
+	}
+
+	c.checkWiring(route)
+}
+
+// Check the plugins and queue settings the route uses, the things
+// that would stop it being wired.
+func (c *checker) checkWiring(route *Route) {
+	line := c.lines["route "+route.Name]
+
+	for _, name := range route.Input {
+		c.checkUse(line, route.Name, "input", "input", name)
+	}
+
+	for _, name := range route.Filter {
+		c.checkUse(line, route.Name, "filter", "filter", name)
+	}
+
+	for _, name := range route.Output {
+		c.checkUse(line, route.Name, "output", "output", name)
+
+		cfg := route.queueConfig(name)
+
+		if cfg.Overflow != "" && !validOverflow(cfg.Overflow) {
+			c.add(line, "route %s: %s: %s", route.Name, ErrUnknownOverflow, cfg.Overflow)
+		}
+
+		if cfg.Overflow == OverflowSpill {
+			if cfg.Spill == "" {
+				c.add(line, "route %s: %s: %s", route.Name, ErrNoSpill, name)
+			} else if contains(route.Output, cfg.Spill) {
+				c.add(line, "route %s: %s: %s", route.Name, ErrSpillIsOutput, cfg.Spill)
+			} else {
+				c.checkUse(line, route.Name, "spill output", "output", cfg.Spill)
+			}
+		}
+	}
+
+	for name := range route.queueConfigs {
+		if !contains(route.Output, name) {
+			c.add(line, "route %s: queue settings for %s, which isn't an output", route.Name, name)
+		}
+	}
+
+	for name := range route.when {
+		if !contains(route.Output, name) {
+			c.add(line, "route %s: when for %s, which isn't an output", route.Name, name)
+		}
+	}
+
+	if route.DeadLetter != "" {
+		c.checkUse(line, route.Name, "dead letter output", "output", route.DeadLetter)
+	}
+}
+
+// Check that route can be wired with the plugins of r, which must
+// already be instantiated.
+func (r *Router) checkWiring(route *Route) error {
+	c := &checker{r: r}
+
+	c.checkWiring(route)
+
+	if len(c.problems) > 0 {
+		return errors.Subject(ErrInvalidConfig, c.problems[0].String())
+	}
+
+	return nil
+}
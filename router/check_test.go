@@ -0,0 +1,158 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestCheckConfig(t *testing.T) {
+	n := neko.Start(t)
+
+	check := func(cfg string) []Problem {
+		return CheckConfig(strings.NewReader(cfg))
+	}
+
+	n.It("finds no problems in a good config", func() {
+		problems := check(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`)
+
+		assert.Empty(t, problems)
+	})
+
+	n.It("reports unknown keys with their line", func() {
+		problems := check(`
+[input.Test]
+bogus = 1
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+outptu = ["output"]
+`)
+
+		require.Equal(t, 2, len(problems))
+
+		assert.Equal(t, 3, problems[0].Line)
+		assert.Contains(t, problems[0].Message, "input")
+
+		assert.Equal(t, 10, problems[1].Line)
+		assert.Contains(t, problems[1].Message, "route Default")
+	})
+
+	n.It("reports plugins used in the wrong role", func() {
+		problems := check(`
+[input.Test]
+
+[output.Test]
+
+[fresh.Fresh]
+
+[route.Default]
+input = ["input"]
+filter = ["fresh"]
+output = ["output"]
+`)
+
+		require.Equal(t, 1, len(problems))
+
+		assert.Equal(t, 8, problems[0].Line)
+		assert.Equal(t, "route Default: filter fresh (Fresh) is not a filter plugin", problems[0].Message)
+	})
+
+	n.It("reports undefined and unknown plugins", func() {
+		problems := check(`
+[input.Test]
+
+[output.NotAPlugin]
+
+[route.Default]
+input = ["input", "missing"]
+output = ["output"]
+`)
+
+		require.Equal(t, 2, len(problems))
+
+		assert.Equal(t, 4, problems[0].Line)
+		assert.Contains(t, problems[0].Message, "unknown plugin type NotAPlugin")
+
+		assert.Equal(t, 6, problems[1].Line)
+		assert.Contains(t, problems[1].Message, "input missing is not defined")
+	})
+
+	n.It("reports bad queue settings", func() {
+		problems := check(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+
+[route.Default.queue.output]
+overflow = "spill"
+
+[route.Default.queue.other]
+size = 10
+`)
+
+		require.Equal(t, 2, len(problems))
+
+		for _, p := range problems {
+			assert.Equal(t, 6, p.Line)
+		}
+
+		msgs := problems[0].Message + problems[1].Message
+
+		assert.Contains(t, msgs, ErrNoSpill.Error())
+		assert.Contains(t, msgs, "queue settings for other")
+	})
+
+	n.It("reports spilling to one of the route's outputs", func() {
+		problems := check(`
+[input.Test]
+
+[output.Test]
+
+[archive.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output", "archive"]
+
+[route.Default.queue.output]
+overflow = "spill"
+spill = "archive"
+`)
+
+		require.Equal(t, 1, len(problems))
+		assert.Contains(t, problems[0].Message, ErrSpillIsOutput.Error())
+	})
+
+	n.It("reports routes without inputs or outputs", func() {
+		problems := check(`
+[output.Test]
+
+[route.Default]
+output = ["output"]
+`)
+
+		require.Equal(t, 1, len(problems))
+		assert.Equal(t, "line 4: route Default: has no inputs", problems[0].String())
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"log"
+
+	"github.com/vektra/cypress"
+)
+
+// The attributes added to a message sent to a route's dead letter output
+const (
+	// The plugin that failed to filter or deliver the message
+	DeadLetterPlugin = "dead_letter.plugin"
+
+	// The error the plugin returned
+	DeadLetterError = "dead_letter.error"
+
+	// How many times the message has failed, counting failures from
+	// before it was replayed.
+	DeadLetterAttempts = "dead_letter.attempts"
+)
+
+// A private copy of m, so that it can be annotated without affecting
+// the outputs still delivering it.
+func copyMessage(m *cypress.Message) *cypress.Message {
+	data, err := m.Marshal()
+	if err != nil {
+		return nil
+	}
+
+	var c cypress.Message
+
+	err = c.Unmarshal(data)
+	if err != nil {
+		return nil
+	}
+
+	return &c
+}
+
+// Record on m why it's being sent to the dead letter output
+func markDeadLetter(m *cypress.Message, plugin string, err error) {
+	attempts, _ := m.GetInt(DeadLetterAttempts)
+
+	m.Remove(DeadLetterPlugin)
+	m.Remove(DeadLetterError)
+	m.Remove(DeadLetterAttempts)
+
+	m.AddString(DeadLetterPlugin, plugin)
+	m.AddString(DeadLetterError, err.Error())
+	m.AddInt(DeadLetterAttempts, attempts+1)
+}
+
+// Send m to the dead letter queue, if there is one
+func deadLetter(dl *OutputQueue, m *cypress.Message, plugin string, err error) {
+	if deadLettered(dl, m, plugin, err) {
+		dl.Push(m)
+	}
+}
+
+// Mark m to be sent to the dead letter queue, indicating if it can be
+func deadLettered(dl *OutputQueue, m *cypress.Message, plugin string, err error) bool {
+	if dl == nil {
+		return false
+	}
+
+	if m == nil {
+		log.Printf("Unable to copy message for dead letter output %s", dl.Name)
+		return false
+	}
+
+	markDeadLetter(m, plugin, err)
+
+	return true
+}
+
+// Create the queue feeding the route's dead letter output
+func (r *Router) newDeadLetter(route *Route) error {
+	recv, err := r.newReceiver(route.DeadLetter)
+	if err != nil {
+		return err
+	}
+
+	cfg := QueueConfig{
+		Size:     route.QueueSize,
+		Overflow: OverflowBlock,
+	}
+
+	route.deadLetter = newOutputQueue(route.DeadLetter, recv, cfg, nil)
+	route.deadLetter.stats = route.stats[route.DeadLetter]
+	route.deadLetterRecv = recv
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/errors"
+	"github.com/vektra/neko"
+)
+
+var errBroken = errors.New("broken plugin")
+
+// A plugin that fails at everything
+type brokenPlugin struct{}
+
+func (b *brokenPlugin) Filterer() (cypress.Filterer, error) {
+	return b, nil
+}
+
+func (b *brokenPlugin) Filter(m *cypress.Message) (*cypress.Message, error) {
+	m.Add("touched", true)
+	return nil, errBroken
+}
+
+func (b *brokenPlugin) Receiver() (cypress.Receiver, error) {
+	return b, nil
+}
+
+func (b *brokenPlugin) Receive(m *cypress.Message) error {
+	return errBroken
+}
+
+func (b *brokenPlugin) Close() error {
+	return nil
+}
+
+func init() {
+	cypress.AddPlugin("Broken", func() cypress.Plugin {
+		return &brokenPlugin{}
+	})
+}
+
+func TestDeadLetter(t *testing.T) {
+	n := neko.Start(t)
+
+	deadLetters := func(testToml string) *cypress.Message {
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		defer r.Shutdown(100 * time.Millisecond)
+
+		rt := r.routes["Default"]
+
+		m := cypress.Log()
+		m.Add("message", "hello")
+
+		rt.generators[0].(*cypress.TestPlugin).Messages <- m
+
+		dl := rt.deadLetterRecv.(*cypress.TestPlugin)
+
+		select {
+		case m2 := <-dl.Messages:
+			return m2
+		case <-time.After(1 * time.Second):
+			t.Fatal("message was not sent to the dead letter output")
+		}
+
+		return nil
+	}
+
+	n.It("receives the original message when a filter fails", func() {
+		m := deadLetters(`
+[input.Test]
+
+[broken.Broken]
+
+[output.Test]
+
+[failed.Test]
+
+[route.Default]
+input = ["input"]
+filter = ["broken"]
+output = ["output"]
+dead_letter = "failed"
+`)
+
+		msg, ok := m.GetString("message")
+		require.True(t, ok)
+		assert.Equal(t, "hello", msg)
+
+		_, ok = m.Get("touched")
+		assert.False(t, ok)
+
+		plugin, _ := m.GetString(DeadLetterPlugin)
+		assert.Equal(t, "broken", plugin)
+
+		reason, _ := m.GetString(DeadLetterError)
+		assert.Equal(t, errBroken.Error(), reason)
+
+		attempts, _ := m.GetInt(DeadLetterAttempts)
+		assert.Equal(t, int64(1), attempts)
+	})
+
+	n.It("receives messages an output failed to deliver", func() {
+		m := deadLetters(`
+[input.Test]
+
+[output.Broken]
+
+[failed.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+dead_letter = "failed"
+`)
+
+		msg, ok := m.GetString("message")
+		require.True(t, ok)
+		assert.Equal(t, "hello", msg)
+
+		plugin, _ := m.GetString(DeadLetterPlugin)
+		assert.Equal(t, "output", plugin)
+	})
+
+	n.It("counts the attempts across replays", func() {
+		m := cypress.Log()
+
+		markDeadLetter(m, "output", errBroken)
+		markDeadLetter(m, "output", errBroken)
+
+		attempts, _ := m.GetInt(DeadLetterAttempts)
+		assert.Equal(t, int64(2), attempts)
+
+		var plugins int
+
+		for _, attr := range m.Attributes {
+			if attr.StringKey(m) == DeadLetterPlugin {
+				plugins++
+			}
+		}
+
+		assert.Equal(t, 1, plugins)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,254 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/vektra/cypress"
+)
+
+// A plugin or route in a Graph
+type GraphNode struct {
+	// Unique within the graph, "plugin:<name>" or "route:<name>"
+	ID string `json:"id"`
+
+	// plugin or route
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	// For plugins, the plugin type and the roles it can play
+	Type  string   `json:"type,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+
+	// For routes
+	Disabled bool   `json:"disabled,omitempty"`
+	Match    string `json:"match,omitempty"`
+}
+
+// How a route uses a plugin. Inputs point at the route, everything
+// else points from the route to the plugin.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// input, filter, output, spill or dead_letter
+	Role string `json:"role"`
+
+	// The position of a filter in the route, starting at 1
+	Order int `json:"order,omitempty"`
+
+	// The when expression of an output
+	When string `json:"when,omitempty"`
+}
+
+// The plugins and routes of a Router and how they're connected
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+func pluginID(name string) string {
+	return "plugin:" + name
+}
+
+func routeID(name string) string {
+	return "route:" + name
+}
+
+// The topology of the router's configuration. The router doesn't
+// need to be opened, plugins not yet created are looked up by type
+// to find their roles. Nodes and edges are sorted so the graph of a
+// config is always the same.
+func (r *Router) Graph() *Graph {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	g := &Graph{
+		Nodes: []GraphNode{},
+		Edges: []GraphEdge{},
+	}
+
+	var names []string
+
+	for name := range r.plugins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		def := r.plugins[name]
+
+		plug := def.Plugin
+		if plug == nil {
+			plug, _ = cypress.FindPlugin(def.Type)
+		}
+
+		var roles []string
+
+		if plug != nil {
+			roles = PluginKinds(plug)
+		}
+
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:    pluginID(name),
+			Kind:  "plugin",
+			Name:  name,
+			Type:  def.Type,
+			Roles: roles,
+		})
+	}
+
+	routes := r.routes
+
+	// show the route Open will add
+	if len(routes) == 0 {
+		routes = map[string]*Route{"Default": defaultRoute()}
+	}
+
+	names = nil
+
+	for name := range routes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		route := routes[name]
+
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:       routeID(name),
+			Kind:     "route",
+			Name:     name,
+			Disabled: !route.Enabled,
+			Match:    route.Match,
+		})
+
+		g.Edges = append(g.Edges, route.edges()...)
+	}
+
+	return g
+}
+
+func (r *Route) edges() []GraphEdge {
+	id := routeID(r.Name)
+
+	var edges []GraphEdge
+
+	for _, name := range r.Input {
+		edges = append(edges, GraphEdge{From: pluginID(name), To: id, Role: "input"})
+	}
+
+	for i, name := range r.Filter {
+		edges = append(edges, GraphEdge{From: id, To: pluginID(name), Role: "filter", Order: i + 1})
+	}
+
+	for _, name := range r.Output {
+		edge := GraphEdge{From: id, To: pluginID(name), Role: "output"}
+
+		if q, ok := r.when[name]; ok {
+			edge.When = q.Source
+		}
+
+		edges = append(edges, edge)
+
+		cfg := r.queueConfig(name)
+
+		if cfg.Overflow == OverflowSpill && cfg.Spill != "" {
+			edges = append(edges, GraphEdge{From: id, To: pluginID(cfg.Spill), Role: "spill"})
+		}
+	}
+
+	if r.DeadLetter != "" {
+		edges = append(edges, GraphEdge{From: id, To: pluginID(r.DeadLetter), Role: "dead_letter"})
+	}
+
+	return edges
+}
+
+// Quote s as a DOT string
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+
+	return `"` + s + `"`
+}
+
+func (n GraphNode) dotAttrs() string {
+	if n.Kind == "route" {
+		label := n.Name
+
+		if n.Match != "" {
+			label += "\nmatch: " + n.Match
+		}
+
+		attrs := "shape=ellipse, label=" + dotQuote(label)
+
+		if n.Disabled {
+			attrs += ", style=dashed"
+		}
+
+		return attrs
+	}
+
+	label := n.Name + "\n" + n.Type
+
+	if len(n.Roles) > 0 {
+		label += " (" + strings.Join(n.Roles, ", ") + ")"
+	}
+
+	return "shape=box, label=" + dotQuote(label)
+}
+
+func (e GraphEdge) dotAttrs() string {
+	label := e.Role
+
+	switch e.Role {
+	case "filter":
+		label = fmt.Sprintf("filter %d", e.Order)
+	case "output":
+		if e.When != "" {
+			label = "when: " + e.When
+		}
+	case "dead_letter":
+		label = "dead letter"
+	}
+
+	attrs := "label=" + dotQuote(label)
+
+	if e.Role == "spill" || e.Role == "dead_letter" {
+		attrs += ", style=dashed"
+	}
+
+	return attrs
+}
+
+// Write the graph in Graphviz's DOT language
+func (g *Graph) WriteDOT(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "digraph router {\n\trankdir=LR;\n")
+	if err != nil {
+		return err
+	}
+
+	for _, n := range g.Nodes {
+		_, err = fmt.Fprintf(w, "\t%s [%s];\n", dotQuote(n.ID), n.dotAttrs())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range g.Edges {
+		_, err = fmt.Fprintf(w, "\t%s -> %s [%s];\n", dotQuote(e.From), dotQuote(e.To), e.dotAttrs())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "}\n")
+
+	return err
+}
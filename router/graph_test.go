@@ -0,0 +1,173 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestGraph(t *testing.T) {
+	n := neko.Start(t)
+
+	var r *Router
+
+	n.Setup(func() {
+		r = NewRouter()
+	})
+
+	load := func(cfg string) *Graph {
+		err := r.LoadConfig(strings.NewReader(cfg))
+		require.NoError(t, err)
+
+		return r.Graph()
+	}
+
+	n.It("includes every plugin and route", func() {
+		g := load(`
+[input.Test]
+
+[output.Test]
+
+[broken.Broken]
+
+[route.Default]
+input = ["input"]
+filter = ["broken"]
+output = ["output"]
+match = "level = 'error'"
+`)
+
+		require.Equal(t, 4, len(g.Nodes))
+
+		assert.Equal(t, GraphNode{
+			ID:    "plugin:broken",
+			Kind:  "plugin",
+			Name:  "broken",
+			Type:  "Broken",
+			Roles: []string{"output", "filter"},
+		}, g.Nodes[0])
+
+		assert.Equal(t, "plugin:input", g.Nodes[1].ID)
+		assert.Equal(t, []string{"input", "output", "filter"}, g.Nodes[1].Roles)
+
+		assert.Equal(t, "plugin:output", g.Nodes[2].ID)
+
+		assert.Equal(t, GraphNode{
+			ID:    "route:Default",
+			Kind:  "route",
+			Name:  "Default",
+			Match: "level = 'error'",
+		}, g.Nodes[3])
+
+		assert.Equal(t, []GraphEdge{
+			{From: "plugin:input", To: "route:Default", Role: "input"},
+			{From: "route:Default", To: "plugin:broken", Role: "filter", Order: 1},
+			{From: "route:Default", To: "plugin:output", Role: "output"},
+		}, g.Edges)
+	})
+
+	n.It("includes when clauses, spills and dead letters", func() {
+		g := load(`
+[input.Test]
+
+[output.Test]
+
+[spill.Test]
+
+[dead.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+dead_letter = "dead"
+
+[route.Default.queue.output]
+overflow = "spill"
+spill = "spill"
+
+[route.Default.when]
+output = "level = 'error'"
+`)
+
+		assert.Equal(t, []GraphEdge{
+			{From: "plugin:input", To: "route:Default", Role: "input"},
+			{From: "route:Default", To: "plugin:output", Role: "output", When: "level = 'error'"},
+			{From: "route:Default", To: "plugin:spill", Role: "spill"},
+			{From: "route:Default", To: "plugin:dead", Role: "dead_letter"},
+		}, g.Edges)
+	})
+
+	n.It("shows the default route", func() {
+		g := load(`
+[in.Test]
+
+[out.Test]
+`)
+
+		assert.Equal(t, []GraphEdge{
+			{From: "plugin:in", To: "route:Default", Role: "input"},
+			{From: "route:Default", To: "plugin:out", Role: "output"},
+		}, g.Edges)
+
+		assert.Equal(t, 0, len(r.routes))
+	})
+
+	n.It("writes DOT", func() {
+		g := load(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+enabled = false
+input = ["input"]
+output = ["output"]
+
+[route.Default.when]
+output = "message = \"hi\""
+`)
+
+		var buf bytes.Buffer
+
+		err := g.WriteDOT(&buf)
+		require.NoError(t, err)
+
+		expected := `digraph router {
+	rankdir=LR;
+	"plugin:input" [shape=box, label="input\nTest (input, output, filter)"];
+	"plugin:output" [shape=box, label="output\nTest (input, output, filter)"];
+	"route:Default" [shape=ellipse, label="Default", style=dashed];
+	"plugin:input" -> "route:Default" [label="input"];
+	"route:Default" -> "plugin:output" [label="when: message = \"hi\""];
+}
+`
+
+		assert.Equal(t, expected, buf.String())
+	})
+
+	n.It("encodes as JSON", func() {
+		g := load(`
+[input.Test]
+
+[output.Test]
+`)
+
+		data, err := json.Marshal(g)
+		require.NoError(t, err)
+
+		var g2 Graph
+
+		err = json.Unmarshal(data, &g2)
+		require.NoError(t, err)
+
+		assert.Equal(t, g, &g2)
+		assert.Contains(t, string(data), `"role":"input"`)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,232 @@
+package router
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/errors"
+)
+
+// What to do with a message when an output's queue is full
+const (
+	// Wait for room in the queue, stalling the route
+	OverflowBlock = "block"
+
+	// Discard the message being queued
+	OverflowDropNewest = "drop-newest"
+
+	// Discard the oldest queued message to make room
+	OverflowDropOldest = "drop-oldest"
+
+	// Hand the message to the route's spill output instead
+	OverflowSpill = "spill"
+)
+
+// How many messages an output may have queued if not configured
+const DefaultQueueSize = 100
+
+var (
+	ErrUnknownOverflow = errors.New("unknown overflow policy")
+	ErrNoSpill         = errors.New("spill overflow requires a spill output")
+	ErrSpillIsOutput   = errors.New("spill output is also an output of the route")
+)
+
+// The queue settings for one output of a Route. Unset values
+// are inherited from the Route.
+type QueueConfig struct {
+	Size     int
+	Overflow string
+	Spill    string
+}
+
+func validOverflow(policy string) bool {
+	switch policy {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSpill:
+		return true
+	default:
+		return false
+	}
+}
+
+// A bounded queue feeding a single Receiver. Each output of a Route
+// has it's own queue so that a slow output only affects itself
+// (unless it's overflow policy is to block).
+type OutputQueue struct {
+	Name     string
+	Overflow string
+
+	recv      cypress.Receiver
+	spill     cypress.Receiver
+	spillName string
+
+	// only messages matching when are queued, if set
+	when *cypress.Query
+
+	// the output plugin's counters
+	stats *PluginStats
+
+	// messages that can't be delivered are sent here, if set
+	deadLetter *OutputQueue
+
+	buf  chan *cypress.Message
+	done chan struct{}
+
+	// closed under lock once no more messages may be pushed, with quit
+	// closed first to wake pushes waiting on a full queue
+	lock   sync.RWMutex
+	closed bool
+	quit   chan struct{}
+
+	delivered uint64
+	dropped   uint64
+	spilled   uint64
+	errors    uint64
+}
+
+func newOutputQueue(name string, recv cypress.Receiver, cfg QueueConfig, spill cypress.Receiver) *OutputQueue {
+	size := cfg.Size
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	policy := cfg.Overflow
+	if policy == "" {
+		policy = OverflowBlock
+	}
+
+	q := &OutputQueue{
+		Name:     name,
+		Overflow: policy,
+		recv:     recv,
+		buf:      make(chan *cypress.Message, size),
+		done:     make(chan struct{}),
+		quit:     make(chan struct{}),
+	}
+
+	if spill != nil {
+		q.spill = spill
+		q.spillName = cfg.Spill
+	}
+
+	return q
+}
+
+// Add a message to the queue, applying the overflow policy if
+// the queue is full. Messages pushed once the queue is closed are
+// dropped.
+func (q *OutputQueue) Push(m *cypress.Message) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		return
+	}
+
+	select {
+	case q.buf <- m:
+		return
+	default:
+	}
+
+	switch q.Overflow {
+	case OverflowDropNewest:
+		atomic.AddUint64(&q.dropped, 1)
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.buf <- m:
+				return
+			default:
+			}
+
+			select {
+			case <-q.buf:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	case OverflowSpill:
+		err := q.spill.Receive(m)
+		if err != nil {
+			log.Printf("Error spilling message from %s: %s", q.Name, err)
+			atomic.AddUint64(&q.dropped, 1)
+		} else {
+			atomic.AddUint64(&q.spilled, 1)
+		}
+	default:
+		select {
+		case q.buf <- m:
+		case <-q.quit:
+			atomic.AddUint64(&q.dropped, 1)
+		}
+	}
+}
+
+// Stop accepting messages, so run delivers the ones queued and returns.
+// A push waiting for room in the queue gives up and drops its message.
+func (q *OutputQueue) stop() {
+	close(q.quit)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	close(q.buf)
+}
+
+// Deliver queued messages to the Receiver until the queue is closed
+func (q *OutputQueue) run() {
+	defer close(q.done)
+
+	for m := range q.buf {
+		err := q.recv.Receive(m)
+		if err != nil {
+			q.stats.recordError()
+			atomic.AddUint64(&q.errors, 1)
+			log.Printf("Error sending messages to %s: %s", q.Name, err)
+
+			if q.deadLetter != nil {
+				deadLetter(q.deadLetter, copyMessage(m), q.Name, err)
+			}
+
+			continue
+		}
+
+		q.stats.record(m)
+		atomic.AddUint64(&q.delivered, 1)
+	}
+}
+
+// How many messages are waiting to be delivered
+func (q *OutputQueue) Len() int {
+	return len(q.buf)
+}
+
+// How many messages the queue can hold
+func (q *OutputQueue) Cap() int {
+	return cap(q.buf)
+}
+
+// How many messages have been passed to the Receiver successfully
+func (q *OutputQueue) Delivered() uint64 {
+	return atomic.LoadUint64(&q.delivered)
+}
+
+// How many messages were discarded because the queue was full
+func (q *OutputQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// How many messages were sent to the spill output because the
+// queue was full
+func (q *OutputQueue) Spilled() uint64 {
+	return atomic.LoadUint64(&q.spilled)
+}
+
+// How many messages the Receiver returned an error for
+func (q *OutputQueue) Errors() uint64 {
+	return atomic.LoadUint64(&q.errors)
+}
@@ -0,0 +1,383 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+type stuckReceiver struct {
+	release chan struct{}
+	got     chan *cypress.Message
+}
+
+func newStuckReceiver() *stuckReceiver {
+	return &stuckReceiver{
+		release: make(chan struct{}),
+		got:     make(chan *cypress.Message, 100),
+	}
+}
+
+func (s *stuckReceiver) Receive(m *cypress.Message) error {
+	<-s.release
+	s.got <- m
+	return nil
+}
+
+func (s *stuckReceiver) Close() error {
+	return nil
+}
+
+// A receiver that changes the messages it receives
+type taggingReceiver struct {
+	got chan *cypress.Message
+}
+
+func (tr *taggingReceiver) Receiver() (cypress.Receiver, error) {
+	return tr, nil
+}
+
+func (tr *taggingReceiver) Receive(m *cypress.Message) error {
+	m.AddTag("seen", "yes")
+	m.Add("seen", true)
+
+	tr.got <- m
+	return nil
+}
+
+func (tr *taggingReceiver) Close() error {
+	return nil
+}
+
+func init() {
+	cypress.AddPlugin("Tagging", func() cypress.Plugin {
+		return &taggingReceiver{got: make(chan *cypress.Message, 100)}
+	})
+}
+
+func TestOutputQueue(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("drops new messages when full with drop-newest", func() {
+		recv := newStuckReceiver()
+
+		q := newOutputQueue("out", recv, QueueConfig{Size: 2, Overflow: OverflowDropNewest}, nil)
+
+		m1 := cypress.Log()
+		m2 := cypress.Log()
+		m3 := cypress.Log()
+
+		q.Push(m1)
+		q.Push(m2)
+		q.Push(m3)
+
+		assert.Equal(t, 2, q.Len())
+		assert.Equal(t, uint64(1), q.Dropped())
+
+		assert.Equal(t, m1, <-q.buf)
+		assert.Equal(t, m2, <-q.buf)
+	})
+
+	n.It("drops the oldest message when full with drop-oldest", func() {
+		recv := newStuckReceiver()
+
+		q := newOutputQueue("out", recv, QueueConfig{Size: 2, Overflow: OverflowDropOldest}, nil)
+
+		m1 := cypress.Log()
+		m2 := cypress.Log()
+		m3 := cypress.Log()
+
+		q.Push(m1)
+		q.Push(m2)
+		q.Push(m3)
+
+		assert.Equal(t, 2, q.Len())
+		assert.Equal(t, uint64(1), q.Dropped())
+
+		assert.Equal(t, m2, <-q.buf)
+		assert.Equal(t, m3, <-q.buf)
+	})
+
+	n.It("sends messages to the spill output when full", func() {
+		recv := newStuckReceiver()
+		var spill cypress.BufferReceiver
+
+		q := newOutputQueue("out", recv, QueueConfig{Size: 1, Overflow: OverflowSpill}, &spill)
+
+		m1 := cypress.Log()
+		m2 := cypress.Log()
+
+		q.Push(m1)
+		q.Push(m2)
+
+		assert.Equal(t, 1, q.Len())
+		assert.Equal(t, uint64(1), q.Spilled())
+		assert.Equal(t, uint64(0), q.Dropped())
+
+		require.Equal(t, 1, len(spill.Messages))
+		assert.Equal(t, m2, spill.Messages[0])
+	})
+
+	n.It("delivers queued messages to the receiver", func() {
+		recv := newStuckReceiver()
+
+		q := newOutputQueue("out", recv, QueueConfig{}, nil)
+
+		assert.Equal(t, DefaultQueueSize, q.Cap())
+		assert.Equal(t, OverflowBlock, q.Overflow)
+
+		go q.run()
+
+		m := cypress.Log()
+		q.Push(m)
+
+		close(recv.release)
+
+		select {
+		case m2 := <-recv.got:
+			assert.Equal(t, m, m2)
+		case <-time.After(1 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+
+		close(q.buf)
+		<-q.done
+
+		assert.Equal(t, uint64(1), q.Delivered())
+	})
+
+	n.Meow()
+}
+
+func TestRouterQueues(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("reads queue settings from the route", func() {
+		testToml := `
+[input.Test]
+
+[fast.Test]
+
+[slow.Test]
+
+[extra.Test]
+
+[route.Default]
+input = ["input"]
+output = ["fast", "slow"]
+queue_size = 50
+overflow = "drop-newest"
+
+[route.Default.queue.slow]
+size = 5
+overflow = "spill"
+spill = "extra"
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		r1, ok := r.routes["Default"]
+		require.True(t, ok)
+
+		queues := r1.Queues()
+		require.Equal(t, 2, len(queues))
+
+		assert.Equal(t, "fast", queues[0].Name)
+		assert.Equal(t, 50, queues[0].Cap())
+		assert.Equal(t, OverflowDropNewest, queues[0].Overflow)
+
+		assert.Equal(t, "slow", queues[1].Name)
+		assert.Equal(t, 5, queues[1].Cap())
+		assert.Equal(t, OverflowSpill, queues[1].Overflow)
+		assert.NotNil(t, queues[1].spill)
+	})
+
+	n.It("loads multiple routes", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.One]
+input = ["input"]
+output = ["output"]
+
+[route.Two]
+enabled = false
+input = ["input"]
+output = ["output"]
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(r.routes))
+
+		assert.True(t, r.routes["One"].Enabled)
+		assert.False(t, r.routes["Two"].Enabled)
+	})
+
+	n.It("rejects unknown overflow policies", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+overflow = "explode"
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		assert.Error(t, err)
+	})
+
+	n.It("rejects spilling to one of the route's outputs", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[archive.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output", "archive"]
+overflow = "spill"
+spill = "archive"
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), ErrSpillIsOutput.Error())
+	})
+
+	n.It("gives each output it's own copy of the message", func() {
+		testToml := `
+[input.Test]
+
+[one.Tagging]
+
+[two.Tagging]
+
+[route.Default]
+input = ["input"]
+output = ["one", "two"]
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		defer r.Shutdown(100 * time.Millisecond)
+
+		r1 := r.routes["Default"]
+
+		in := r1.generators[0].(*cypress.TestPlugin)
+		one := r1.receivers[0].(*taggingReceiver)
+		two := r1.receivers[1].(*taggingReceiver)
+
+		for i := 0; i < 20; i++ {
+			m := cypress.Log()
+			m.Add("iter", i)
+
+			in.Messages <- m
+
+			var got []*cypress.Message
+
+			for _, tr := range []*taggingReceiver{one, two} {
+				select {
+				case m2 := <-tr.got:
+					got = append(got, m2)
+				case <-time.After(1 * time.Second):
+					t.Fatal("message did not flow through the router")
+				}
+			}
+
+			require.True(t, got[0] != got[1])
+
+			for _, m2 := range got {
+				iter, ok := m2.GetInt("iter")
+				require.True(t, ok)
+				assert.Equal(t, int64(i), iter)
+
+				assert.Equal(t, 1, len(m2.Tags))
+			}
+		}
+	})
+
+	n.It("keeps other outputs flowing when one is stuck", func() {
+		testToml := `
+[input.Test]
+
+[fast.Test]
+
+[slow.Test]
+
+[route.Default]
+input = ["input"]
+output = ["fast", "slow"]
+queue_size = 1
+overflow = "drop-newest"
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		defer r.Shutdown(100 * time.Millisecond)
+
+		r1 := r.routes["Default"]
+
+		in := r1.generators[0].(*cypress.TestPlugin)
+		out := r1.receivers[0].(*cypress.TestPlugin)
+
+		// Nothing reads from slow, so it will back up once it's internal
+		// buffer is full.
+		for i := 0; i < 20; i++ {
+			m := cypress.Log()
+			m.Add("iter", i)
+
+			in.Messages <- m
+
+			select {
+			case m2 := <-out.Messages:
+				assert.Equal(t, m, m2)
+			case <-time.After(1 * time.Second):
+				t.Fatal("message did not flow through the router")
+			}
+		}
+
+		assert.True(t, r1.Dropped() > 0)
+	})
+
+	n.Meow()
+}
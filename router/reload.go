@@ -0,0 +1,482 @@
+package router
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/naoina/toml/ast"
+	"github.com/vektra/cypress"
+	"github.com/vektra/errors"
+)
+
+// Render a config table in a canonical form so that definitions can be
+// compared regardless of key order.
+func configSource(v interface{}) string {
+	switch x := v.(type) {
+	case *ast.Table:
+		var keys []string
+
+		for k := range x.Fields {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		var parts []string
+
+		for _, k := range keys {
+			parts = append(parts, k+"="+configSource(x.Fields[k]))
+		}
+
+		return "{" + strings.Join(parts, ",") + "}"
+	case []*ast.Table:
+		var parts []string
+
+		for _, t := range x {
+			parts = append(parts, configSource(t))
+		}
+
+		return "[" + strings.Join(parts, ",") + "]"
+	case *ast.KeyValue:
+		return x.Value.Source()
+	case nil:
+		return ""
+	default:
+		return "?"
+	}
+}
+
+// Indicates if def describes the same plugin with the same settings
+func (def *PluginDefinition) sameAs(o *PluginDefinition) bool {
+	return strings.ToLower(def.Type) == strings.ToLower(o.Type) &&
+		configSource(def.Config) == configSource(o.Config)
+}
+
+// The configured settings of a route, used to detect changes
+type routeSettings struct {
+	Enabled         bool
+	Input, Output   []string
+	Filter          []string
+	QueueSize       int
+	Overflow, Spill string
+	QueueConfigs    map[string]QueueConfig
+	Match           string
+	When            map[string]string
+	DeadLetter      string
+	Workers         int
+	Ordered         bool
+}
+
+func (r *Route) settings() routeSettings {
+	return routeSettings{
+		Enabled:      r.Enabled,
+		Input:        r.Input,
+		Output:       r.Output,
+		Filter:       r.Filter,
+		QueueSize:    r.QueueSize,
+		Overflow:     r.Overflow,
+		Spill:        r.Spill,
+		QueueConfigs: r.queueConfigs,
+		Match:        r.Match,
+		When:         r.whenSources(),
+		DeadLetter:   r.DeadLetter,
+		Workers:      r.workers(),
+		Ordered:      r.Ordered,
+	}
+}
+
+func (r *Route) whenSources() map[string]string {
+	if len(r.when) == 0 {
+		return nil
+	}
+
+	sources := make(map[string]string, len(r.when))
+
+	for name, q := range r.when {
+		sources[name] = q.Source
+	}
+
+	return sources
+}
+
+// Indicates if the route uses any of the named plugins
+func (r *Route) uses(names map[string]bool) bool {
+	for _, list := range [][]string{r.Input, r.Filter, r.Output} {
+		for _, name := range list {
+			if names[name] {
+				return true
+			}
+		}
+	}
+
+	for _, q := range r.queues {
+		if q.spill != nil && names[q.spillName] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Route) running() bool {
+	if r.done == nil {
+		return false
+	}
+
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func contains(list []string, name string) bool {
+	for _, s := range list {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read a new configuration and apply it to the running router. Plugins
+// whose definition has not changed are left running, so for instance
+// a TCP input keeps it's listener open. Routes that changed are rewired
+// in place, new routes are started and removed routes are shutdown.
+func (r *Router) Reload(i io.Reader) error {
+	next := NewRouter()
+
+	err := next.LoadConfig(i)
+	if err != nil {
+		return err
+	}
+
+	next.addDefaultRoute()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Create all the new plugins up front so that a bad definition
+	// aborts the reload before anything is touched.
+	changed := make(map[string]bool)
+
+	for name, def := range next.plugins {
+		if old, ok := r.plugins[name]; ok && old.sameAs(def) {
+			next.plugins[name] = old
+			continue
+		}
+
+		err := def.instantiate()
+		if err != nil {
+			return err
+		}
+
+		changed[name] = true
+	}
+
+	for name := range r.plugins {
+		if _, ok := next.plugins[name]; !ok {
+			changed[name] = true
+		}
+	}
+
+	for _, nr := range next.routes {
+		if !nr.Enabled {
+			continue
+		}
+
+		err := next.checkWiring(nr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Wire the new routes before touching the running ones as well, so
+	// that a plugin failing to open also leaves everything as it was.
+	staged := make(map[string]*Route)
+
+	for name, nr := range next.routes {
+		if _, ok := r.routes[name]; ok || !nr.Enabled {
+			continue
+		}
+
+		err := next.wireRoute(nr)
+		if err != nil {
+			logReport(nr.stop(0))
+
+			for _, route := range staged {
+				logReport(route.stop(0))
+			}
+
+			return err
+		}
+
+		staged[name] = nr
+	}
+
+	for name, route := range r.routes {
+		nr, ok := next.routes[name]
+
+		// Every output feeds the dead letter queue and the workers are
+		// started with the route, so changing either means restarting
+		// the route.
+		restart := ok && (nr.DeadLetter != route.DeadLetter || changed[route.DeadLetter] ||
+			nr.workers() != route.workers() || nr.Ordered != route.Ordered)
+
+		if ok && nr.Enabled && route.running() && !restart {
+			continue
+		}
+
+		logReport(route.stop(DefaultShutdownTimeout))
+		delete(r.routes, name)
+	}
+
+	r.plugins = next.plugins
+
+	for name, nr := range staged {
+		r.routes[name] = nr
+		nr.start()
+	}
+
+	for name, nr := range next.routes {
+		if _, ok := staged[name]; ok {
+			continue
+		}
+
+		route, ok := r.routes[name]
+		if !ok {
+			// the route was restarted, so it's inputs could only be
+			// opened once the old route was stopped
+			r.routes[name] = nr
+
+			if !nr.Enabled {
+				continue
+			}
+
+			err := r.wireRoute(nr)
+			if err != nil {
+				logReport(nr.stop(0))
+				nr.Enabled = false
+				return err
+			}
+
+			nr.start()
+			continue
+		}
+
+		if reflect.DeepEqual(route.settings(), nr.settings()) && !route.uses(changed) {
+			continue
+		}
+
+		err := r.rewireRoute(route, nr, changed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Change a running route to match next. Inputs and outputs that are
+// unchanged are kept as is, the rest are closed and replaced. Messages
+// are held at the route's inputs while it is rewired.
+func (r *Router) rewireRoute(route, next *Route, changed map[string]bool) error {
+	route.lock.Lock()
+	defer route.lock.Unlock()
+
+	// Keep the route's feed open even if every input is being replaced
+	route.inputs.Add(1)
+	defer route.inputs.Done()
+
+	report := &ShutdownReport{}
+	defer logReport(report)
+
+	r.trackStats(route, next.Input, next.Filter, next.Output, []string{next.DeadLetter})
+
+	var (
+		inputs     []string
+		generators []cypress.Generator
+	)
+
+	for i, name := range route.Input {
+		g := route.generators[i]
+
+		if changed[name] || !contains(next.Input, name) {
+			err := g.Close()
+			if err != nil {
+				report.Errors = append(report.Errors, err)
+			}
+
+			continue
+		}
+
+		inputs = append(inputs, name)
+		generators = append(generators, g)
+	}
+
+	defer func() {
+		route.Input = inputs
+		route.generators = generators
+	}()
+
+	for _, name := range next.Input {
+		if contains(inputs, name) {
+			continue
+		}
+
+		g, err := r.newGenerator(name)
+		if err != nil {
+			return err
+		}
+
+		route.startInput(name, g)
+
+		inputs = append(inputs, name)
+		generators = append(generators, g)
+	}
+
+	var filters []cypress.Filterer
+
+	for _, name := range next.Filter {
+		var filt cypress.Filterer
+
+		if !changed[name] {
+			for i, prev := range route.Filter {
+				if prev == name {
+					filt = route.filters[i]
+					break
+				}
+			}
+		}
+
+		if filt == nil {
+			var err error
+
+			filt, err = r.newFilterer(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		filters = append(filters, filt)
+	}
+
+	route.Filter = next.Filter
+	route.filters = filters
+
+	// Retire the queues that are changing first, so that the output is
+	// closed before it's replacement is created.
+	kept := make(map[string]*OutputQueue)
+	keptRecv := make(map[string]cypress.Receiver)
+
+	for i, q := range route.queues {
+		name := route.Output[i]
+
+		cfg := route.queueConfig(name)
+
+		if changed[name] || !contains(next.Output, name) ||
+			cfg != next.queueConfig(name) ||
+			(q.spill != nil && changed[q.spillName]) {
+			q.stop()
+
+			select {
+			case <-q.done:
+				report.closeReceiver(name, route.receivers[i])
+			case <-time.After(DefaultShutdownTimeout):
+				report.Undelivered = append(report.Undelivered, Undelivered{route.Name, name, q.Len()})
+				report.Errors = append(report.Errors, errors.Subject(ErrOutputBusy, name))
+			}
+
+			continue
+		}
+
+		kept[name] = q
+		keptRecv[name] = route.receivers[i]
+	}
+
+	oldSpills := route.spills
+
+	route.QueueSize = next.QueueSize
+	route.Overflow = next.Overflow
+	route.Spill = next.Spill
+	route.queueConfigs = next.queueConfigs
+	route.spills = make(map[string]cypress.Receiver)
+
+	route.Match = next.Match
+	route.matcher = next.matcher
+	route.when = next.when
+
+	for name, q := range kept {
+		if q.spill != nil {
+			route.spills[q.spillName] = q.spill
+		}
+
+		q.when = route.when[name]
+	}
+
+	var (
+		outputs   []string
+		receivers []cypress.Receiver
+		queues    []*OutputQueue
+	)
+
+	defer func() {
+		route.Output = outputs
+		route.receivers = receivers
+		route.queues = queues
+
+		for name, recv := range oldSpills {
+			if route.spills[name] != recv {
+				report.closeReceiver(name, recv)
+			}
+		}
+	}()
+
+	for _, name := range next.Output {
+		if q, ok := kept[name]; ok {
+			outputs = append(outputs, name)
+			receivers = append(receivers, keptRecv[name])
+			queues = append(queues, q)
+			continue
+		}
+
+		recv, err := r.newReceiver(name)
+		if err != nil {
+			return err
+		}
+
+		q, err := r.newQueue(route, name, recv)
+		if err != nil {
+			recv.Close()
+			return err
+		}
+
+		go q.run()
+
+		outputs = append(outputs, name)
+		receivers = append(receivers, recv)
+		queues = append(queues, q)
+	}
+
+	return nil
+}
+
+func logReport(report *ShutdownReport) {
+	for _, name := range report.TimedOut {
+		log.Printf("Route %s did not drain before the deadline", name)
+	}
+
+	for _, u := range report.Undelivered {
+		log.Printf("%s", u)
+	}
+
+	for _, err := range report.Errors {
+		log.Printf("Error closing plugin: %s", err)
+	}
+}
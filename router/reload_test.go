@@ -0,0 +1,327 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestReload(t *testing.T) {
+	n := neko.Start(t)
+
+	var r *Router
+
+	n.Setup(func() {
+		r = NewRouter()
+	})
+
+	n.Cleanup(func() {
+		r.Shutdown(100 * time.Millisecond)
+	})
+
+	open := func(cfg string) {
+		err := r.LoadConfig(strings.NewReader(cfg))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+	}
+
+	send := func(route string) {
+		rt := r.routes[route]
+
+		in := rt.generators[0].(*cypress.TestPlugin)
+
+		m := cypress.Log()
+		in.Messages <- m
+
+		out := rt.receivers[0].(*cypress.TestPlugin)
+
+		select {
+		case m2 := <-out.Messages:
+			assert.Equal(t, m, m2)
+		case <-time.After(1 * time.Second):
+			t.Fatal("message did not flow through the router")
+		}
+	}
+
+	n.It("keeps plugins that have not changed", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`)
+
+		in := r.routes["Default"].generators[0]
+		out := r.routes["Default"].receivers[0]
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`))
+
+		require.NoError(t, err)
+
+		assert.True(t, in == r.routes["Default"].generators[0])
+		assert.True(t, out == r.routes["Default"].receivers[0])
+
+		send("Default")
+	})
+
+	n.It("replaces outputs that changed", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`)
+
+		in := r.routes["Default"].generators[0]
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[other.Test]
+
+[route.Default]
+input = ["input"]
+output = ["other"]
+`))
+
+		require.NoError(t, err)
+
+		rt := r.routes["Default"]
+
+		assert.True(t, in == rt.generators[0])
+		assert.Equal(t, []string{"other"}, rt.Output)
+		assert.Equal(t, 1, len(rt.Queues()))
+
+		send("Default")
+	})
+
+	n.It("starts new routes and stops removed ones", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.One]
+input = ["input"]
+output = ["output"]
+`)
+
+		one := r.routes["One"]
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[input2.Test]
+
+[output2.Test]
+
+[route.Two]
+input = ["input2"]
+output = ["output2"]
+`))
+
+		require.NoError(t, err)
+
+		_, ok := r.routes["One"]
+		assert.False(t, ok)
+		assert.False(t, one.running())
+
+		two, ok := r.routes["Two"]
+		require.True(t, ok)
+		assert.True(t, two.running())
+
+		send("Two")
+	})
+
+	n.It("leaves the router alone if the new config is bad", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`)
+
+		rt := r.routes["Default"]
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.NotAPlugin]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`))
+
+		require.Error(t, err)
+
+		assert.True(t, rt == r.routes["Default"])
+		assert.Equal(t, "Test", r.plugins["output"].Type)
+
+		send("Default")
+	})
+
+	n.It("leaves the running routes alone if a new route is bad", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`)
+
+		rt := r.routes["Default"]
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[other.Test]
+
+[route.Default]
+input = ["input"]
+output = ["other"]
+
+[route.Broken]
+input = ["input"]
+output = ["missing"]
+`))
+
+		require.Error(t, err)
+
+		assert.True(t, rt == r.routes["Default"])
+		assert.True(t, rt.running())
+
+		_, ok := r.routes["Broken"]
+		assert.False(t, ok)
+
+		send("Default")
+
+		assert.NotPanics(t, func() {
+			report := r.Shutdown(time.Second)
+			assert.True(t, report.Clean())
+		})
+	})
+
+	n.It("keeps tracking the dead letter output of a rewired route", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[failed.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+dead_letter = "failed"
+`)
+
+		err := r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[failed.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+dead_letter = "failed"
+match = 'type == "metric"'
+`))
+
+		require.NoError(t, err)
+
+		_, ok := r.routes["Default"].stats["failed"]
+		assert.True(t, ok)
+	})
+
+	n.It("reloads while an output is blocked", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+queue_size = 1
+`)
+
+		in := r.routes["Default"].generators[0].(*cypress.TestPlugin)
+		out := r.routes["Default"].receivers[0].(*cypress.TestPlugin)
+
+		// Nothing reads from output yet, so once it and the queue are full
+		// the route waits to push the rest
+		for i := 0; i < 15; i++ {
+			in.Messages <- cypress.Log()
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- r.Reload(strings.NewReader(`
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+queue_size = 1
+match = 'type == "log"'
+`))
+		}()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Error("reload blocked behind the full output")
+		}
+
+		for i := 0; i < 15; i++ {
+			select {
+			case <-out.Messages:
+			case <-time.After(1 * time.Second):
+				t.Fatalf("only %d messages flowed through the router", i)
+			}
+		}
+	})
+
+	n.Meow()
+}
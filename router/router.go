@@ -1,9 +1,12 @@
 package router
 
 import (
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"sync"
 
 	"github.com/naoina/toml"
 	"github.com/naoina/toml/ast"
@@ -15,6 +18,13 @@ type PluginDefinition struct {
 	Name, Type string
 	Config     *ast.Table
 	Plugin     cypress.Plugin
+
+	stats PluginStats
+}
+
+// Counters for the messages handled by the plugin
+func (def *PluginDefinition) Stats() *PluginStats {
+	return &def.stats
 }
 
 type Route struct {
@@ -24,14 +34,65 @@ type Route struct {
 	Output  []string
 	Filter  []string
 
+	// Only messages matching this expression enter the route
+	Match string
+
+	// Messages that fail filtering or delivery are sent to this output
+	DeadLetter string `toml:"dead_letter"`
+
+	// How many messages are filtered at once, 1 if unset. With more
+	// than one worker, messages may reach the outputs out of order
+	// unless Ordered is set, in which case messages with the same
+	// session_id are kept in order. Filters used by a route with
+	// multiple workers must be safe to call concurrently.
+	Workers int
+	Ordered bool
+
+	// Defaults for the queue in front of each output
+	QueueSize int `toml:"queue_size"`
+	Overflow  string
+	Spill     string
+
 	generators []cypress.Generator
 	receivers  []cypress.Receiver
 	filters    []cypress.Filterer
+
+	matcher *cypress.Query
+
+	// per output match expressions, from the when table
+	when map[string]*cypress.Query
+
+	queueConfigs map[string]QueueConfig
+	queues       []*OutputQueue
+	spills       map[string]cypress.Receiver
+
+	// the counters of the plugins used by the route
+	stats map[string]*PluginStats
+
+	deadLetter     *OutputQueue
+	deadLetterRecv cypress.Receiver
+
+	// held for writing while the route is rewired
+	lock sync.RWMutex
+
+	feed   chan *cypress.Message
+	inputs sync.WaitGroup
+
+	// closed once the route has delivered everything and stopped
+	done chan struct{}
 }
 
 type Router struct {
+	lock sync.Mutex
+
 	plugins map[string]*PluginDefinition
 	routes  map[string]*Route
+
+	admin         AdminConfig
+	adminListener net.Listener
+
+	shutdown     sync.Once
+	shutdownInfo *ShutdownReport
 }
 
 func NewRouter() *Router {
@@ -42,6 +103,9 @@ func NewRouter() *Router {
 }
 
 func (r *Router) Routes() []*Route {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	var routes []*Route
 
 	for _, route := range r.routes {
@@ -69,44 +133,178 @@ var ErrInvalidConfig = errors.New("invalid configuration")
 
 func (r *Router) loadPlugins(top *ast.Table) error {
 	for key, val := range top.Fields {
-		typ := key
-
-		switch sub := val.(type) {
-		case *ast.Table:
-			if len(sub.Fields) == 1 {
-				for skey, sval := range sub.Fields {
-					if stable, ok := sval.(*ast.Table); ok {
-						typ = skey
-						sub = stable
-					}
-
-					break
-				}
+		err := r.loadEntry(key, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load one top level entry of the config, a plugin definition or
+// the route or admin tables.
+func (r *Router) loadEntry(key string, val interface{}) error {
+	sub, ok := val.(*ast.Table)
+	if !ok {
+		return errors.Subject(ErrInvalidConfig, key)
+	}
+
+	switch key {
+	case "route":
+		return r.loadRoutes(sub)
+	case "admin":
+		err := toml.UnmarshalTable(sub, &r.admin)
+		if err != nil {
+			return errors.Subject(err, key)
+		}
+
+		return nil
+	}
+
+	typ := key
+
+	if len(sub.Fields) == 1 {
+		for skey, sval := range sub.Fields {
+			if stable, ok := sval.(*ast.Table); ok {
+				typ = skey
+				sub = stable
 			}
 
-			if key == "route" {
-				var route Route
-				route.Enabled = true
-				route.Name = typ
+			break
+		}
+	}
 
-				err := toml.UnmarshalTable(sub, &route)
-				if err != nil {
-					return err
-				}
+	r.plugins[key] = &PluginDefinition{
+		Name:   key,
+		Type:   typ,
+		Config: sub,
+	}
 
-				r.routes[typ] = &route
-			} else {
-				r.plugins[key] = &PluginDefinition{
-					Name:   key,
-					Type:   typ,
-					Config: sub,
-				}
+	return nil
+}
+
+// Load the routes in the route table
+func (r *Router) loadRoutes(top *ast.Table) error {
+	for name, tbl := range routeTables(top) {
+		err := r.loadRoute(name, tbl)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The tables defining each route. Each sub-table of the route table
+// is a seperate route, unless the table contains plain keys, in which
+// case it's a single route named "route".
+func routeTables(top *ast.Table) map[string]*ast.Table {
+	for _, val := range top.Fields {
+		if _, ok := val.(*ast.Table); !ok {
+			return map[string]*ast.Table{"route": top}
+		}
+	}
+
+	tables := make(map[string]*ast.Table, len(top.Fields))
+
+	for name, val := range top.Fields {
+		tables[name] = val.(*ast.Table)
+	}
+
+	return tables
+}
+
+func (r *Router) loadRoute(name string, tbl *ast.Table) error {
+	route := &Route{
+		Name:         name,
+		Enabled:      true,
+		queueConfigs: make(map[string]QueueConfig),
+	}
+
+	if qv, ok := tbl.Fields["queue"]; ok {
+		queues, ok := qv.(*ast.Table)
+		if !ok {
+			return errors.Subject(ErrInvalidConfig, name)
+		}
+
+		for output, val := range queues.Fields {
+			qt, ok := val.(*ast.Table)
+			if !ok {
+				return errors.Subject(ErrInvalidConfig, name)
+			}
+
+			var cfg QueueConfig
+
+			err := toml.UnmarshalTable(qt, &cfg)
+			if err != nil {
+				return errors.Subject(err, name)
+			}
+
+			route.queueConfigs[output] = cfg
+		}
+	}
+
+	if wv, ok := tbl.Fields["when"]; ok {
+		when, ok := wv.(*ast.Table)
+		if !ok {
+			return errors.Subject(ErrInvalidConfig, name)
+		}
+
+		route.when = make(map[string]*cypress.Query)
+
+		for output, val := range when.Fields {
+			kv, ok := val.(*ast.KeyValue)
+			if !ok {
+				return errors.Subject(ErrInvalidConfig, name)
+			}
+
+			str, ok := kv.Value.(*ast.String)
+			if !ok {
+				return errors.Subject(ErrInvalidConfig, name)
+			}
+
+			query, err := cypress.ParseQuery(str.Value)
+			if err != nil {
+				return errors.Subject(err, name)
 			}
-		default:
-			return ErrInvalidConfig
+
+			route.when[output] = query
+		}
+	}
+
+	// The queue and when tables are handled above, so strip them out
+	// before unmarshaling the rest.
+	fields := make(map[string]interface{}, len(tbl.Fields))
+
+	for k, v := range tbl.Fields {
+		if k != "queue" && k != "when" {
+			fields[k] = v
+		}
+	}
+
+	stripped := *tbl
+	stripped.Fields = fields
+	tbl = &stripped
+
+	err := toml.UnmarshalTable(tbl, route)
+	if err != nil {
+		return errors.Subject(err, name)
+	}
+
+	if route.Workers < 0 {
+		return errors.Subject(ErrInvalidConfig, name)
+	}
+
+	if route.Match != "" {
+		route.matcher, err = cypress.ParseQuery(route.Match)
+		if err != nil {
+			return errors.Subject(err, name)
 		}
 	}
 
+	r.routes[name] = route
+
 	return nil
 }
 
@@ -117,176 +315,545 @@ var (
 	ErrInvalidFilterer  = errors.New("invalid/nil filterer")
 )
 
+// Create the Plugin for def and configure it
+func (def *PluginDefinition) instantiate() error {
+	plug, ok := cypress.FindPlugin(def.Type)
+	if !ok {
+		return errors.Subject(ErrUnknownPlugin, def.Type)
+	}
+
+	err := toml.UnmarshalTable(def.Config, plug)
+	if err != nil {
+		return errors.Subject(err, def.Name)
+	}
+
+	def.Plugin = plug
+
+	return nil
+}
+
 func (r *Router) Open() error {
-	for name, def := range r.plugins {
-		if def.Plugin == nil {
-			plug, ok := cypress.FindPlugin(def.Type)
-			if !ok {
-				return errors.Subject(ErrUnknownPlugin, def.Type)
-			}
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-			err := toml.UnmarshalTable(def.Config, plug)
+	for _, def := range r.plugins {
+		if def.Plugin == nil {
+			err := def.instantiate()
 			if err != nil {
-				return errors.Subject(err, name)
+				return err
 			}
-
-			def.Plugin = plug
 		}
 	}
 
-	var err error
-
-	if len(r.routes) == 0 {
-		r.routes["Default"] = &Route{
-			Name:    "Default",
-			Enabled: true,
-			Input:   []string{"in"},
-			Output:  []string{"out"},
-		}
-	}
+	r.addDefaultRoute()
 
-	err = r.wireRoutes()
+	err := r.wireRoutes()
 	if err != nil {
 		return err
 	}
 
 	for _, route := range r.routes {
 		if route.Enabled {
-			go route.Flow()
+			route.start()
 		}
 	}
 
+	if r.admin.Listen != "" {
+		return r.startAdmin()
+	}
+
 	return nil
 }
 
+// If no routes are configured, route "in" to "out"
+func (r *Router) addDefaultRoute() {
+	if len(r.routes) == 0 {
+		r.routes["Default"] = defaultRoute()
+	}
+}
+
+func defaultRoute() *Route {
+	return &Route{
+		Name:    "Default",
+		Enabled: true,
+		Input:   []string{"in"},
+		Output:  []string{"out"},
+	}
+}
+
 func (r *Router) wireRoutes() error {
 	for _, route := range r.routes {
 		if !route.Enabled {
 			continue
 		}
 
-		for _, name := range route.Input {
-			def, ok := r.plugins[name]
-			if !ok {
-				return errors.Subject(ErrUnknownPlugin, name)
-			}
+		err := r.wireRoute(route)
+		if err != nil {
+			return err
+		}
+	}
 
-			gp, ok := def.Plugin.(cypress.GeneratorPlugin)
-			if !ok {
-				return errors.Subject(ErrInvalidGenerator, name)
-			}
+	return nil
+}
 
-			gen, err := gp.Generator()
-			if err != nil {
-				return errors.Subject(err, name)
-			}
+func (r *Router) wireRoute(route *Route) error {
+	r.trackStats(route, route.Input, route.Filter, route.Output, []string{route.DeadLetter})
 
-			if gen == nil {
-				return errors.Subject(ErrInvalidGenerator, name)
-			}
+	for _, name := range route.Input {
+		gen, err := r.newGenerator(name)
+		if err != nil {
+			return err
+		}
+
+		route.generators = append(route.generators, gen)
+	}
 
-			route.generators = append(route.generators, gen)
+	for _, name := range route.Filter {
+		filt, err := r.newFilterer(name)
+		if err != nil {
+			return err
 		}
 
-		for _, name := range route.Filter {
-			def, ok := r.plugins[name]
-			if !ok {
-				return errors.Subject(ErrUnknownPlugin, name)
-			}
+		route.filters = append(route.filters, filt)
+	}
 
-			fp, ok := def.Plugin.(cypress.FiltererPlugin)
-			if !ok {
-				return errors.Subject(ErrInvalidFilterer, name)
-			}
+	for _, name := range route.Output {
+		recv, err := r.newReceiver(name)
+		if err != nil {
+			return err
+		}
 
-			filt, err := fp.Filterer()
-			if err != nil {
-				return errors.Subject(err, name)
-			}
+		route.receivers = append(route.receivers, recv)
+	}
 
-			if filt == nil {
-				return errors.Subject(ErrInvalidFilterer, name)
-			}
+	route.spills = make(map[string]cypress.Receiver)
 
-			route.filters = append(route.filters, filt)
+	if route.DeadLetter != "" {
+		err := r.newDeadLetter(route)
+		if err != nil {
+			return err
 		}
+	}
 
-		for _, name := range route.Output {
-			def, ok := r.plugins[name]
-			if !ok {
-				return errors.Subject(ErrUnknownPlugin, name)
-			}
+	for i, name := range route.Output {
+		q, err := r.newQueue(route, name, route.receivers[i])
+		if err != nil {
+			return err
+		}
 
-			rp, ok := def.Plugin.(cypress.ReceiverPlugin)
-			if !ok {
-				return errors.Subject(ErrInvalidGenerator, name)
-			}
+		route.queues = append(route.queues, q)
+	}
 
-			recv, err := rp.Receiver()
-			if err != nil {
-				return errors.Subject(err, name)
+	return nil
+}
+
+// Point the route at the counters of the plugins it uses
+func (r *Router) trackStats(route *Route, lists ...[]string) {
+	route.stats = make(map[string]*PluginStats)
+
+	for _, list := range lists {
+		for _, name := range list {
+			if def, ok := r.plugins[name]; ok {
+				route.stats[name] = def.Stats()
 			}
+		}
+	}
+}
+
+func (r *Router) newGenerator(name string) (cypress.Generator, error) {
+	def, ok := r.plugins[name]
+	if !ok {
+		return nil, errors.Subject(ErrUnknownPlugin, name)
+	}
+
+	gp, ok := def.Plugin.(cypress.GeneratorPlugin)
+	if !ok {
+		return nil, errors.Subject(ErrInvalidGenerator, name)
+	}
+
+	gen, err := gp.Generator()
+	if err != nil {
+		return nil, errors.Subject(err, name)
+	}
+
+	if gen == nil {
+		return nil, errors.Subject(ErrInvalidGenerator, name)
+	}
+
+	return gen, nil
+}
+
+func (r *Router) newFilterer(name string) (cypress.Filterer, error) {
+	def, ok := r.plugins[name]
+	if !ok {
+		return nil, errors.Subject(ErrUnknownPlugin, name)
+	}
+
+	fp, ok := def.Plugin.(cypress.FiltererPlugin)
+	if !ok {
+		return nil, errors.Subject(ErrInvalidFilterer, name)
+	}
+
+	filt, err := fp.Filterer()
+	if err != nil {
+		return nil, errors.Subject(err, name)
+	}
+
+	if filt == nil {
+		return nil, errors.Subject(ErrInvalidFilterer, name)
+	}
+
+	return filt, nil
+}
+
+func (r *Router) newReceiver(name string) (cypress.Receiver, error) {
+	def, ok := r.plugins[name]
+	if !ok {
+		return nil, errors.Subject(ErrUnknownPlugin, name)
+	}
+
+	rp, ok := def.Plugin.(cypress.ReceiverPlugin)
+	if !ok {
+		return nil, errors.Subject(ErrInvalidReceiver, name)
+	}
+
+	recv, err := rp.Receiver()
+	if err != nil {
+		return nil, errors.Subject(err, name)
+	}
+
+	if recv == nil {
+		return nil, errors.Subject(ErrInvalidReceiver, name)
+	}
+
+	return recv, nil
+}
 
-			if recv == nil {
-				return errors.Subject(ErrInvalidReceiver, name)
+// The queue settings for an output, applying any per output settings
+// over the route's defaults.
+func (r *Route) queueConfig(name string) QueueConfig {
+	cfg := QueueConfig{
+		Size:     r.QueueSize,
+		Overflow: r.Overflow,
+		Spill:    r.Spill,
+	}
+
+	if oc, ok := r.queueConfigs[name]; ok {
+		if oc.Size != 0 {
+			cfg.Size = oc.Size
+		}
+
+		if oc.Overflow != "" {
+			cfg.Overflow = oc.Overflow
+		}
+
+		if oc.Spill != "" {
+			cfg.Spill = oc.Spill
+		}
+	}
+
+	return cfg
+}
+
+// Create the queue in front of one of the route's outputs, creating
+// the route's spill output if need be.
+func (r *Router) newQueue(route *Route, name string, recv cypress.Receiver) (*OutputQueue, error) {
+	cfg := route.queueConfig(name)
+
+	if cfg.Overflow != "" && !validOverflow(cfg.Overflow) {
+		return nil, errors.Subject(ErrUnknownOverflow, cfg.Overflow)
+	}
+
+	var spill cypress.Receiver
+
+	if cfg.Overflow == OverflowSpill {
+		if cfg.Spill == "" {
+			return nil, errors.Subject(ErrNoSpill, name)
+		}
+
+		// The spill gets it's own receiver, which would be a second
+		// writer to wherever the output of the same name writes
+		if contains(route.Output, cfg.Spill) {
+			return nil, errors.Subject(ErrSpillIsOutput, cfg.Spill)
+		}
+
+		spill = route.spills[cfg.Spill]
+
+		if spill == nil {
+			var err error
+
+			spill, err = r.newReceiver(cfg.Spill)
+			if err != nil {
+				return nil, err
 			}
 
-			route.receivers = append(route.receivers, recv)
+			route.spills[cfg.Spill] = spill
 		}
 	}
 
-	return nil
+	q := newOutputQueue(name, recv, cfg, spill)
+	q.when = route.when[name]
+	q.stats = route.stats[name]
+	q.deadLetter = route.deadLetter
+
+	return q, nil
 }
 
-func (r *Route) Flow() {
-	c := make(chan *cypress.Message, len(r.generators)*2)
+// Begin moving messages through the route
+func (r *Route) start() {
+	r.done = make(chan struct{})
+	r.feed = make(chan *cypress.Message, len(r.generators)*2)
+
+	for i, g := range r.generators {
+		r.startInput(r.Input[i], g)
+	}
+
+	go func() {
+		r.inputs.Wait()
+		close(r.feed)
+	}()
+
+	for _, q := range r.queues {
+		go q.run()
+	}
 
-	for _, g := range r.generators {
-		go func(g cypress.Generator) {
-			for {
-				msg, err := g.Generate()
-				if err != nil {
+	if r.deadLetter != nil {
+		go r.deadLetter.run()
+	}
+
+	go r.Flow()
+}
+
+// Read messages from g into the route until g returns an error
+func (r *Route) startInput(name string, g cypress.Generator) {
+	stats := r.stats[name]
+
+	r.inputs.Add(1)
+
+	go func() {
+		defer r.inputs.Done()
+
+		for {
+			msg, err := g.Generate()
+			if err != nil {
+				if err != io.EOF {
+					stats.recordError()
 					log.Printf("Error generating messages: %s", err)
-					return
 				}
 
-				c <- msg
+				return
 			}
-		}(g)
+
+			if msg == nil {
+				continue
+			}
+
+			stats.record(msg)
+
+			r.feed <- msg
+		}
+	}()
+}
+
+// Move messages from the route's inputs, through it's filters and
+// into the output queues. Flow returns once all the inputs have been
+// closed and the queues delivered everything.
+func (r *Route) Flow() {
+	defer close(r.done)
+
+	r.work()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, q := range r.queues {
+		q.stop()
 	}
 
-	for msg := range c {
-		for _, filt := range r.filters {
-			msg, err := filt.Filter(msg)
-			if err != nil {
-				log.Printf("Error filtering message: %s", err)
-				msg = nil
-				break
+	for _, q := range r.queues {
+		<-q.done
+	}
+
+	// The output queues feed the dead letter queue, so it's closed last
+	if r.deadLetter != nil {
+		r.deadLetter.stop()
+		<-r.deadLetter.done
+	}
+}
+
+// The number of workers processing the route's messages
+func (r *Route) workers() int {
+	if r.Workers <= 0 {
+		return 1
+	}
+
+	return r.Workers
+}
+
+// Process messages from the route's feed on it's workers, returning
+// once the feed is closed and every message has been processed.
+func (r *Route) work() {
+	workers := r.workers()
+
+	var wg sync.WaitGroup
+
+	process := func(feed <-chan *cypress.Message) {
+		defer wg.Done()
+
+		for msg := range feed {
+			r.lock.RLock()
+			pushes := r.process(msg)
+			r.lock.RUnlock()
+
+			// Pushing may wait on a full queue, so it's done without
+			// the lock, leaving the route free to be rewired
+			for _, p := range pushes {
+				p.q.Push(p.m)
 			}
+		}
+	}
 
-			if msg == nil {
-				break
+	wg.Add(workers)
+
+	if workers == 1 || !r.Ordered {
+		for i := 0; i < workers; i++ {
+			go process(r.feed)
+		}
+
+		wg.Wait()
+		return
+	}
+
+	// Each session is always handled by the same worker, keeping it's
+	// messages in order.
+	feeds := make([]chan *cypress.Message, workers)
+
+	for i := range feeds {
+		feeds[i] = make(chan *cypress.Message, 1)
+		go process(feeds[i])
+	}
+
+	var next int
+
+	for msg := range r.feed {
+		var i int
+
+		if msg.SessionId != nil {
+			h := fnv.New32a()
+			h.Write([]byte(*msg.SessionId))
+			i = int(h.Sum32() % uint32(workers))
+		} else {
+			// messages without a session have no order to keep
+			i = next
+			next = (next + 1) % workers
+		}
+
+		feeds[i] <- msg
+	}
+
+	for _, feed := range feeds {
+		close(feed)
+	}
+
+	wg.Wait()
+}
+
+// A message to push onto a queue
+type push struct {
+	q *OutputQueue
+	m *cypress.Message
+}
+
+// Filter msg, returning the queues it should be pushed onto. Must be
+// called with the lock held.
+func (r *Route) process(msg *cypress.Message) []push {
+	if r.matcher != nil && !r.matcher.Match(msg) {
+		return nil
+	}
+
+	// Filters may modify the message, so keep the original to send
+	// to the dead letter output.
+	orig := msg
+
+	if r.deadLetter != nil && len(r.filters) > 0 {
+		orig = copyMessage(msg)
+	}
+
+	var err error
+
+	for i, filt := range r.filters {
+		stats := r.stats[r.Filter[i]]
+		stats.record(msg)
+
+		msg, err = filt.Filter(msg)
+		if err != nil {
+			stats.recordError()
+			log.Printf("Error filtering message: %s", err)
+
+			if deadLettered(r.deadLetter, orig, r.Filter[i], err) {
+				return []push{{r.deadLetter, orig}}
 			}
+
+			return nil
 		}
 
 		if msg == nil {
+			return nil
+		}
+	}
+
+	var pushes []push
+
+	for _, q := range r.queues {
+		if q.when != nil && !q.when.Match(msg) {
 			continue
 		}
 
-		for _, recv := range r.receivers {
-			err := recv.Receive(msg)
-			if err != nil {
-				log.Printf("Error sending messages: %s", err)
-			}
+		pushes = append(pushes, push{q, msg})
+	}
+
+	// Outputs are free to change the messages they receive, so each
+	// gets it's own copy
+	for i := 1; i < len(pushes); i++ {
+		m := copyMessage(msg)
+		if m == nil {
+			log.Printf("Unable to copy message for output %s", pushes[i].q.Name)
+			continue
 		}
+
+		pushes[i].m = m
 	}
+
+	return pushes
 }
 
+// The queues in front of each of the route's outputs
+func (r *Route) Queues() []*OutputQueue {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.queues
+}
+
+// The total number of messages dropped across all outputs
+func (r *Route) Dropped() uint64 {
+	var total uint64
+
+	for _, q := range r.Queues() {
+		total += q.Dropped()
+	}
+
+	return total
+}
+
+// Shutdown the router, waiting up to DefaultShutdownTimeout for messages
+// to be delivered. Use Shutdown directly to control the timeout and see
+// what could not be delivered.
 func (r *Router) Close() error {
-	for _, route := range r.routes {
-		for _, g := range route.generators {
-			g.Close()
-		}
+	report := r.Shutdown(DefaultShutdownTimeout)
+
+	if len(report.Errors) > 0 {
+		return report.Errors[0]
 	}
 
 	return nil
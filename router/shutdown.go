@@ -0,0 +1,215 @@
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vektra/cypress"
+	"github.com/vektra/errors"
+)
+
+// How long Close waits for routes to drain
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Indicates an output was still delivering a message at the end of
+// Shutdown and so was not closed
+var ErrOutputBusy = errors.New("output busy, not closed")
+
+// Messages that were still queued for an output when the router
+// finished shutting down.
+type Undelivered struct {
+	Route  string
+	Output string
+	Count  int
+}
+
+func (u Undelivered) String() string {
+	return fmt.Sprintf("%s/%s: %d message(s) undelivered", u.Route, u.Output, u.Count)
+}
+
+// The outcome of shutting down a Router
+type ShutdownReport struct {
+	// Routes that did not drain before the deadline
+	TimedOut []string
+
+	// Outputs that still had messages queued
+	Undelivered []Undelivered
+
+	// Errors returned while closing inputs and flushing or
+	// closing outputs
+	Errors []error
+}
+
+// Indicates if every message was delivered and everything closed
+// without error.
+func (s *ShutdownReport) Clean() bool {
+	return len(s.TimedOut) == 0 && len(s.Undelivered) == 0 && len(s.Errors) == 0
+}
+
+// Stop the router in an orderly fashion. Inputs are closed first, then
+// each route is given until timeout to deliver the messages already in
+// it's pipeline. Finally, every output is flushed and closed. Anything
+// that could not be delivered is detailed in the returned report.
+// Calling Shutdown again returns the original report.
+func (r *Router) Shutdown(timeout time.Duration) *ShutdownReport {
+	r.shutdown.Do(func() {
+		r.shutdownInfo = r.runShutdown(timeout)
+	})
+
+	return r.shutdownInfo
+}
+
+func (r *Router) runShutdown(timeout time.Duration) *ShutdownReport {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	report := &ShutdownReport{}
+
+	if r.adminListener != nil {
+		r.adminListener.Close()
+	}
+
+	for _, route := range r.routes {
+		route.closeInputs(report)
+	}
+
+	deadline := time.After(timeout)
+
+	for _, route := range r.routes {
+		if route.done == nil {
+			continue
+		}
+
+		select {
+		case <-route.done:
+		case <-deadline:
+			// the deadline has passed, so don't wait on the rest
+			deadline = closedDeadline
+			report.TimedOut = append(report.TimedOut, route.Name)
+		}
+	}
+
+	for _, route := range r.routes {
+		route.closeOutputs(report)
+	}
+
+	return report
+}
+
+// Stop just this route, as Shutdown does for all routes
+func (r *Route) stop(timeout time.Duration) *ShutdownReport {
+	report := &ShutdownReport{}
+
+	r.closeInputs(report)
+
+	if r.done != nil {
+		select {
+		case <-r.done:
+		case <-time.After(timeout):
+			report.TimedOut = append(report.TimedOut, r.Name)
+		}
+	}
+
+	r.closeOutputs(report)
+
+	// Forget what was closed so that shutting down the router doesn't
+	// close it again
+	r.generators = nil
+	r.receivers = nil
+	r.spills = nil
+	r.deadLetterRecv = nil
+
+	return report
+}
+
+func (r *Route) closeInputs(report *ShutdownReport) {
+	for i, g := range r.generators {
+		err := g.Close()
+		if err != nil {
+			report.Errors = append(report.Errors, errors.Subject(err, r.Input[i]))
+		}
+	}
+}
+
+func (r *Route) closeOutputs(report *ShutdownReport) {
+	for _, q := range r.queues {
+		if cnt := q.Len(); cnt > 0 {
+			report.Undelivered = append(report.Undelivered, Undelivered{
+				Route:  r.Name,
+				Output: q.Name,
+				Count:  cnt,
+			})
+		}
+	}
+
+	// An output still working on a message can't be safely closed
+	// out from under it, so it's left alone.
+	busy := false
+
+	for i, recv := range r.receivers {
+		if r.done != nil {
+			select {
+			case <-r.queues[i].done:
+			default:
+				busy = true
+				report.Errors = append(report.Errors, errors.Subject(ErrOutputBusy, r.Output[i]))
+				continue
+			}
+		}
+
+		report.closeReceiver(r.Output[i], recv)
+	}
+
+	if dl := r.deadLetter; dl != nil && r.deadLetterRecv != nil {
+		if cnt := dl.Len(); cnt > 0 {
+			report.Undelivered = append(report.Undelivered, Undelivered{
+				Route:  r.Name,
+				Output: dl.Name,
+				Count:  cnt,
+			})
+		}
+
+		dlBusy := false
+
+		if r.done != nil {
+			select {
+			case <-dl.done:
+			default:
+				dlBusy = true
+				report.Errors = append(report.Errors, errors.Subject(ErrOutputBusy, dl.Name))
+			}
+		}
+
+		if !dlBusy {
+			report.closeReceiver(dl.Name, r.deadLetterRecv)
+		}
+	}
+
+	if busy {
+		return
+	}
+
+	for name, recv := range r.spills {
+		report.closeReceiver(name, recv)
+	}
+}
+
+var closedDeadline = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+func (s *ShutdownReport) closeReceiver(name string, recv cypress.Receiver) {
+	if f, ok := recv.(cypress.Flusher); ok {
+		err := f.Flush()
+		if err != nil {
+			s.Errors = append(s.Errors, errors.Subject(err, name))
+		}
+	}
+
+	err := recv.Close()
+	if err != nil {
+		s.Errors = append(s.Errors, errors.Subject(err, name))
+	}
+}
@@ -0,0 +1,182 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+type flushRecorder struct {
+	cypress.BufferReceiver
+
+	flushed bool
+	closed  bool
+}
+
+func (f *flushRecorder) Receiver() (cypress.Receiver, error) {
+	return f, nil
+}
+
+func (f *flushRecorder) Flush() error {
+	f.flushed = true
+	return nil
+}
+
+func (f *flushRecorder) Close() error {
+	f.closed = true
+	return nil
+}
+
+func init() {
+	cypress.AddPlugin("FlushRecorder", func() cypress.Plugin {
+		return &flushRecorder{}
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("delivers queued messages before closing outputs", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		r1 := r.routes["Default"]
+
+		in := r1.generators[0].(*cypress.TestPlugin)
+		out := r1.receivers[0].(*cypress.TestPlugin)
+
+		for i := 0; i < 5; i++ {
+			m := cypress.Log()
+			m.Add("iter", i)
+
+			in.Messages <- m
+		}
+
+		report := r.Shutdown(1 * time.Second)
+		assert.True(t, report.Clean())
+
+		var cnt int
+
+		for _ = range out.Messages {
+			cnt++
+		}
+
+		assert.Equal(t, 5, cnt)
+	})
+
+	n.It("flushes and closes outputs", func() {
+		testToml := `
+[input.Test]
+
+[output.FlushRecorder]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		in := r.routes["Default"].generators[0].(*cypress.TestPlugin)
+
+		in.Messages <- cypress.Log()
+
+		err = r.Close()
+		require.NoError(t, err)
+
+		out := r.plugins["output"].Plugin.(*flushRecorder)
+
+		assert.True(t, out.flushed)
+		assert.True(t, out.closed)
+		assert.Equal(t, 1, len(out.Messages))
+	})
+
+	n.It("reports messages that could not be delivered", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+queue_size = 5
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		in := r.routes["Default"].generators[0].(*cypress.TestPlugin)
+
+		// The output is never read from, so the output and it's queue
+		// back up.
+		for i := 0; i < 15; i++ {
+			in.Messages <- cypress.Log()
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		report := r.Shutdown(100 * time.Millisecond)
+		require.False(t, report.Clean())
+
+		assert.Equal(t, []string{"Default"}, report.TimedOut)
+
+		require.Equal(t, 1, len(report.Undelivered))
+		assert.Equal(t, "Default", report.Undelivered[0].Route)
+		assert.Equal(t, "output", report.Undelivered[0].Output)
+		assert.True(t, report.Undelivered[0].Count > 0)
+
+		require.Equal(t, 1, len(report.Errors))
+		assert.Contains(t, report.Errors[0].Error(), ErrOutputBusy.Error())
+	})
+
+	n.It("only shuts down once", func() {
+		testToml := `
+[in.Test]
+
+[out.Test]
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		report := r.Shutdown(1 * time.Second)
+		assert.True(t, report.Clean())
+
+		assert.Equal(t, report, r.Shutdown(1*time.Second))
+	})
+
+	n.Meow()
+}
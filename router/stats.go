@@ -0,0 +1,47 @@
+package router
+
+import (
+	"sync/atomic"
+
+	"github.com/vektra/cypress"
+)
+
+// Counters for the messages a plugin has handled, across all the
+// routes that use it.
+type PluginStats struct {
+	messages uint64
+	bytes    uint64
+	errors   uint64
+}
+
+func (s *PluginStats) record(m *cypress.Message) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.messages, 1)
+	atomic.AddUint64(&s.bytes, uint64(m.Size()))
+}
+
+func (s *PluginStats) recordError() {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.errors, 1)
+}
+
+// How many messages the plugin has handled
+func (s *PluginStats) Messages() uint64 {
+	return atomic.LoadUint64(&s.messages)
+}
+
+// The encoded size of the messages the plugin has handled
+func (s *PluginStats) Bytes() uint64 {
+	return atomic.LoadUint64(&s.bytes)
+}
+
+// How many errors the plugin has returned
+func (s *PluginStats) Errors() uint64 {
+	return atomic.LoadUint64(&s.errors)
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+func TestConditionalRouting(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("only passes messages that match the route", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+match = 'type == "metric"'
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		defer r.Shutdown(100 * time.Millisecond)
+
+		rt := r.routes["Default"]
+
+		in := rt.generators[0].(*cypress.TestPlugin)
+		out := rt.receivers[0].(*cypress.TestPlugin)
+
+		m := cypress.Metric()
+
+		in.Messages <- cypress.Log()
+		in.Messages <- m
+
+		select {
+		case m2 := <-out.Messages:
+			assert.Equal(t, m, m2)
+		case <-time.After(1 * time.Second):
+			t.Fatal("message did not flow through the router")
+		}
+	})
+
+	n.It("sends messages to the outputs whose when matches", func() {
+		testToml := `
+[input.Test]
+
+[metrics.Test]
+
+[audit.Test]
+
+[all.Test]
+
+[route.Default]
+input = ["input"]
+output = ["metrics", "audit", "all"]
+
+[route.Default.when]
+metrics = 'type == "metric"'
+audit = 'type == "audit"'
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+
+		rt := r.routes["Default"]
+
+		in := rt.generators[0].(*cypress.TestPlugin)
+
+		in.Messages <- cypress.Metric()
+		in.Messages <- cypress.Audit()
+		in.Messages <- cypress.Log()
+
+		report := r.Shutdown(1 * time.Second)
+		require.True(t, report.Clean())
+
+		types := func(name string) []string {
+			var out []string
+
+			for m := range r.plugins[name].Plugin.(*cypress.TestPlugin).Messages {
+				out = append(out, m.StringType())
+			}
+
+			return out
+		}
+
+		assert.Equal(t, []string{"metric"}, types("metrics"))
+		assert.Equal(t, []string{"audit"}, types("audit"))
+		assert.Equal(t, []string{"metric", "audit", "log"}, types("all"))
+	})
+
+	n.It("rejects routes with a bad match expression", func() {
+		testToml := `
+[input.Test]
+
+[output.Test]
+
+[route.Default]
+input = ["input"]
+output = ["output"]
+
+[route.Default.when]
+output = 'type =='
+`
+
+		r := NewRouter()
+		err := r.LoadConfig(strings.NewReader(testToml))
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,199 @@
+package router
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/cypress"
+	"github.com/vektra/neko"
+)
+
+// A filter that sleeps for the message's delay attribute, tracking
+// how many messages it's filtering at once.
+type slowFilter struct {
+	active, most int64
+}
+
+func (s *slowFilter) Filterer() (cypress.Filterer, error) {
+	return s, nil
+}
+
+func (s *slowFilter) Filter(m *cypress.Message) (*cypress.Message, error) {
+	active := atomic.AddInt64(&s.active, 1)
+	defer atomic.AddInt64(&s.active, -1)
+
+	for {
+		most := atomic.LoadInt64(&s.most)
+		if active <= most || atomic.CompareAndSwapInt64(&s.most, most, active) {
+			break
+		}
+	}
+
+	if delay, ok := m.GetInt("delay"); ok {
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+
+	return m, nil
+}
+
+func init() {
+	cypress.AddPlugin("Slow", func() cypress.Plugin {
+		return &slowFilter{}
+	})
+}
+
+func TestWorkers(t *testing.T) {
+	n := neko.Start(t)
+
+	var r *Router
+
+	n.Setup(func() {
+		r = NewRouter()
+	})
+
+	n.Cleanup(func() {
+		r.Shutdown(100 * time.Millisecond)
+	})
+
+	open := func(cfg string) {
+		err := r.LoadConfig(strings.NewReader(cfg))
+		require.NoError(t, err)
+
+		err = r.Open()
+		require.NoError(t, err)
+	}
+
+	send := func(session string, seq, delay int64) {
+		m := cypress.Log()
+		m.Add("seq", seq)
+		m.Add("delay", delay)
+
+		if session != "" {
+			m.SessionId = proto.String(session)
+		}
+
+		r.routes["Default"].generators[0].(*cypress.TestPlugin).Messages <- m
+	}
+
+	recv := func(count int) []*cypress.Message {
+		out := r.routes["Default"].receivers[0].(*cypress.TestPlugin)
+
+		var msgs []*cypress.Message
+
+		for i := 0; i < count; i++ {
+			select {
+			case m := <-out.Messages:
+				msgs = append(msgs, m)
+			case <-time.After(1 * time.Second):
+				t.Fatal("message did not flow through the router")
+			}
+		}
+
+		return msgs
+	}
+
+	n.It("filters messages in parallel", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[slow.Slow]
+
+[route.Default]
+input = ["input"]
+filter = ["slow"]
+output = ["output"]
+workers = 4
+`)
+
+		for i := int64(0); i < 8; i++ {
+			send("", i, 20)
+		}
+
+		recv(8)
+
+		slow := r.plugins["slow"].Plugin.(*slowFilter)
+
+		assert.True(t, atomic.LoadInt64(&slow.most) > 1)
+	})
+
+	n.It("filters messages one at a time by default", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[slow.Slow]
+
+[route.Default]
+input = ["input"]
+filter = ["slow"]
+output = ["output"]
+`)
+
+		for i := int64(0); i < 4; i++ {
+			send("", i, 5)
+		}
+
+		recv(4)
+
+		slow := r.plugins["slow"].Plugin.(*slowFilter)
+
+		assert.Equal(t, int64(1), atomic.LoadInt64(&slow.most))
+	})
+
+	n.It("keeps each session in order", func() {
+		open(`
+[input.Test]
+
+[output.Test]
+
+[slow.Slow]
+
+[route.Default]
+input = ["input"]
+filter = ["slow"]
+output = ["output"]
+workers = 4
+ordered = true
+`)
+
+		// Earlier messages are slower, so they'd be overtaken if the
+		// session was spread across workers.
+		for i := int64(0); i < 4; i++ {
+			send("a", i, 20-5*i)
+			send("b", i, 20-5*i)
+		}
+
+		seen := map[string]int64{"a": -1, "b": -1}
+
+		for _, m := range recv(8) {
+			seq, ok := m.GetInt("seq")
+			require.True(t, ok)
+
+			session := m.GetSessionId()
+
+			assert.Equal(t, seen[session]+1, seq, "session %s out of order", session)
+			seen[session] = seq
+		}
+	})
+
+	n.It("rejects a negative worker count", func() {
+		err := r.LoadConfig(strings.NewReader(`
+[route.Default]
+input = ["input"]
+output = ["output"]
+workers = -1
+`))
+
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
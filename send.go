@@ -1,11 +1,16 @@
 package cypress
 
 import (
+	"bufio"
 	"container/list"
+	"crypto/ecdsa"
 	"errors"
 	"io"
 	"sync"
 	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/vektra/cypress/keystore"
 )
 
 // A type use to send a stream of Messages reliably. This type works in
@@ -23,6 +28,21 @@ type Send struct {
 	available int32
 	reqs      *list.List
 
+	// Set when using the sequenced protocol. Messages are numbered by
+	// session and the in flight messages are indexed by number. acks is
+	// also set for a handshake, which is read through it.
+	session  *SendSession
+	acks     *bufio.Reader
+	inflight map[uint64]*list.Element
+
+	// Set to authenticate with during the handshake
+	authKey *ecdsa.PrivateKey
+
+	compression StreamHeader_Compression
+
+	// Set to let the remote side pick the compression from these
+	offers []StreamHeader_Compression
+
 	ackLock sync.Mutex
 	ackCond *sync.Cond
 }
@@ -81,6 +101,96 @@ var (
 // the memory usage and throughput. Fast lans only require a small window
 // because there is a very small transmission delay.
 func NewSend(rw io.ReadWriteCloser, window int) *Send {
+	s := newSend(rw, window)
+
+	go s.backgroundAck()
+
+	return s
+}
+
+// Create a new Send that uses the sequenced reliable protocol, numbering
+// messages with session. Pass the same session to each Send used for a
+// stream of messages so that messages resent on a new connection keep
+// their number. If session is nil, a new one is used. The remote side
+// must understand the sequenced protocol, which Recv does.
+func NewSequencedSend(rw io.ReadWriteCloser, window int, session *SendSession) *Send {
+	s := newSequencedSend(rw, window, session)
+
+	go s.backgroundAck()
+
+	return s
+}
+
+// Create a Send like NewSequencedSend that proves to the remote side
+// that it holds key during SendHandshake. The remote side must be a
+// Recv that understands authentication. SendHandshake must be called
+// before sending, acks aren't read until it succeeds.
+func NewAuthSend(rw io.ReadWriteCloser, window int, session *SendSession, key *ecdsa.PrivateKey) *Send {
+	return NewSendOptions(rw, window, SendOptions{Session: session, AuthKey: key})
+}
+
+// Settings for a Send using the sequenced protocol
+type SendOptions struct {
+	// Number messages with this session, a new one if nil
+	Session *SendSession
+
+	// Prove to the remote side that the Send holds this key
+	AuthKey *ecdsa.PrivateKey
+
+	// Offer these compressions to the remote side, in order of
+	// preference. The remote side picks one, or none if it accepts
+	// none of them.
+	Compressions []StreamHeader_Compression
+
+	// The settings for the compression used
+	CompressionOptions *CompressionOptions
+
+	// Use the original protocol like NewSend, for remote sides that
+	// don't understand the sequenced one. Session is ignored.
+	Legacy bool
+}
+
+// Create a Send like NewSequencedSend configured by opts. If opts has
+// an AuthKey or Compressions, the remote side must be a Recv that
+// understands them and SendHandshake must be called before sending,
+// acks aren't read until it succeeds.
+func NewSendOptions(rw io.ReadWriteCloser, window int, opts SendOptions) *Send {
+	var s *Send
+
+	if opts.Legacy {
+		s = newSend(rw, window)
+
+		// the handshake is read through it, so acks must be too
+		s.acks = bufio.NewReader(rw)
+	} else {
+		s = newSequencedSend(rw, window, opts.Session)
+	}
+	s.authKey = opts.AuthKey
+	s.offers = opts.Compressions
+	s.enc.Options = opts.CompressionOptions
+
+	if s.authKey == nil && len(s.offers) == 0 {
+		go s.backgroundAck()
+	}
+
+	return s
+}
+
+func newSequencedSend(rw io.ReadWriteCloser, window int, session *SendSession) *Send {
+	s := newSend(rw, window)
+
+	if session == nil {
+		session = NewSendSession()
+	}
+
+	s.session = session
+	s.acks = bufio.NewReader(rw)
+	s.inflight = make(map[uint64]*list.Element)
+
+	return s
+}
+
+func newSend(rw io.ReadWriteCloser, window int) *Send {
 	switch window {
 	case -1:
 		window = 1
@@ -99,26 +209,89 @@ func NewSend(rw io.ReadWriteCloser, window int) *Send {
 
 	s.ackCond = sync.NewCond(&s.ackLock)
 
-	go s.backgroundAck()
-
 	return s
 }
 
+// Compress the stream with comp, using opts for it's settings if
+// they're not nil. Must be called before SendHandshake.
+func (s *Send) SetCompression(comp StreamHeader_Compression, opts *CompressionOptions) {
+	s.compression = comp
+	s.enc.Options = opts
+}
+
 // Send the start of a stream to the remote side. This will initialize
-// the stream to use Snappy for compression and reliable transmission.
+// the stream to use the compression set by SetCompression (none by
+// default) and reliable transmission.
+// If the Send has an auth key, the remote side's challenge is answered
+// before returning. If it has compressions to offer, the stream uses
+// the one the remote side picks instead.
 func (s *Send) SendHandshake() error {
 	hdr := &StreamHeader{
-		Compression: NONE.Enum(),
+		Compression: s.compression.Enum(),
 		Mode:        StreamHeader_RELIABLE.Enum(),
 	}
 
-	return s.enc.WriteCustomHeader(hdr)
+	if s.session != nil {
+		hdr.Version = proto.Uint32(ReliableSequenced)
+		hdr.SenderId = proto.String(s.session.ID)
+	}
+
+	if s.authKey == nil && len(s.offers) == 0 {
+		return s.enc.WriteCustomHeader(hdr)
+	}
+
+	if s.authKey != nil {
+		hdr.AuthKeyId = proto.String(keystore.KeyId(&s.authKey.PublicKey))
+	}
+
+	if len(s.offers) > 0 {
+		// The header itself is never compressed, the pick is used
+		// after it.
+		hdr.Compression = NONE.Enum()
+		hdr.OfferCompression = s.offers
+	}
+
+	err := s.enc.writeHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	if s.authKey != nil {
+		err = s.authenticate()
+		if err != nil {
+			return err
+		}
+	}
+
+	comp := s.compression
+
+	if len(s.offers) > 0 {
+		comp, err = s.negotiate()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.compression = comp
+	s.enc.startCompression(comp)
+
+	go s.backgroundAck()
+
+	return nil
 }
 
 // Send the Message. If there is an error, nack the message so it can
 // be sent again later.
 func (s *Send) transmit(m *Message) error {
-	err := s.enc.Receive(m)
+	return s.transmitted(s.enc.Receive(m))
+}
+
+// Send the Message along with it's sequence number
+func (s *Send) transmitSequenced(seq uint64, m *Message) error {
+	return s.transmitted(s.enc.ReceiveSequenced(seq, m))
+}
+
+func (s *Send) transmitted(err error) error {
 	if err != nil {
 		s.sendNacks()
 		return ErrClosed
@@ -136,11 +309,26 @@ var ErrStreamUnsynced = errors.New("stream unsynced")
 type sendInFlight struct {
 	req SendRequest
 	m   *Message
+	seq uint64
 }
 
 // Read any acks from the stream and remove them from the requests list.
 func (s *Send) readAck() error {
-	n, err := s.rw.Read(s.buf)
+	if s.session != nil {
+		return s.readSequencedAck()
+	}
+
+	var (
+		n   int
+		err error
+	)
+
+	if s.acks != nil {
+		n, err = s.acks.Read(s.buf)
+	} else {
+		n, err = s.rw.Read(s.buf)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -173,6 +361,64 @@ func (s *Send) readAck() error {
 	return nil
 }
 
+// Read an ack of ranges of sequence numbers and ack the in flight
+// messages with those numbers. Numbers of messages not in flight,
+// which were acked already, are ignored.
+func (s *Send) readSequencedAck() error {
+	ranges, err := readAckFrame(s.acks)
+	if err != nil {
+		return err
+	}
+
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+
+	var acked int32
+
+	for _, r := range ranges {
+		// Don't walk a range bigger than what's in flight
+		if r.end-r.start >= uint64(len(s.inflight)) {
+			for seq := range s.inflight {
+				if seq >= r.start && seq <= r.end && s.ackSequence(seq) {
+					acked++
+				}
+			}
+
+			continue
+		}
+
+		for seq := r.start; seq <= r.end; seq++ {
+			if s.ackSequence(seq) {
+				acked++
+			}
+		}
+	}
+
+	s.available += acked
+	s.ackCond.Signal()
+
+	return nil
+}
+
+// Ack the in flight message numbered seq, if there is one
+func (s *Send) ackSequence(seq uint64) bool {
+	e, ok := s.inflight[seq]
+	if !ok {
+		return false
+	}
+
+	delete(s.inflight, seq)
+	s.reqs.Remove(e)
+
+	inf := e.Value.(sendInFlight)
+
+	if inf.req != nil {
+		inf.req.Ack(inf.m)
+	}
+
+	return true
+}
+
 // Tell the sender about all the messages that it was not able to get
 // acks about and thus should be resent.
 func (s *Send) sendNacks() {
@@ -182,6 +428,11 @@ func (s *Send) sendNacks() {
 
 	for e := s.reqs.Back(); e != nil; e = e.Prev() {
 		if inf, ok := e.Value.(sendInFlight); ok {
+			// only a message someone will resend keeps it's number
+			if s.session != nil && inf.req != nil {
+				s.session.nack(inf.m, inf.seq)
+			}
+
 			if inf.req != nil {
 				inf.req.Nack(inf.m)
 			}
@@ -235,11 +486,28 @@ func (s *Send) Send(m *Message, req SendRequest) error {
 		return ErrClosed
 	}
 
-	s.reqs.PushFront(sendInFlight{req, m})
+	inf := sendInFlight{req: req, m: m}
+
+	if s.session != nil {
+		inf.seq = s.session.sequence(m)
+	}
+
+	e := s.reqs.PushFront(inf)
+
+	if s.inflight != nil {
+		s.inflight[inf.seq] = e
+	}
 
 	s.available--
 
-	err := s.transmit(m)
+	var err error
+
+	if s.session != nil {
+		err = s.transmitSequenced(inf.seq, m)
+	} else {
+		err = s.transmit(m)
+	}
+
 	if err != nil {
 		return err
 	}
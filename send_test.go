@@ -317,5 +317,158 @@ func TestSend(t *testing.T) {
 		require.Equal(t, ErrClosed, err)
 	})
 
+	n.It("sends the version and sender id in the sequenced handshake", func() {
+		db := newDualBuffer()
+
+		session := NewSendSession()
+
+		s := NewSequencedSend(db, NoWindow, session)
+
+		err := s.SendHandshake()
+		require.NoError(t, err)
+
+		_, err = db.write.ReadByte()
+		require.NoError(t, err)
+
+		var hdr StreamHeader
+
+		err = hdr.UnmarshalFrom(db.write)
+		require.NoError(t, err)
+
+		assert.Equal(t, StreamHeader_RELIABLE, hdr.GetMode())
+		assert.Equal(t, ReliableSequenced, hdr.GetVersion())
+		assert.Equal(t, session.ID, hdr.GetSenderId())
+	})
+
+	n.It("sends the original handshake when asked to", func() {
+		db := newDualBuffer()
+
+		s := NewSendOptions(db, NoWindow, SendOptions{Legacy: true})
+
+		err := s.SendHandshake()
+		require.NoError(t, err)
+
+		_, err = db.write.ReadByte()
+		require.NoError(t, err)
+
+		var hdr StreamHeader
+
+		err = hdr.UnmarshalFrom(db.write)
+		require.NoError(t, err)
+
+		assert.Equal(t, StreamHeader_RELIABLE, hdr.GetMode())
+		assert.Equal(t, ReliableLegacy, hdr.GetVersion())
+		assert.Equal(t, "", hdr.GetSenderId())
+	})
+
+	n.It("forgets the numbers of messages no one will resend", func() {
+		send, recv := newPair()
+		defer send.Close()
+
+		session := NewSendSession()
+
+		s := NewSequencedSend(send, 0, session)
+
+		err := s.Send(Log(), nil)
+		require.NoError(t, err)
+
+		err = recv.Close()
+		require.NoError(t, err)
+
+		// let backgroundAck routine detect the error
+		time.Sleep(100 * time.Millisecond)
+
+		session.lock.Lock()
+		defer session.lock.Unlock()
+
+		assert.Equal(t, 0, len(session.nacked))
+	})
+
+	n.It("acks sequenced messages in any order", func() {
+		send, recv := newPair()
+		defer send.Close()
+		defer recv.Close()
+
+		s := NewSequencedSend(send, 0, nil)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		m2 := Log()
+		m2.Add("message", "logs are fun")
+
+		ack.On("Ack", m2).Return(nil)
+		ack.On("Ack", m).Return(nil)
+
+		err := s.Send(m, &ack)
+		require.NoError(t, err)
+
+		err = s.Send(m2, &ack)
+		require.NoError(t, err)
+
+		err = writeAckFrame(recv, []seqRange{{2, 2}})
+		require.NoError(t, err)
+
+		// acking a number twice is ignored
+		err = writeAckFrame(recv, []seqRange{{1, 2}})
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+
+		s.ackLock.Lock()
+		defer s.ackLock.Unlock()
+
+		assert.Equal(t, int32(s.window), s.available)
+		assert.Equal(t, 0, s.reqs.Len())
+	})
+
+	n.It("resends nacked messages with the same number", func() {
+		send, recv := newPair()
+		defer send.Close()
+
+		session := NewSendSession()
+
+		s := NewSequencedSend(send, 0, session)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		ack.On("Nack", m).Return(nil)
+
+		err := s.Send(m, &ack)
+		require.NoError(t, err)
+
+		err = recv.Close()
+		require.NoError(t, err)
+
+		// let backgroundAck routine detect the error
+		time.Sleep(100 * time.Millisecond)
+
+		send2, recv2 := newPair()
+		defer send2.Close()
+		defer recv2.Close()
+
+		s2 := NewSequencedSend(send2, 0, session)
+
+		err = s2.SendHandshake()
+		require.NoError(t, err)
+
+		err = s2.Send(m, nil)
+		require.NoError(t, err)
+
+		err = s2.Flush()
+		require.NoError(t, err)
+
+		r, err := NewRecv(recv2)
+		require.NoError(t, err)
+
+		m2, err := r.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, m, m2)
+		assert.Equal(t, uint64(1), r.Sequence())
+		assert.Equal(t, session.ID, r.SenderID())
+	})
+
 	n.Meow()
 }
@@ -0,0 +1,191 @@
+package cypress
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Versions of the reliable protocol, sent in the StreamHeader
+const (
+	// Each message is acked with a single byte, in the order they
+	// were sent
+	ReliableLegacy uint32 = 0
+
+	// Each message carries a sequence number and acks are ranges
+	// of sequence numbers, so the two sides can't become unsynced and
+	// a message resent after a reconnect can be recognized.
+	ReliableSequenced uint32 = 1
+)
+
+// Tracks the sequence numbers a sender has given messages. A
+// SendSession outlives any one connection, so a message that was
+// nacked keeps it's number when it's resent on a new connection.
+type SendSession struct {
+	// Identifies the sender to the remote side
+	ID string
+
+	lock sync.Mutex
+	next uint64
+
+	// the numbers of messages nacked and not yet resent
+	nacked map[*Message]uint64
+}
+
+// Create a SendSession with a random ID
+func NewSendSession() *SendSession {
+	var buf [16]byte
+
+	_, err := io.ReadFull(rand.Reader, buf[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return &SendSession{
+		ID:     hex.EncodeToString(buf[:]),
+		next:   1,
+		nacked: make(map[*Message]uint64),
+	}
+}
+
+// The sequence number to send m with, the number it was sent with
+// before if it was nacked, otherwise the next number.
+func (s *SendSession) sequence(m *Message) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if seq, ok := s.nacked[m]; ok {
+		delete(s.nacked, m)
+		return seq
+	}
+
+	seq := s.next
+	s.next++
+
+	return seq
+}
+
+// Remember the number of m, which was nacked and will be resent
+func (s *SendSession) nack(m *Message, seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nacked[m] = seq
+}
+
+// Forget the numbers of the nacked messages, which won't be resent
+func (s *SendSession) clearNacked() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nacked = make(map[*Message]uint64)
+}
+
+// An inclusive range of sequence numbers
+type seqRange struct {
+	start, end uint64
+}
+
+// Collapse seqs into ranges of consecutive numbers
+func seqRanges(seqs []uint64) []seqRange {
+	sort.Sort(uint64s(seqs))
+
+	var ranges []seqRange
+
+	for _, seq := range seqs {
+		if l := len(ranges); l > 0 && seq <= ranges[l-1].end+1 {
+			if seq > ranges[l-1].end {
+				ranges[l-1].end = seq
+			}
+
+			continue
+		}
+
+		ranges = append(ranges, seqRange{seq, seq})
+	}
+
+	return ranges
+}
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+
+// Starts an ack in the sequenced protocol
+const ackFrameByte = 'a'
+
+// The most ranges a single ack may carry
+const maxAckRanges = 1 << 16
+
+// Write an ack of the sequence numbers in ranges. The ack is the
+// ackFrameByte, the number of ranges, then the start of each range
+// and how many numbers follow it, all as uvarints.
+func writeAckFrame(w io.Writer, ranges []seqRange) error {
+	buf := make([]byte, 1, 1+(len(ranges)*2+1)*binary.MaxVarintLen64)
+
+	buf[0] = ackFrameByte
+
+	var tmp [binary.MaxVarintLen64]byte
+
+	cnt := binary.PutUvarint(tmp[:], uint64(len(ranges)))
+	buf = append(buf, tmp[:cnt]...)
+
+	for _, r := range ranges {
+		cnt = binary.PutUvarint(tmp[:], r.start)
+		buf = append(buf, tmp[:cnt]...)
+
+		cnt = binary.PutUvarint(tmp[:], r.end-r.start)
+		buf = append(buf, tmp[:cnt]...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// Read an ack written by writeAckFrame
+func readAckFrame(r io.ByteReader) ([]seqRange, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if b != ackFrameByte {
+		return nil, ErrStreamUnsynced
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > maxAckRanges {
+		return nil, ErrStreamUnsynced
+	}
+
+	ranges := make([]seqRange, n)
+
+	for i := range ranges {
+		start, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if start+length < start {
+			return nil, ErrStreamUnsynced
+		}
+
+		ranges[i] = seqRange{start, start + length}
+	}
+
+	return ranges, nil
+}
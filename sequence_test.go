@@ -0,0 +1,80 @@
+package cypress
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSequence(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("collapses sequence numbers into ranges", func() {
+		ranges := seqRanges([]uint64{7, 1, 2, 3, 5, 6, 9, 3})
+
+		assert.Equal(t, []seqRange{{1, 3}, {5, 7}, {9, 9}}, ranges)
+	})
+
+	n.It("writes and reads acks", func() {
+		var buf bytes.Buffer
+
+		ranges := []seqRange{{1, 1}, {3, 300}, {1 << 40, 1<<40 + 5}}
+
+		err := writeAckFrame(&buf, ranges)
+		require.NoError(t, err)
+
+		assert.Equal(t, byte(ackFrameByte), buf.Bytes()[0])
+
+		ranges2, err := readAckFrame(bufio.NewReader(&buf))
+		require.NoError(t, err)
+
+		assert.Equal(t, ranges, ranges2)
+	})
+
+	n.It("detects a stream that isn't acks", func() {
+		_, err := readAckFrame(bufio.NewReader(bytes.NewReader([]byte("k"))))
+		assert.Equal(t, ErrStreamUnsynced, err)
+	})
+
+	n.It("numbers messages in order", func() {
+		s := NewSendSession()
+
+		assert.Equal(t, uint64(1), s.sequence(Log()))
+		assert.Equal(t, uint64(2), s.sequence(Log()))
+	})
+
+	n.It("keeps the number of nacked messages", func() {
+		s := NewSendSession()
+
+		m := Log()
+
+		seq := s.sequence(m)
+		s.sequence(Log())
+
+		s.nack(m, seq)
+
+		assert.Equal(t, seq, s.sequence(m))
+		assert.Equal(t, uint64(3), s.sequence(m))
+	})
+
+	n.It("forgets the numbers of nacked messages when cleared", func() {
+		s := NewSendSession()
+
+		m := Log()
+
+		s.nack(m, s.sequence(m))
+		s.clearNacked()
+
+		assert.Equal(t, uint64(2), s.sequence(m))
+	})
+
+	n.It("gives each session it's own id", func() {
+		assert.NotEqual(t, NewSendSession().ID, NewSendSession().ID)
+	})
+
+	n.Meow()
+}
@@ -9,6 +9,10 @@ type StreamDecoder struct {
 	dec  *Decoder
 
 	Header *StreamHeader
+
+	// Settings for the stream's compression, such as a zstd dictionary.
+	// Set before the first Generate.
+	Options *CompressionOptions
 }
 
 // Create a new StreamDecoder from the data in r
@@ -16,11 +20,23 @@ func NewStreamDecoder(r io.Reader) (*StreamDecoder, error) {
 	return &StreamDecoder{r: r, dec: NewDecoder(r)}, nil
 }
 
+// Create a StreamDecoder for the rest of the stream p has probed
+func newProbedStreamDecoder(p *Probe) *StreamDecoder {
+	return &StreamDecoder{
+		r:       p.r,
+		init:    true,
+		dec:     NewDecoder(p.Reader()),
+		Header:  p.Header,
+		Options: p.Options,
+	}
+}
+
 // Probe the stream and setup the decoder to read Messages
 func (s *StreamDecoder) Probe() error {
 	s.init = true
 
 	probe := NewProbe(s.r)
+	probe.Options = s.Options
 
 	err := probe.Probe()
 	if err != nil {
@@ -47,6 +63,18 @@ func (s *StreamDecoder) Generate() (*Message, error) {
 	return s.dec.Decode()
 }
 
+// The sequence number of the last Message read, if the stream
+// numbers it's messages
+func (s *StreamDecoder) Sequence() uint64 {
+	return s.dec.Sequence
+}
+
+// How many bytes of the stream, after the header and decompressed, the
+// native messages read so far took up
+func (s *StreamDecoder) Offset() int64 {
+	return s.dec.Offset
+}
+
 // To satisify the Generator interface
 func (s *StreamDecoder) Close() error {
 	return nil
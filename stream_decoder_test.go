@@ -39,5 +39,40 @@ func TestStreamDecoder(t *testing.T) {
 		assert.Equal(t, m, m2)
 	})
 
+	n.It("tracks how much of the stream the messages took up", func() {
+		var buf ByteBuffer
+
+		se := NewStreamEncoder(&buf)
+
+		err := se.Init(SNAPPY)
+		require.NoError(t, err)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		for i := 0; i < 2; i++ {
+			err = se.Receive(m)
+			require.NoError(t, err)
+		}
+
+		err = se.Close()
+		require.NoError(t, err)
+
+		sd, err := NewStreamDecoder(&buf)
+		require.NoError(t, err)
+
+		_, err = sd.Generate()
+		require.NoError(t, err)
+
+		size := int64(1 + sovLog(uint64(m.Size())) + m.Size())
+
+		assert.Equal(t, size, sd.Offset())
+
+		_, err = sd.Generate()
+		require.NoError(t, err)
+
+		assert.Equal(t, 2*size, sd.Offset())
+	})
+
 	n.Meow()
 }
@@ -27,7 +27,14 @@ type StreamEncoder struct {
 	encoded uint64
 	lock    sync.Mutex
 
+	// precede messages with checksums, as the header says
+	checksums bool
+
 	t tomb.Tomb
+
+	// Settings for the stream's compression, nil for the defaults. Set
+	// before the header is written.
+	Options *CompressionOptions
 }
 
 // Create a new StreamEncoder sending data to w. The format is either the
@@ -69,6 +76,21 @@ func (s *StreamEncoder) flushTimer() error {
 
 // Write a StreamHeader
 func (s *StreamEncoder) WriteCustomHeader(hdr *StreamHeader) error {
+	err := s.writeHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	s.startCompression(hdr.GetCompression())
+
+	return nil
+}
+
+// Write hdr without setting up the encoder for the rest of the stream,
+// for when the compression is agreed on after the header
+func (s *StreamEncoder) writeHeader(hdr *StreamHeader) error {
+	s.checksums = hdr.GetChecksums()
+
 	_, err := s.w.Write(StreamNotifyByte)
 	if err != nil {
 		return err
@@ -85,20 +107,22 @@ func (s *StreamEncoder) WriteCustomHeader(hdr *StreamHeader) error {
 	}
 
 	_, err = s.w.Write(data)
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	s.ew = WriteCompressed(s.w, hdr.GetCompression())
+// Setup the encoder to write the rest of the stream with comp
+func (s *StreamEncoder) startCompression(comp StreamHeader_Compression) {
+	s.ew = WriteCompressedOptions(s.w, comp, s.Options)
 
 	if f, ok := s.ew.(Flusher); ok {
 		s.flush = f
 		s.t.Go(s.flushTimer)
 	}
 
-	s.enc = NewEncoder(s.ew)
+	enc := NewEncoder(s.ew)
+	enc.Checksums = s.checksums
 
-	return nil
+	s.enc = enc
 }
 
 // Probe the file and setup the encoder to match the probe's
@@ -111,14 +135,9 @@ func (s *StreamEncoder) OpenFile(f *os.File) error {
 		return err
 	}
 
-	s.ew = WriteCompressed(s.w, probe.Compression())
-
-	if f, ok := s.ew.(Flusher); ok {
-		s.flush = f
-		s.t.Go(s.flushTimer)
-	}
+	s.checksums = probe.Header.GetChecksums()
 
-	s.enc = NewEncoder(s.ew)
+	s.startCompression(probe.Compression())
 
 	_, err = f.Seek(0, os.SEEK_END)
 	return err
@@ -137,6 +156,28 @@ func (s *StreamEncoder) Receive(m *Message) error {
 	return err
 }
 
+type sequencedEncoder interface {
+	EncodeSequenced(seq uint64, m *Message) (uint64, error)
+}
+
+// Take a Message and encode it along with it's sequence number
+func (s *StreamEncoder) ReceiveSequenced(seq uint64, m *Message) error {
+	enc, ok := s.enc.(sequencedEncoder)
+	if !ok {
+		return ErrNotSequenced
+	}
+
+	s.lock.Lock()
+
+	cnt, err := enc.EncodeSequenced(seq, m)
+
+	s.encoded += cnt
+
+	s.lock.Unlock()
+
+	return err
+}
+
 func (s *StreamEncoder) Close() error {
 	if s.flush != nil {
 		s.t.Kill(nil)
@@ -131,3 +131,58 @@ func TestStreamEncoder(t *testing.T) {
 
 	n.Meow()
 }
+
+func TestStreamEncoderChecksums(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("keeps checksumming messages when appending to a file", func() {
+		f, err := ioutil.TempFile("", "cypress")
+		require.NoError(t, err)
+
+		defer os.Remove(f.Name())
+
+		checksums := true
+
+		se := NewStreamEncoder(f)
+
+		err = se.WriteCustomHeader(&StreamHeader{Checksums: &checksums})
+		require.NoError(t, err)
+
+		err = se.Close()
+		require.NoError(t, err)
+
+		_, err = f.Seek(0, os.SEEK_SET)
+		require.NoError(t, err)
+
+		se = NewStreamEncoder(f)
+
+		err = se.OpenFile(f)
+		require.NoError(t, err)
+
+		m := Log()
+		m.Add("hello", "world")
+
+		err = se.Receive(m)
+		require.NoError(t, err)
+
+		err = se.Close()
+		require.NoError(t, err)
+
+		_, err = f.Seek(0, os.SEEK_SET)
+		require.NoError(t, err)
+
+		probe := NewProbe(f)
+
+		err = probe.Probe()
+		require.NoError(t, err)
+
+		assert.True(t, probe.Header.GetChecksums())
+
+		data, err := ioutil.ReadAll(probe.Reader())
+		require.NoError(t, err)
+
+		assert.Equal(t, byte('!'), data[0])
+	})
+
+	n.Meow()
+}